@@ -60,11 +60,15 @@ func main() {
 	var kubeConfig string
 	var disableTLS bool
 	var skipChkSumVerify bool
+	var validateDir string
+	var traceAddress string
 
 	flag.StringVar(&masterURL, "master", "", "overrides the address of the Kubernetes API server in the kubeconfig file (only required if out-of-cluster)")
 	flag.Var(&triggerURL, "triggerURL", "set to override the trigger directory")
 	flag.BoolVar(&disableTLS, "disableTLS", false, "set to use non-TLS listener and listen on port 9080")
 	flag.BoolVar(&skipChkSumVerify, "skipChecksumVerify", false, "set to skip the verification of the trigger collection checksum")
+	flag.StringVar(&traceAddress, "traceAddress", "127.0.0.1:9081", "address of the listener serving evaluation traces, or empty to not serve them")
+	flag.StringVar(&validateDir, "validate", "", "validate the trigger files in the directory, including the type check against event schemas, and exit")
 
 	var kubeConfigPath string
 	if home := homedir.HomeDir(); home != "" {
@@ -79,6 +83,10 @@ func main() {
 
 	flag.Parse()
 
+	if validateDir != "" {
+		os.Exit(validateTriggers(validateDir))
+	}
+
 	klog.Infof("disableTLS: %v", disableTLS)
 	klog.Infof("skipChecksumVerify: %v", skipChkSumVerify)
 
@@ -133,6 +141,7 @@ func main() {
 		MessageService: messageService,
 		KubeClient:     kubeClient,
 		DynamicClient:  dynamicClient,
+		KubeConfig:     cfg,
 	}
 
 	triggerProc := trigger.NewProcessor(env)
@@ -141,12 +150,32 @@ func main() {
 		klog.Fatal(fmt.Errorf("unable to initialize trigger definition: %s", err))
 	}
 
+	/* Load and watch the overrides of the trigger parameters */
+	err = triggerProc.WatchParameters()
+	if err != nil {
+		klog.Fatal(fmt.Errorf("unable to load trigger parameters: %s", err))
+	}
+
 	/* Start listeners to listen on events */
 	err = triggerProc.StartListeners()
 	if err != nil {
 		klog.Fatal(fmt.Errorf("unable to start listeners for event triggers: %s", err))
 	}
 
+	/* Serve traces of evaluations on the internal trace listener */
+	if traceAddress != "" {
+		go func() {
+			err := endpoints.NewTraceListener(traceAddress, func(eventID string) (interface{}, bool) {
+				if eventID == "" {
+					return triggerProc.TraceIDs(), true
+				}
+				trace := triggerProc.GetTrace(eventID)
+				return trace, trace != nil
+			})
+			klog.Errorf("Trace listener stopped: %v", err)
+		}()
+	}
+
 	// Listen for events
 	if disableTLS {
 		err = endpoints.NewListener(messageService)
@@ -161,6 +190,17 @@ func main() {
 	select {}
 }
 
+/* Validate the trigger files in a directory without connecting to Kubernetes. Return the exit code. */
+func validateTriggers(dir string) int {
+	err := trigger.NewProcessor(nil).Initialize(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	fmt.Printf("Trigger files in %v are valid\n", dir)
+	return 0
+}
+
 type urlFlag struct {
 	url *url.URL
 }
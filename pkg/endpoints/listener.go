@@ -23,6 +23,7 @@ import (
 	"k8s.io/klog"
 	"net/http"
 	"os"
+	"strings"
 )
 
 const (
@@ -32,8 +33,14 @@ const (
 	BODY = "body"
 	// WEBHOOKDESTINATION GitHub event destination
 	WEBHOOKDESTINATION = "github"
+	// TRACEPATH Path to retrieve evaluation traces by event ID
+	TRACEPATH = "/trace/"
 )
 
+// TraceLookup returns the evaluation trace for an event ID, or false if not found.
+// With an empty event ID, it returns the IDs of all the events with traces.
+type TraceLookup func(eventID string) (interface{}, bool)
+
 /* Event listener */
 func listenerHandler(messageService *messages.Service) http.HandlerFunc {
 	return func(writer http.ResponseWriter, req *http.Request) {
@@ -78,6 +85,43 @@ func listenerHandler(messageService *messages.Service) http.HandlerFunc {
 	}
 }
 
+/* Handler to retrieve evaluation traces as JSON */
+func traceHandler(lookup TraceLookup) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		eventID := strings.TrimPrefix(req.URL.Path, TRACEPATH)
+		trace, found := lookup(eventID)
+		if !found {
+			klog.Infof("Trace for event %v not found", eventID)
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		bytes, err := json.Marshal(trace)
+		if err != nil {
+			klog.Errorf("Unable to marshall trace for event %v as JSON: %v", eventID, err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusOK)
+		writer.Write(bytes)
+	}
+}
+
+// NewTraceListener serves evaluation traces at TRACEPATH<event ID> on a listener separate from the webhook listener,
+// as traces hold the content of events. The address, such as 127.0.0.1:9081, should not be reachable by senders of events.
+func NewTraceListener(address string, lookup TraceLookup) error {
+	klog.Infof("Starting trace listener on %v", address)
+	mux := http.NewServeMux()
+	mux.HandleFunc(TRACEPATH, traceHandler(lookup))
+	return http.ListenAndServe(address, mux)
+}
+
 // NewListener creates a new event listener on port 9080
 func NewListener(messageService *messages.Service) error {
 	klog.Infof("Starting listener on port 9080")
@@ -20,11 +20,13 @@ import (
 	"github.com/kabanero-io/kabanero-events/pkg/messages"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 // Environment stores clients and such that will need to be shared.
 type Environment struct {
 	MessageService *messages.Service
-	KubeClient     *kubernetes.Clientset
+	KubeClient     kubernetes.Interface
 	DynamicClient  dynamic.Interface
+	KubeConfig     *rest.Config // configuration of the clients, to bound the time of requests while processing an event, or nil
 }
@@ -17,6 +17,7 @@ limitations under the License.
 package messages
 
 import (
+	"context"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"k8s.io/klog"
@@ -39,6 +40,12 @@ type Provider interface {
 	ListenAndServe(*EventNode, ReceiverFunc)
 }
 
+// ContextProvider may be implemented by a Provider that can bound the time to send a message with a context.
+type ContextProvider interface {
+	// SendContext sends a new message to an eventDestination within the time allowed by the context.
+	SendContext(context.Context, *EventNode, []byte, interface{}) error
+}
+
 // EventDefinition contains providers, event sources, and event destinations.
 type EventDefinition struct {
 	Providers         []*ProviderDefinition `yaml:"messageProviders,omitempty"`
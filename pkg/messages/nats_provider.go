@@ -17,6 +17,7 @@ limitations under the License.
 package messages
 
 import (
+	"context"
 	"fmt"
 	"github.com/nats-io/nats.go"
 	"k8s.io/klog"
@@ -56,14 +57,29 @@ func (provider *natsProvider) Subscribe(node *EventNode) error {
 
 // Send an event to some eventSource.
 func (provider *natsProvider) Send(node *EventNode, payload []byte, header interface{}) error {
+	return provider.SendContext(context.Background(), node, payload, header)
+}
+
+// SendContext sends an event to some eventSource within the time allowed by the context.
+func (provider *natsProvider) SendContext(ctx context.Context, node *EventNode, payload []byte, header interface{}) error {
 	klog.Infof("natsProvider: Sending %s", string(payload))
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	conn := provider.connection
 	if err := conn.Publish(node.Topic, payload); err != nil {
 		return err
 	}
 
 	// Perform a round trip to the server and return when it receives the internal reply.
-	if err := conn.Flush(); err != nil {
+	// Flushing with a context requires a deadline.
+	var err error
+	if _, ok := ctx.Deadline(); ok {
+		err = conn.FlushWithContext(ctx)
+	} else {
+		err = conn.Flush()
+	}
+	if err != nil {
 		return err
 	}
 
@@ -18,6 +18,7 @@ package messages
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"k8s.io/klog"
@@ -47,6 +48,11 @@ func (provider *restProvider) ListenAndServe(node *EventNode, receiver ReceiverF
 
 // Send a message to an eventDestination.
 func (provider *restProvider) Send(node *EventNode, payload []byte, header interface{}) error {
+	return provider.SendContext(context.Background(), node, payload, header)
+}
+
+// SendContext sends a message to an eventDestination within the time allowed by the context.
+func (provider *restProvider) SendContext(ctx context.Context, node *EventNode, payload []byte, header interface{}) error {
 	if klog.V(6) {
 		klog.Infof("restProvider: Sending %s", string(payload))
 	}
@@ -55,6 +61,7 @@ func (provider *restProvider) Send(node *EventNode, payload []byte, header inter
 	if err != nil {
 		return err
 	}
+	req = req.WithContext(ctx)
 
 	if header != nil {
 		headerMap, ok := header.(map[string][]string)
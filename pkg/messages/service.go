@@ -17,6 +17,7 @@ limitations under the License.
 package messages
 
 import (
+	"context"
 	"fmt"
 	"k8s.io/klog"
 )
@@ -76,6 +77,11 @@ func NewService(fileName string) (*Service, error) {
 
 // Send a message to the destination with the name `dest`.
 func (s *Service) Send(dest string, body []byte, other interface{}) error {
+	return s.SendContext(context.Background(), dest, body, other)
+}
+
+// SendContext sends a message to the destination with the name `dest`, within the time allowed by the context.
+func (s *Service) SendContext(ctx context.Context, dest string, body []byte, other interface{}) error {
 	node := s.GetNode(dest)
 	if node == nil {
 		return fmt.Errorf("unable find an event node with the name '%s'", dest)
@@ -86,6 +92,9 @@ func (s *Service) Send(dest string, body []byte, other interface{}) error {
 		return fmt.Errorf("unable to find provider with name '%s", node.ProviderRef)
 	}
 
+	if contextProvider, ok := provider.(ContextProvider); ok {
+		return contextProvider.SendContext(ctx, node, body, other)
+	}
 	return provider.Send(node, body, other)
 }
 
@@ -0,0 +1,872 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+)
+
+// Statement is a statement in the body of a trigger or function.
+type Statement interface {
+	Pos() Position
+}
+
+// Trigger is a trigger for an event source.
+type Trigger struct {
+	EventSource string
+	Input       string   // name of the variable holding the event
+	Trace       bool     // whether evaluation of the trigger is traced
+	Export      []string // variables passed to templates by applyResources, or nil for all variables
+	Body        []Statement
+	Position    Position
+}
+
+// Function is a user defined function that can be invoked with call.
+type Function struct {
+	Name       string
+	Namespace  string // namespace of the library declaring the function, or empty
+	Parameters []*Parameter
+	Outputs    []string // names of the variables holding the return values
+	OutputMap  bool     // true if the outputs are returned as a map, keyed by name
+	Body       []Statement
+	Position   Position
+}
+
+// Constant is a named value evaluated once, when the trigger definition is loaded.
+type Constant struct {
+	Name       string // name, qualified by the namespace of its library
+	Namespace  string // namespace of the library declaring the constant, or empty
+	Expression string
+	Position   Position
+}
+
+// Parameter is a parameter of a function.
+type Parameter struct {
+	Name     string
+	Type     string // CEL type name of the argument, or empty to accept any type
+	Default  string // expression for the value when the argument is not passed, or empty if the argument is required
+	Position Position
+}
+
+// Assignment assigns the value of a CEL expression to a variable.
+type Assignment struct {
+	Variable   string
+	Path       []PathElement // components of the variable if it contains list indexes, otherwise nil
+	Expression string
+	Position   Position
+}
+
+// PathElement is one component of a variable name: the key of a map, or the index of a list.
+type PathElement struct {
+	Key   string
+	Index int // index of a list, when Key is empty
+}
+
+// Let assigns variables that are local to the enclosing body. They are removed, or restored to their previous
+// values, at the end of the body.
+type Let struct {
+	Assignments []*Assignment
+	Position    Position
+}
+
+// Unset removes variables.
+type Unset struct {
+	Variables []string
+	Position  Position
+}
+
+// If evaluates its body when its condition is true. In a switch, it is one of the cases.
+type If struct {
+	Condition string
+	Body      []Statement // assignments, or the statements of a nested body or switch
+	Position  Position
+}
+
+// Switch evaluates the body of the first case whose condition is true, or the default if none is.
+type Switch struct {
+	Cases    []*If
+	Default  *Default // nil if no default
+	Position Position
+}
+
+// Default is the body of a switch evaluated when no case is true.
+type Default struct {
+	Body     []Statement
+	Position Position
+}
+
+// Body is a nested block of statements.
+type Body struct {
+	Body     []Statement
+	Position Position
+}
+
+// Foreach evaluates its body once for each item of a list or map, in a nested scope.
+type Foreach struct {
+	Expression string // list or map to iterate over
+	Item       string // name of the variable holding the item
+	Index      string // name of the variable holding the index of a list, or the key of a map. Optional.
+	Collect    string // expression evaluated in the nested scope after the body. Optional.
+	Into       string // name of the list variable holding the collected values. Optional.
+	Body       []Statement
+	Position   Position
+}
+
+// Exit is a return, stop, or fail statement. Return ends a function with the value of the expression as its output.
+// Stop ends the current trigger, and fail aborts processing of the event. The expression of stop and fail is the reason.
+type Exit struct {
+	Kind       string // return, stop, or fail
+	Expression string
+	Position   Position
+}
+
+// Try evaluates its body, and if an error occurs, evaluates the catch body with the error bound to a variable.
+type Try struct {
+	Body          []Statement
+	Catch         []Statement
+	ErrorVariable string // name of the variable holding the error in the catch body
+	Position      Position
+}
+
+// Pos returns the position of the statement
+func (stmt *Assignment) Pos() Position { return stmt.Position }
+
+// Pos returns the position of the statement
+func (stmt *If) Pos() Position { return stmt.Position }
+
+// Pos returns the position of the statement
+func (stmt *Switch) Pos() Position { return stmt.Position }
+
+// Pos returns the position of the statement
+func (stmt *Default) Pos() Position { return stmt.Position }
+
+// Pos returns the position of the statement
+func (stmt *Body) Pos() Position { return stmt.Position }
+
+// Pos returns the position of the statement
+func (stmt *Foreach) Pos() Position { return stmt.Position }
+
+// Pos returns the position of the statement
+func (stmt *Exit) Pos() Position { return stmt.Position }
+
+// Pos returns the position of the statement
+func (stmt *Try) Pos() Position { return stmt.Position }
+
+// Pos returns the position of the statement
+func (stmt *Let) Pos() Position { return stmt.Position }
+
+// Pos returns the position of the statement
+func (stmt *Unset) Pos() Position { return stmt.Position }
+
+/* keywords that end a function, trigger, or event */
+const exitFlags = ReturnFlag | StopFlag | FailFlag
+
+/* parser converts the objects read from a trigger file into the AST, validating the syntax */
+type parser struct {
+	env        cel.Env // used to check syntax of CEL expressions
+	inFunction bool    // true when parsing the body of a function
+}
+
+func newParser() (*parser, error) {
+	env, err := cel.NewEnv()
+	if err != nil {
+		return nil, err
+	}
+	return &parser{env: env}, nil
+}
+
+/* Check the syntax of a CEL expression */
+func (ps *parser) checkExpression(expression string, pos Position) error {
+	_, issues := ps.env.Parse(expression)
+	if issues != nil && issues.Err() != nil {
+		return fmt.Errorf("%v: syntax error in expression %v: %v", pos, expression, issues.Err())
+	}
+	return nil
+}
+
+/* Return the value of a key that must be a string */
+func getString(obj *Object, key string) (string, error) {
+	entry := obj.GetEntry(key)
+	if entry == nil {
+		return "", fmt.Errorf("%v: %v not found", obj.Position, key)
+	}
+	str, ok := entry.Value.(string)
+	if !ok {
+		return "", fmt.Errorf("%v: %v is not a string but %T", entry.Position, key, entry.Value)
+	}
+	return str, nil
+}
+
+/* Return the value of a key that must be an array */
+func getArray(obj *Object, key string) ([]interface{}, error) {
+	entry := obj.GetEntry(key)
+	if entry == nil {
+		return nil, fmt.Errorf("%v: %v not found", obj.Position, key)
+	}
+	array, ok := entry.Value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%v: %v is not an array but %T", entry.Position, key, entry.Value)
+	}
+	return array, nil
+}
+
+/* Return an error if the object contains keys other than the allowed ones */
+func checkKeys(obj *Object, kind string, allowed ...string) error {
+	for _, entry := range obj.Entries {
+		found := false
+		for _, key := range allowed {
+			if entry.Key == key {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%v: unexpected %v in %v", entry.Position, entry.Key, kind)
+		}
+	}
+	return nil
+}
+
+/* Return an error if a variable may not be assigned */
+func checkWritable(name string, pos Position) error {
+	if strings.Split(name, ".")[0] == PARAMS {
+		return fmt.Errorf("%v: unable to set %v: %v is read-only", pos, name, PARAMS)
+	}
+	return nil
+}
+
+func (ps *parser) parseTrigger(obj *Object) (*Trigger, error) {
+	if err := checkKeys(obj, "trigger", EVENTSOURCE, INPUT, BODY, TRACE, EXPORT); err != nil {
+		return nil, err
+	}
+	trigger := &Trigger{Position: obj.Position}
+	var err error
+	if trigger.EventSource, err = getString(obj, EVENTSOURCE); err != nil {
+		return nil, err
+	}
+	if trigger.Input, err = getString(obj, INPUT); err != nil {
+		return nil, err
+	}
+	if traceEntry := obj.GetEntry(TRACE); traceEntry != nil {
+		b, ok := traceEntry.Value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%v: %v is not a boolean but %T", traceEntry.Position, TRACE, traceEntry.Value)
+		}
+		trigger.Trace = b
+	}
+	if _, ok := obj.Get(EXPORT); ok {
+		exports, err := getArray(obj, EXPORT)
+		if err != nil {
+			return nil, err
+		}
+		trigger.Export = make([]string, 0, len(exports))
+		for _, exportObj := range exports {
+			export, ok := exportObj.(string)
+			if !ok || export == "" || strings.ContainsAny(export, ".[") {
+				return nil, fmt.Errorf("%v: exported variable %v must be the name of a top level variable", obj.GetEntry(EXPORT).Position, exportObj)
+			}
+			trigger.Export = append(trigger.Export, export)
+		}
+	}
+	body, err := getArray(obj, BODY)
+	if err != nil {
+		return nil, err
+	}
+	if trigger.Body, err = ps.parseStatements(body, obj.Position); err != nil {
+		return nil, err
+	}
+	return trigger, nil
+}
+
+func (ps *parser) parseFunction(obj *Object) (*Function, error) {
+	if err := checkKeys(obj, "function", NAME, INPUT, PARAMETERS, OUTPUT, OUTPUTS, BODY); err != nil {
+		return nil, err
+	}
+	function := &Function{Position: obj.Position}
+	ps.inFunction = true
+	defer func() { ps.inFunction = false }()
+	var err error
+	if function.Name, err = getString(obj, NAME); err != nil {
+		return nil, err
+	}
+
+	/* input declares a single parameter, and parameters declares any number */
+	_, hasInput := obj.Get(INPUT)
+	_, hasParameters := obj.Get(PARAMETERS)
+	switch {
+	case hasInput && hasParameters:
+		return nil, fmt.Errorf("%v: function %v declares both %v and %v", obj.Position, function.Name, INPUT, PARAMETERS)
+	case hasInput:
+		input, err := getString(obj, INPUT)
+		if err != nil {
+			return nil, err
+		}
+		function.Parameters = []*Parameter{{Name: input, Position: obj.GetEntry(INPUT).Position}}
+	case hasParameters:
+		if function.Parameters, err = ps.parseParameters(obj); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%v: function %v does not declare %v or %v", obj.Position, function.Name, INPUT, PARAMETERS)
+	}
+
+	/* output declares a single return value, and outputs declares a map of return values */
+	_, hasOutput := obj.Get(OUTPUT)
+	_, hasOutputs := obj.Get(OUTPUTS)
+	switch {
+	case hasOutput && hasOutputs:
+		return nil, fmt.Errorf("%v: function %v declares both %v and %v", obj.Position, function.Name, OUTPUT, OUTPUTS)
+	case hasOutput:
+		output, err := getString(obj, OUTPUT)
+		if err != nil {
+			return nil, err
+		}
+		function.Outputs = []string{output}
+	case hasOutputs:
+		outputs, err := getArray(obj, OUTPUTS)
+		if err != nil {
+			return nil, err
+		}
+		for _, outputObj := range outputs {
+			output, ok := outputObj.(string)
+			if !ok {
+				return nil, fmt.Errorf("%v: output %v of function %v is not a string", obj.GetEntry(OUTPUTS).Position, outputObj, function.Name)
+			}
+			function.Outputs = append(function.Outputs, output)
+		}
+		function.OutputMap = true
+	default:
+		return nil, fmt.Errorf("%v: function %v does not declare %v or %v", obj.Position, function.Name, OUTPUT, OUTPUTS)
+	}
+
+	body, err := getArray(obj, BODY)
+	if err != nil {
+		return nil, err
+	}
+	if function.Body, err = ps.parseStatements(body, obj.Position); err != nil {
+		return nil, err
+	}
+	return function, nil
+}
+
+/* Parse an array of statements. pos is the position of the enclosing object, for error messages */
+func (ps *parser) parseStatements(array []interface{}, pos Position) ([]Statement, error) {
+	statements := make([]Statement, 0, len(array))
+	for _, element := range array {
+		obj, ok := element.(*Object)
+		if !ok {
+			return nil, fmt.Errorf("%v: statement %v is not an object but %T", pos, element, element)
+		}
+		parsed, err := ps.parseStatement(obj)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, parsed...)
+	}
+	return statements, nil
+}
+
+/* Parse one element of a body. An element with several assignments results in several statements. */
+func (ps *parser) parseStatement(obj *Object) ([]Statement, error) {
+	numKeywords, flags := countKeywords(obj)
+	switch {
+	case (flags & IfFlag) != 0:
+		stmt, err := ps.parseIf(obj, numKeywords, flags)
+		if err != nil {
+			return nil, err
+		}
+		return []Statement{stmt}, nil
+	case (flags & exitFlags) != 0:
+		if numKeywords > 1 || obj.Len() > 1 {
+			return nil, fmt.Errorf("%v: return, stop, or fail must be stand alone: %v", obj.Position, obj)
+		}
+		stmt, err := ps.parseExit(obj.Entries[0])
+		if err != nil {
+			return nil, err
+		}
+		return []Statement{stmt}, nil
+	case (flags & TryFlag) != 0:
+		stmt, err := ps.parseTry(obj)
+		if err != nil {
+			return nil, err
+		}
+		return []Statement{stmt}, nil
+	case (flags & LetFlag) != 0:
+		if numKeywords > 1 || obj.Len() > 1 {
+			return nil, fmt.Errorf("%v: let must be stand alone: %v", obj.Position, obj)
+		}
+		stmt, err := ps.parseLet(obj.Entries[0])
+		if err != nil {
+			return nil, err
+		}
+		return []Statement{stmt}, nil
+	case (flags & UnsetFlag) != 0:
+		if numKeywords > 1 || obj.Len() > 1 {
+			return nil, fmt.Errorf("%v: unset must be stand alone: %v", obj.Position, obj)
+		}
+		stmt, err := parseUnset(obj.Entries[0])
+		if err != nil {
+			return nil, err
+		}
+		return []Statement{stmt}, nil
+	case (flags & ForeachFlag) != 0:
+		stmt, err := ps.parseForeach(obj)
+		if err != nil {
+			return nil, err
+		}
+		return []Statement{stmt}, nil
+	case (flags & SwitchFlag) != 0:
+		if numKeywords > 1 {
+			return nil, fmt.Errorf("%v: switch contains more than one keyword: %v", obj.Position, obj)
+		}
+		if obj.Len() > 1 {
+			return nil, fmt.Errorf("%v: switch also contains assignment: %v", obj.Position, obj)
+		}
+		stmt, err := ps.parseSwitch(obj.GetEntry(SWITCH))
+		if err != nil {
+			return nil, err
+		}
+		return []Statement{stmt}, nil
+	case (flags & BodyFlag) != 0:
+		if numKeywords > 1 {
+			return nil, fmt.Errorf("%v: body contains more than one keyword: %v", obj.Position, obj)
+		}
+		if obj.Len() > 1 {
+			return nil, fmt.Errorf("%v: body also contains assignment: %v", obj.Position, obj)
+		}
+		body, err := getArray(obj, BODY)
+		if err != nil {
+			return nil, err
+		}
+		statements, err := ps.parseStatements(body, obj.Position)
+		if err != nil {
+			return nil, err
+		}
+		return []Statement{&Body{Body: statements, Position: obj.Position}}, nil
+	case (flags & DefaultFlag) != 0:
+		return nil, fmt.Errorf("%v: unexpected keyword default outside of a switch: %v", obj.Position, obj)
+	default:
+		/* plain assignments, evaluated in the order they are declared */
+		return ps.parseAssignments(obj)
+	}
+}
+
+/* Parse the assignments of an object, skipping keywords */
+func (ps *parser) parseAssignments(obj *Object) ([]Statement, error) {
+	statements := make([]Statement, 0, obj.Len())
+	for _, entry := range obj.Entries {
+		if isKeyword(entry.Key) {
+			continue
+		}
+		expression, err := ps.parseExpression(entry)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkWritable(entry.Key, entry.Position); err != nil {
+			return nil, err
+		}
+		stmt := &Assignment{Variable: entry.Key, Expression: expression, Position: entry.Position}
+		if strings.Contains(entry.Key, "[") {
+			if stmt.Path, err = parseVariablePath(entry.Key, entry.Position); err != nil {
+				return nil, err
+			}
+		}
+		statements = append(statements, stmt)
+	}
+	return statements, nil
+}
+
+/* Parse a variable name containing list indexes, such as build.targets[2].name */
+func parseVariablePath(name string, pos Position) ([]PathElement, error) {
+	path := make([]PathElement, 0)
+	rest := name
+	for rest != "" {
+		if rest[0] == '[' {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("%v: missing ] in variable %v", pos, name)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("%v: invalid list index %v in variable %v", pos, rest[1:end], name)
+			}
+			path = append(path, PathElement{Index: index})
+			rest = rest[end+1:]
+			if rest != "" && rest[0] != '[' && rest[0] != '.' {
+				return nil, fmt.Errorf("%v: unexpected %v after list index in variable %v", pos, rest, name)
+			}
+			continue
+		}
+		if rest[0] == '.' {
+			if len(path) == 0 {
+				return nil, fmt.Errorf("%v: variable %v starts with '.'", pos, name)
+			}
+			rest = rest[1:]
+		}
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("%v: empty component in variable %v", pos, name)
+		}
+		path = append(path, PathElement{Key: rest[:end]})
+		rest = rest[end:]
+	}
+	if len(path) == 0 || path[0].Key == "" {
+		return nil, fmt.Errorf("%v: variable %v must start with a name", pos, name)
+	}
+	return path, nil
+}
+
+/* Parse a let statement. The variables are local to the enclosing body, so they must be top level names. */
+func (ps *parser) parseLet(entry *ObjectEntry) (*Let, error) {
+	obj, ok := entry.Value.(*Object)
+	if !ok {
+		return nil, fmt.Errorf("%v: %v is not an object but %T", entry.Position, LET, entry.Value)
+	}
+	stmt := &Let{Assignments: make([]*Assignment, 0, obj.Len()), Position: entry.Position}
+	for _, assignment := range obj.Entries {
+		if strings.ContainsAny(assignment.Key, ".[") || isKeyword(assignment.Key) {
+			return nil, fmt.Errorf("%v: let variable %v must be a top level name", assignment.Position, assignment.Key)
+		}
+		if err := checkWritable(assignment.Key, assignment.Position); err != nil {
+			return nil, err
+		}
+		expression, err := ps.parseExpression(assignment)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Assignments = append(stmt.Assignments, &Assignment{Variable: assignment.Key, Expression: expression, Position: assignment.Position})
+	}
+	return stmt, nil
+}
+
+/* Parse an unset statement, with either one variable or a list of variables */
+func parseUnset(entry *ObjectEntry) (*Unset, error) {
+	stmt := &Unset{Position: entry.Position}
+	switch value := entry.Value.(type) {
+	case string:
+		stmt.Variables = []string{value}
+	case []interface{}:
+		for _, element := range value {
+			name, ok := element.(string)
+			if !ok {
+				return nil, fmt.Errorf("%v: variable %v to unset is not a string but %T", entry.Position, element, element)
+			}
+			stmt.Variables = append(stmt.Variables, name)
+		}
+	default:
+		return nil, fmt.Errorf("%v: %v is not a string or list but %T", entry.Position, UNSET, entry.Value)
+	}
+	for _, name := range stmt.Variables {
+		if name == "" || strings.Contains(name, "[") {
+			return nil, fmt.Errorf("%v: unable to unset %v: only variables and keys of maps may be unset", entry.Position, name)
+		}
+		if err := checkWritable(name, entry.Position); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+/* Return the value of an entry as a CEL expression */
+func (ps *parser) parseExpression(entry *ObjectEntry) (string, error) {
+	/* Format value as string for CEL parsing */
+	var expression string
+	switch entry.Value.(type) {
+	case int, int64, int32, float32, float64, bool:
+		expression = fmt.Sprintf("%v", entry.Value)
+	case string:
+		expression = entry.Value.(string)
+	default:
+		return "", fmt.Errorf("%v: Value of variables not stored as  YAML primitive types or string when assgining %v to %v. Type of value is %T", entry.Position, entry.Key, entry.Value, entry.Value)
+	}
+	if err := ps.checkExpression(expression, entry.Position); err != nil {
+		return "", err
+	}
+	return expression, nil
+}
+
+func (ps *parser) parseIf(obj *Object, numKeywords int, flags uint) (*If, error) {
+	if numKeywords > 2 {
+		return nil, fmt.Errorf("%v: body of if %v contains more than two keyword", obj.Position, obj)
+	}
+	if numKeywords == 2 && (flags&(BodyFlag|SwitchFlag|exitFlags)) == 0 {
+		/* second keyword is not body, switch, return, stop, or fail */
+		return nil, fmt.Errorf("%v: if object also contains keywords other than body, switch, return, stop, or fail: %v", obj.Position, obj)
+	}
+	if numKeywords == 2 && obj.Len() > 2 {
+		return nil, fmt.Errorf("%v: can not mix assignment with body object in if: %v", obj.Position, obj)
+	}
+
+	conditionEntry := obj.GetEntry(IF)
+	condition, ok := conditionEntry.Value.(string)
+	if !ok {
+		return nil, fmt.Errorf("%v: condition of if object not a string: %v", conditionEntry.Position, obj)
+	}
+	if err := ps.checkExpression(condition, conditionEntry.Position); err != nil {
+		return nil, err
+	}
+	stmt := &If{Condition: condition, Position: conditionEntry.Position}
+
+	var err error
+	switch {
+	case (flags & BodyFlag) != 0:
+		body, err := getArray(obj, BODY)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Body, err = ps.parseStatements(body, obj.Position)
+		if err != nil {
+			return nil, err
+		}
+	case (flags & SwitchFlag) != 0:
+		switchStmt, err := ps.parseSwitch(obj.GetEntry(SWITCH))
+		if err != nil {
+			return nil, err
+		}
+		stmt.Body = []Statement{switchStmt}
+	case (flags & exitFlags) != 0:
+		for _, entry := range obj.Entries {
+			if entry.Key == IF {
+				continue
+			}
+			exitStmt, err := ps.parseExit(entry)
+			if err != nil {
+				return nil, err
+			}
+			stmt.Body = []Statement{exitStmt}
+		}
+	default:
+		stmt.Body, err = ps.parseAssignments(obj)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (ps *parser) parseSwitch(entry *ObjectEntry) (*Switch, error) {
+	switchArray, ok := entry.Value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%v: body of switch not array of objects: %v ", entry.Position, entry.Value)
+	}
+	stmt := &Switch{Cases: make([]*If, 0, len(switchArray)), Position: entry.Position}
+	for _, caseObj := range switchArray {
+		caseElement, ok := caseObj.(*Object)
+		if !ok {
+			return nil, fmt.Errorf("%v: body component of switch not object: %v ", entry.Position, caseObj)
+		}
+		numKeywords, flags := countKeywords(caseElement)
+		if (flags & IfFlag) != 0 {
+			ifStmt, err := ps.parseIf(caseElement, numKeywords, flags)
+			if err != nil {
+				return nil, err
+			}
+			stmt.Cases = append(stmt.Cases, ifStmt)
+			continue
+		}
+		if (flags & DefaultFlag) != 0 {
+			if caseElement.Len() > 1 {
+				return nil, fmt.Errorf("%v: default object must be stand alone: %v", caseElement.Position, caseElement)
+			}
+			if stmt.Default != nil {
+				return nil, fmt.Errorf("%v: Only one default statement supported. Previous default statement at %v", caseElement.Position, stmt.Default.Position)
+			}
+			defaultArray, err := getArray(caseElement, DEFAULT)
+			if err != nil {
+				return nil, err
+			}
+			body, err := ps.parseStatements(defaultArray, caseElement.Position)
+			if err != nil {
+				return nil, err
+			}
+			stmt.Default = &Default{Body: body, Position: caseElement.Position}
+			continue
+		}
+		/* Unsupported keyword, or assignment */
+		return nil, fmt.Errorf("%v: switch statement must contain if or default statements, but found: %v", caseElement.Position, caseElement)
+	}
+	return stmt, nil
+}
+
+func (ps *parser) parseForeach(obj *Object) (*Foreach, error) {
+	if err := checkKeys(obj, FOREACH, FOREACH, ITEM, INDEX, COLLECT, INTO, BODY); err != nil {
+		return nil, err
+	}
+	stmt := &Foreach{Position: obj.GetEntry(FOREACH).Position}
+	var err error
+	if stmt.Expression, err = getString(obj, FOREACH); err != nil {
+		return nil, err
+	}
+	if err = ps.checkExpression(stmt.Expression, stmt.Position); err != nil {
+		return nil, err
+	}
+	if stmt.Item, err = getString(obj, ITEM); err != nil {
+		return nil, err
+	}
+	if _, ok := obj.Get(INDEX); ok {
+		if stmt.Index, err = getString(obj, INDEX); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := obj.Get(COLLECT); ok {
+		if stmt.Collect, err = getString(obj, COLLECT); err != nil {
+			return nil, err
+		}
+		if err = ps.checkExpression(stmt.Collect, obj.GetEntry(COLLECT).Position); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := obj.Get(INTO); ok {
+		if stmt.Into, err = getString(obj, INTO); err != nil {
+			return nil, err
+		}
+	}
+	if (stmt.Collect == "") != (stmt.Into == "") {
+		return nil, fmt.Errorf("%v: foreach must specify both %v and %v, or neither", obj.Position, COLLECT, INTO)
+	}
+	for _, name := range []string{stmt.Item, stmt.Index, stmt.Into} {
+		if strings.Contains(name, ".") {
+			return nil, fmt.Errorf("%v: foreach variable %v must not be a nested name", obj.Position, name)
+		}
+		if err := checkWritable(name, obj.Position); err != nil {
+			return nil, err
+		}
+	}
+	if stmt.Index != "" && stmt.Index == stmt.Item {
+		return nil, fmt.Errorf("%v: foreach %v and %v must be different variables", obj.Position, ITEM, INDEX)
+	}
+
+	if _, ok := obj.Get(BODY); ok {
+		body, err := getArray(obj, BODY)
+		if err != nil {
+			return nil, err
+		}
+		if stmt.Body, err = ps.parseStatements(body, obj.Position); err != nil {
+			return nil, err
+		}
+	} else if stmt.Collect == "" {
+		return nil, fmt.Errorf("%v: foreach must contain %v, or %v and %v", obj.Position, BODY, COLLECT, INTO)
+	}
+	return stmt, nil
+}
+
+func (ps *parser) parseExit(entry *ObjectEntry) (*Exit, error) {
+	if entry.Key == RETURN && !ps.inFunction {
+		return nil, fmt.Errorf("%v: return outside of a function. Use stop to end a trigger", entry.Position)
+	}
+	if entry.Key == STOP && ps.inFunction {
+		return nil, fmt.Errorf("%v: stop inside a function. Use return to end a function", entry.Position)
+	}
+	expression, err := ps.parseExpression(entry)
+	if err != nil {
+		return nil, err
+	}
+	return &Exit{Kind: entry.Key, Expression: expression, Position: entry.Position}, nil
+}
+
+func (ps *parser) parseTry(obj *Object) (*Try, error) {
+	if err := checkKeys(obj, TRY, TRY, CATCH, ERROR); err != nil {
+		return nil, err
+	}
+	stmt := &Try{ErrorVariable: ERROR, Position: obj.GetEntry(TRY).Position}
+	body, err := getArray(obj, TRY)
+	if err != nil {
+		return nil, err
+	}
+	if stmt.Body, err = ps.parseStatements(body, obj.Position); err != nil {
+		return nil, err
+	}
+	catch, err := getArray(obj, CATCH)
+	if err != nil {
+		return nil, err
+	}
+	if stmt.Catch, err = ps.parseStatements(catch, obj.Position); err != nil {
+		return nil, err
+	}
+	if _, ok := obj.Get(ERROR); ok {
+		if stmt.ErrorVariable, err = getString(obj, ERROR); err != nil {
+			return nil, err
+		}
+		if strings.Contains(stmt.ErrorVariable, ".") {
+			return nil, fmt.Errorf("%v: try %v variable %v must not be a nested name", obj.Position, ERROR, stmt.ErrorVariable)
+		}
+		if err := checkWritable(stmt.ErrorVariable, obj.Position); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+/* Parse the parameters of a function */
+func (ps *parser) parseParameters(obj *Object) ([]*Parameter, error) {
+	array, err := getArray(obj, PARAMETERS)
+	if err != nil {
+		return nil, err
+	}
+	if len(array) > maxCallArguments {
+		return nil, fmt.Errorf("%v: function declares %v parameters, more than the maximum of %v", obj.GetEntry(PARAMETERS).Position, len(array), maxCallArguments)
+	}
+	return ps.parseParameterList(obj, array)
+}
+
+/* Parse a list of parameters, of either a function or a trigger collection */
+func (ps *parser) parseParameterList(obj *Object, array []interface{}) ([]*Parameter, error) {
+	var err error
+	parameters := make([]*Parameter, 0, len(array))
+	names := make(map[string]bool)
+	for _, element := range array {
+		paramObj, ok := element.(*Object)
+		if !ok {
+			return nil, fmt.Errorf("%v: parameter %v is not an object but %T", obj.GetEntry(PARAMETERS).Position, element, element)
+		}
+		if err := checkKeys(paramObj, "parameter", NAME, TYPE, DEFAULT); err != nil {
+			return nil, err
+		}
+		param := &Parameter{Position: paramObj.Position}
+		if param.Name, err = getString(paramObj, NAME); err != nil {
+			return nil, err
+		}
+		if names[param.Name] {
+			return nil, fmt.Errorf("%v: parameter %v declared more than once", paramObj.Position, param.Name)
+		}
+		names[param.Name] = true
+		if _, ok := paramObj.Get(TYPE); ok {
+			if param.Type, err = getString(paramObj, TYPE); err != nil {
+				return nil, err
+			}
+			switch param.Type {
+			case TYPEINT, TYPEDOUBLE, TYPEBOOL, TYPESTRING, TYPELIST, TYPEMAP, TYPEANY:
+			default:
+				return nil, fmt.Errorf("%v: unsupported type %v of parameter %v", paramObj.GetEntry(TYPE).Position, param.Type, param.Name)
+			}
+		}
+		if entry := paramObj.GetEntry(DEFAULT); entry != nil {
+			if param.Default, err = ps.parseExpression(entry); err != nil {
+				return nil, err
+			}
+		}
+		parameters = append(parameters, param)
+	}
+	return parameters, nil
+}
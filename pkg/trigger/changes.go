@@ -0,0 +1,234 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/kabanero-io/kabanero-events/pkg/utils"
+)
+
+/* maximum number of commits in the payload of a push event. The commits of larger pushes are truncated. */
+const maxPushPayloadCommits = 20
+
+/* the before commit of a push creating a branch */
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+/* changeList is the list of files changed by an event, as returned by the GitHub API */
+type changeList struct {
+	files     []string
+	truncated bool // true if GitHub may have cut the list off
+}
+
+/* Return the paths added, modified, or removed by the commits of the payload of a push event */
+func pushPayloadFiles(body map[string]interface{}) ([]string, int) {
+	files := make([]string, 0)
+	commits, _ := body["commits"].([]interface{})
+	for _, commitObj := range commits {
+		commit, ok := commitObj.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, kind := range []string{"added", "modified", "removed"} {
+			paths, _ := commit[kind].([]interface{})
+			for _, path := range paths {
+				if str, ok := path.(string); ok {
+					files = append(files, str)
+				}
+			}
+		}
+	}
+	return files, len(commits)
+}
+
+/* Return the paths changed by the event of a webhook message, from its payload or else from the GitHub API */
+func (p *Processor) changedFiles(ev *evaluation, header map[string][]string, body map[string]interface{}) ([]string, ref.Val) {
+	var event string
+	if events := header[http.CanonicalHeaderKey("x-github-event")]; len(events) > 0 {
+		event = events[0]
+	}
+	switch event {
+	case "push":
+		files, numCommits := pushPayloadFiles(body)
+		before, _ := body["before"].(string)
+		after, _ := body["after"].(string)
+		if numCommits < maxPushPayloadCommits || before == "" || before == zeroSHA || after == "" || after == zeroSHA {
+			/* complete, or not comparable, as for a push creating or deleting a branch */
+			return files, nil
+		}
+		repo, errVal := p.messageRepository("changedFiles", header, body)
+		if errVal != nil {
+			return nil, errVal
+		}
+		key := fmt.Sprintf("changedFiles %v%v/%v %v...%v", repo.APIURL, repo.Owner, repo.Name, before, after)
+		obj, err := ev.cachedLookup(key, func() (interface{}, error) {
+			client, err := p.gitHubClient(ev.ctx, repo)
+			if err != nil {
+				return nil, err
+			}
+			files, truncated, err := utils.GetGitHubComparisonFiles(ev.ctx, client, repo, before, after)
+			return &changeList{files: files, truncated: truncated}, err
+		})
+		if err != nil {
+			return nil, types.NewErr("function changedFiles: %v", err)
+		}
+		changes := obj.(*changeList)
+		if changes.truncated {
+			return nil, types.NewErr("function changedFiles: GitHub returned only the first %v files changed between %v and %v, so the other changed files are not known", len(changes.files), before, after)
+		}
+		return changes.files, nil
+	case "pull_request":
+		number, ok := body["number"].(float64)
+		if !ok {
+			return nil, types.NewErr("function changedFiles: the pull_request event has no number")
+		}
+		repo, errVal := p.messageRepository("changedFiles", header, body)
+		if errVal != nil {
+			return nil, errVal
+		}
+		key := fmt.Sprintf("changedFiles %v%v/%v #%v@%v", repo.APIURL, repo.Owner, repo.Name, number, repo.Ref)
+		obj, err := ev.cachedLookup(key, func() (interface{}, error) {
+			client, err := p.gitHubClient(ev.ctx, repo)
+			if err != nil {
+				return nil, err
+			}
+			files, truncated, err := utils.GetGitHubPullRequestFiles(ev.ctx, client, repo, int(number))
+			return &changeList{files: files, truncated: truncated}, err
+		})
+		if err != nil {
+			return nil, types.NewErr("function changedFiles: %v", err)
+		}
+		changes := obj.(*changeList)
+		if changes.truncated {
+			return nil, types.NewErr("function changedFiles: GitHub returned only the first %v files changed by pull request %v, so the other changed files are not known", len(changes.files), number)
+		}
+		return changes.files, nil
+	default:
+		return nil, types.NewErr("function changedFiles: unsupported event %q. Expecting push or pull_request", event)
+	}
+}
+
+/*
+implementation of changedFiles(message): the sorted paths added, modified, or removed by a push or pull request. The payload of
+a push has at most 20 commits, so the commits of a larger push are compared with the GitHub API. The files of a pull request are
+listed with the GitHub API. It is an error if GitHub cuts the list of files off, so that no change is missed.
+*/
+func (p *Processor) changedFilesCEL(ev *evaluation, messageVal ref.Val) ref.Val {
+	header, body, errVal := webhookMessage("changedFiles", messageVal)
+	if errVal != nil {
+		return errVal
+	}
+	files, errVal := p.changedFiles(ev, header, body)
+	if errVal != nil {
+		return errVal
+	}
+	unique := make(map[string]bool)
+	sorted := make([]string, 0, len(files))
+	for _, file := range files {
+		if !unique[file] {
+			unique[file] = true
+			sorted = append(sorted, file)
+		}
+	}
+	sort.Strings(sorted)
+	return types.NewStringList(types.DefaultTypeAdapter, sorted)
+}
+
+/*
+Convert a glob to a regular expression. ** matches any number of directories, * any characters other than /, and ? one character
+other than /. A glob ending with / matches everything in the directory.
+*/
+func globToRegex(glob string) string {
+	if strings.HasSuffix(glob, "/") {
+		glob += "**"
+	}
+	var buf strings.Builder
+	buf.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			buf.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			buf.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			buf.WriteString("[^/]*")
+		case glob[i] == '?':
+			buf.WriteString("[^/]")
+		default:
+			buf.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	buf.WriteString("$")
+	return buf.String()
+}
+
+/* Return the strings of a list, or of a single string, passed to a function */
+func stringsParam(function string, position string, val ref.Val) ([]string, ref.Val) {
+	if str, ok := val.(types.String); ok {
+		return []string{string(str)}, nil
+	}
+	list, ok := val.(traits.Lister)
+	if !ok {
+		return nil, types.ValOrErr(val, "unexpected type '%v' passed as %v parameter to function %v", val.Type(), position, function)
+	}
+	_, items, err := getIterationItems(list)
+	if err != nil {
+		return nil, types.NewErr("function %v: %v", function, err)
+	}
+	strs := make([]string, len(items))
+	for index, item := range items {
+		str, ok := item.(types.String)
+		if !ok {
+			return nil, types.NewErr("function %v: element %v of the %v parameter is not a string but %v", function, index, position, item.Type().TypeName())
+		}
+		strs[index] = string(str)
+	}
+	return strs, nil
+}
+
+/* implementation of pathMatches(files, globs): whether any of the paths matches any of the globs, such as "services/api/**" */
+func (p *Processor) pathMatchesCEL(filesVal ref.Val, globsVal ref.Val) ref.Val {
+	files, errVal := stringsParam("pathMatches", "first", filesVal)
+	if errVal != nil {
+		return errVal
+	}
+	globs, errVal := stringsParam("pathMatches", "second", globsVal)
+	if errVal != nil {
+		return errVal
+	}
+	for _, glob := range globs {
+		re, err := patternCache.compile(globToRegex(glob))
+		if err != nil {
+			return types.NewErr("function pathMatches: invalid glob %v: %v", glob, err)
+		}
+		for _, file := range files {
+			if re.MatchString(file) {
+				return types.True
+			}
+		}
+	}
+	return types.False
+}
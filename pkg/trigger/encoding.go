@@ -0,0 +1,274 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"sigs.k8s.io/yaml"
+)
+
+/* number of hex digits of the hash appended by truncateHash */
+const truncateHashDigits = 8
+
+/* Return the string parameter of a string function */
+func stringParam(function string, position string, val ref.Val) (string, ref.Val) {
+	str, ok := val.(types.String)
+	if !ok {
+		return "", types.ValOrErr(val, "unexpected type '%v' passed as %v parameter to function %v", val.Type(), position, function)
+	}
+	return string(str), nil
+}
+
+/* Convert a value to the Go values of JSON: maps with string keys, lists, strings, numbers, booleans, and nil */
+func refToNative(value interface{}) (interface{}, error) {
+	val, ok := value.(ref.Val)
+	if !ok {
+		val = types.DefaultTypeAdapter.NativeToValue(value)
+		if types.IsError(val) {
+			return nil, fmt.Errorf("unable to convert %v of type %T", value, value)
+		}
+	}
+	switch val.(type) {
+	case types.Null:
+		return nil, nil
+	case traits.Lister, traits.Mapper:
+		keys, items, err := getIterationItems(val)
+		if err != nil {
+			return nil, err
+		}
+		natives := make([]interface{}, len(items))
+		for index, item := range items {
+			if natives[index], err = refToNative(item); err != nil {
+				return nil, err
+			}
+		}
+		if _, isList := val.(traits.Lister); isList {
+			return natives, nil
+		}
+		ret := make(map[string]interface{})
+		for index, key := range keys {
+			keyStr, ok := key.(types.String)
+			if !ok {
+				return nil, fmt.Errorf("map key %v is not a string but %v", key, key.Type().TypeName())
+			}
+			ret[string(keyStr)] = natives[index]
+		}
+		return ret, nil
+	default:
+		return val.Value(), nil
+	}
+}
+
+/* implementation of base64Encode */
+func (p *Processor) base64EncodeCEL(val ref.Val) ref.Val {
+	str, errVal := stringParam("base64Encode", "first", val)
+	if errVal != nil {
+		return errVal
+	}
+	return types.String(base64.StdEncoding.EncodeToString([]byte(str)))
+}
+
+/* implementation of base64Decode. The decoded bytes must be a string. */
+func (p *Processor) base64DecodeCEL(val ref.Val) ref.Val {
+	str, errVal := stringParam("base64Decode", "first", val)
+	if errVal != nil {
+		return errVal
+	}
+	buf, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		return types.NewErr("function base64Decode: %v", err)
+	}
+	if !utf8.Valid(buf) {
+		return types.NewErr("function base64Decode: the decoded bytes are not a valid UTF-8 string")
+	}
+	return types.String(buf)
+}
+
+/* implementation of sha256: the hex encoded SHA-256 hash of a string */
+func (p *Processor) sha256CEL(val ref.Val) ref.Val {
+	str, errVal := stringParam("sha256", "first", val)
+	if errVal != nil {
+		return errVal
+	}
+	hash := sha256.Sum256([]byte(str))
+	return types.String(hex.EncodeToString(hash[:]))
+}
+
+/* implementation of toJSON: a compact JSON document, with the keys of maps sorted */
+func (p *Processor) toJSONCEL(val ref.Val) ref.Val {
+	native, err := refToNative(val)
+	if err != nil {
+		return types.NewErr("function toJSON: %v", err)
+	}
+	buf, err := json.Marshal(native)
+	if err != nil {
+		return types.NewErr("function toJSON: %v", err)
+	}
+	return types.String(buf)
+}
+
+/* implementation of fromJSON. Numbers are doubles, as in messages. */
+func (p *Processor) fromJSONCEL(val ref.Val) ref.Val {
+	str, errVal := stringParam("fromJSON", "first", val)
+	if errVal != nil {
+		return errVal
+	}
+	var native interface{}
+	if err := json.Unmarshal([]byte(str), &native); err != nil {
+		return types.NewErr("function fromJSON: %v", err)
+	}
+	return types.DefaultTypeAdapter.NativeToValue(native)
+}
+
+/* implementation of toYAML, with the keys of maps sorted */
+func (p *Processor) toYAMLCEL(val ref.Val) ref.Val {
+	native, err := refToNative(val)
+	if err != nil {
+		return types.NewErr("function toYAML: %v", err)
+	}
+	buf, err := yaml.Marshal(native)
+	if err != nil {
+		return types.NewErr("function toYAML: %v", err)
+	}
+	return types.String(buf)
+}
+
+/* implementation of fromYAML. The document is converted as JSON, so numbers are doubles and keys are strings. */
+func (p *Processor) fromYAMLCEL(val ref.Val) ref.Val {
+	str, errVal := stringParam("fromYAML", "first", val)
+	if errVal != nil {
+		return errVal
+	}
+	var native interface{}
+	if err := yaml.Unmarshal([]byte(str), &native); err != nil {
+		return types.NewErr("function fromYAML: %v", err)
+	}
+	return types.DefaultTypeAdapter.NativeToValue(native)
+}
+
+/* implementation of urlEncode: escape a string to be used in a URL query */
+func (p *Processor) urlEncodeCEL(val ref.Val) ref.Val {
+	str, errVal := stringParam("urlEncode", "first", val)
+	if errVal != nil {
+		return errVal
+	}
+	return types.String(url.QueryEscape(str))
+}
+
+func (p *Processor) lowerCEL(val ref.Val) ref.Val {
+	str, errVal := stringParam("lower", "first", val)
+	if errVal != nil {
+		return errVal
+	}
+	return types.String(strings.ToLower(str))
+}
+
+func (p *Processor) upperCEL(val ref.Val) ref.Val {
+	str, errVal := stringParam("upper", "first", val)
+	if errVal != nil {
+		return errVal
+	}
+	return types.String(strings.ToUpper(str))
+}
+
+/* implementation of trim(str [, cutset]): remove leading and trailing white space, or characters in the cutset */
+func (p *Processor) trimCEL(values ...ref.Val) ref.Val {
+	if len(values) != 1 && len(values) != 2 {
+		return types.NewErr("function trim expects 1 or 2 parameters, but got %v", len(values))
+	}
+	str, errVal := stringParam("trim", "first", values[0])
+	if errVal != nil {
+		return errVal
+	}
+	if len(values) == 1 {
+		return types.String(strings.TrimSpace(str))
+	}
+	cutset, errVal := stringParam("trim", "second", values[1])
+	if errVal != nil {
+		return errVal
+	}
+	return types.String(strings.Trim(str, cutset))
+}
+
+/* implementation of join: concatenate a list of strings with a separator */
+func (p *Processor) joinCEL(listVal ref.Val, sepVal ref.Val) ref.Val {
+	list, ok := listVal.(traits.Lister)
+	if !ok {
+		return types.ValOrErr(listVal, "unexpected type '%v' passed as first parameter to function join", listVal.Type())
+	}
+	sep, errVal := stringParam("join", "second", sepVal)
+	if errVal != nil {
+		return errVal
+	}
+	_, items, err := getIterationItems(list)
+	if err != nil {
+		return types.NewErr("function join: %v", err)
+	}
+	strs := make([]string, len(items))
+	for index, item := range items {
+		str, ok := item.(types.String)
+		if !ok {
+			return types.NewErr("function join: element %v of the list is not a string but %v", index, item.Type().TypeName())
+		}
+		strs[index] = string(str)
+	}
+	return types.String(strings.Join(strs, sep))
+}
+
+/*
+implementation of truncateHash(str, n): the string if it is at most n characters, or else a prefix followed by - and a hash of the
+whole string, n characters in total, so that names remain distinct and stable when truncated
+*/
+func (p *Processor) truncateHashCEL(strVal ref.Val, nVal ref.Val) ref.Val {
+	str, errVal := stringParam("truncateHash", "first", strVal)
+	if errVal != nil {
+		return errVal
+	}
+	n, ok := nVal.(types.Int)
+	if !ok {
+		return types.ValOrErr(nVal, "unexpected type '%v' passed as second parameter to function truncateHash", nVal.Type())
+	}
+	if int(n) <= truncateHashDigits {
+		return types.NewErr("function truncateHash: length %v must be greater than %v", n, truncateHashDigits)
+	}
+	if len(str) <= int(n) {
+		return strVal
+	}
+	hash := sha256.Sum256([]byte(str))
+	suffix := hex.EncodeToString(hash[:])[:truncateHashDigits]
+	/* do not split a multi-byte character */
+	end := int(n) - truncateHashDigits - 1
+	for end > 0 && !utf8.RuneStart(str[end]) {
+		end--
+	}
+	prefix := strings.TrimRight(str[:end], "-.")
+	if prefix == "" {
+		return types.String(suffix)
+	}
+	return types.String(prefix + "-" + suffix)
+}
@@ -0,0 +1,278 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kabanero-io/kabanero-events/pkg/utils"
+	"runtime/debug"
+	"time"
+
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter/functions"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+/* constants for dry run settings */
+const (
+	DRYRUN            = "dryrun"
+	DRYRUNCONFIGMAP   = "dryrunConfigMap"
+	DRYRUNDESTINATION = "dryrunDestination"
+	DRYRUNRESULTKEY   = "result.json"
+	DRYRUNLABEL       = "kabanero.io/dryrun"
+)
+
+// EvaluationResult records the outcome of processing one event through all triggers of its event source.
+type EvaluationResult struct {
+	EventID     string            `json:"eventID"`
+	EventSource string            `json:"eventSource"`
+	DryRun      bool              `json:"dryrun"`
+	Resources   []*DryRunResource `json:"resources,omitempty"`   // resources rendered but not created because of dryrun
+	Events      []*DryRunEvent    `json:"events,omitempty"`      // events not sent because of dryrun
+	Trace       *Trace            `json:"trace,omitempty"`       // statements evaluated, if tracing is enabled
+	Exits       []*TriggerExit    `json:"exits,omitempty"`       // triggers ended by stop or fail
+	SystemError *EvaluationResult `json:"systemError,omitempty"` // result of routing an unhandled error to the systemError event source
+}
+
+// TriggerExit records a trigger ended early by a stop or fail statement.
+type TriggerExit struct {
+	Trigger  int    `json:"trigger"` // index of the trigger for the event source
+	Kind     string `json:"kind"`    // stop or fail
+	Reason   string `json:"reason"`
+	Position string `json:"position"` // position of the statement in the trigger file
+}
+
+// DryRunResource is a rendered resource that would have been created had dryrun not been set.
+type DryRunResource struct {
+	Group     string                 `json:"group"`
+	Version   string                 `json:"version"`
+	Resource  string                 `json:"resource"`
+	Namespace string                 `json:"namespace"`
+	Name      string                 `json:"name"`
+	Manifest  map[string]interface{} `json:"manifest"`
+}
+
+// DryRunEvent is an event that would have been sent had dryrun not been set.
+type DryRunEvent struct {
+	Destination string              `json:"destination"`
+	Payload     interface{}         `json:"payload"`
+	Header      map[string][]string `json:"header,omitempty"`
+}
+
+/* evaluation holds the state for processing one event */
+type evaluation struct {
+	result       *EvaluationResult
+	funcs        []*functions.Overload   // CEL function implementations bound to this evaluation
+	ctx          context.Context         // bounds the time to process the event
+	timeout      time.Duration           // time allowed to process the event, or 0 if not bounded
+	trace        *Trace                  // non-nil if any trigger for the event is traced
+	tracing      bool                    // true if tracing the current trigger
+	redacting    bool                    // true once the current trigger calls a function whose values are redacted from traces
+	traceEvent   bool                    // true if tracing is requested for all triggers of the event
+	triggerIndex int                     // index of the current trigger
+	position     Position                // position of the current trigger
+	callDepth    int                     // depth of nested calls of functions
+	namespace    string                  // namespace of the library of the function being called
+	variables    map[string]interface{}  // top level variables of the current trigger
+	varTypes     map[string]*exprpb.Type // declared types of the variables in scope, when not the types of their values
+	exports      []string                // variables of the current trigger exported to templates, or nil for all
+	lookups      map[string]interface{}  // results of reading Kubernetes resources while processing the event
+	message      map[string]interface{}  // message of the event
+	costTracker  *costTracker            // cost of the top level expression being evaluated, or nil
+}
+
+/* Create the state for processing one event from the given event source */
+func (p *Processor) newEvaluation(message map[string]interface{}, eventSource string) *evaluation {
+	ev := &evaluation{
+		traceEvent: p.isTraceRequested(message),
+		message:    message,
+		ctx:        context.Background(),
+		result: &EvaluationResult{
+			EventID:     getEventID(message),
+			EventSource: eventSource,
+			DryRun:      p.triggerDef.isDryRun(),
+			Resources:   make([]*DryRunResource, 0),
+			Events:      make([]*DryRunEvent, 0),
+		},
+	}
+	ev.funcs = p.getAdditionalCELFuncs(ev)
+	return ev
+}
+
+// StatementError is an error evaluating a statement of a trigger or function.
+type StatementError struct {
+	Position  Position
+	Statement string // expression or condition evaluated
+	Err       error
+}
+
+func newStatementError(pos Position, statement string, err error) error {
+	return &StatementError{Position: pos, Statement: statement, Err: err}
+}
+
+func (err *StatementError) Error() string {
+	return fmt.Sprintf("%v: %v", err.Position, err.Err)
+}
+
+/* Convert an error to the value of the variable bound by catch, or sent to the systemError event source */
+func errorToMap(err error) map[string]interface{} {
+	ret := map[string]interface{}{
+		"message":   err.Error(),
+		"statement": "",
+		"position":  "",
+	}
+	var stmtErr *StatementError
+	if errors.As(err, &stmtErr) {
+		ret["message"] = stmtErr.Err.Error()
+		ret["statement"] = stmtErr.Statement
+		ret["position"] = stmtErr.Position.String()
+	}
+	return ret
+}
+
+/* Route an error not handled by a trigger to the triggers of the systemError event source, if any */
+func (p *Processor) routeSystemError(ev *evaluation, message map[string]interface{}, trigger *Trigger, err error) {
+	if ev.result.EventSource == SYSTEMERROR {
+		/* don't route errors in handling errors */
+		return
+	}
+	if _, ok := p.triggerDef.EventTriggers[SYSTEMERROR]; !ok {
+		return
+	}
+	body := errorToMap(err)
+	body["eventSource"] = ev.result.EventSource
+	body["eventID"] = ev.result.EventID
+	body["trigger"] = ev.triggerIndex
+	body["triggerPosition"] = trigger.Position.String()
+	body["event"] = message
+	errorMessage := map[string]interface{}{
+		HEADER: map[string]interface{}{EVENTIDHEADER: []interface{}{ev.result.EventID + "-" + SYSTEMERROR}},
+		BODY:   body,
+	}
+	_, result, err := p.ProcessMessage(errorMessage, SYSTEMERROR)
+	if err != nil {
+		klog.Errorf("Error processing %v event for event %v: %v", SYSTEMERROR, ev.result.EventID, err)
+	}
+	ev.result.SystemError = result
+}
+
+/* Convert a value recovered from a panic while processing the event to an error, and log the stack */
+func (ev *evaluation) recoverPanic(r interface{}) error {
+	klog.Errorf("Recovered from panic processing event %v from %v with the trigger at %v: %v\n%s", ev.result.EventID, ev.result.EventSource, ev.position, r, debug.Stack())
+	return fmt.Errorf("internal error: %v", r)
+}
+
+/* stopError ends the evaluation of the current trigger without error */
+type stopError struct {
+	reason   string
+	position Position
+}
+
+func (err *stopError) Error() string {
+	return fmt.Sprintf("%v: trigger stopped: %v", err.position, err.reason)
+}
+
+/* returnError ends the evaluation of a function with a return value */
+type returnError struct {
+	value ref.Val
+}
+
+func (err *returnError) Error() string {
+	return fmt.Sprintf("return %v outside of a function", err.value)
+}
+
+/* Record that the current trigger is ended by a stop or fail statement */
+func (ev *evaluation) recordExit(kind string, reason string, pos Position) {
+	ev.result.Exits = append(ev.result.Exits, &TriggerExit{Trigger: ev.triggerIndex, Kind: kind, Reason: reason, Position: pos.String()})
+}
+
+/* Set up the evaluation to process a trigger */
+func (ev *evaluation) startTrigger(index int, trigger *Trigger) {
+	ev.triggerIndex = index
+	ev.position = trigger.Position
+	ev.exports = trigger.Export
+	ev.tracing = ev.traceEvent || trigger.Trace
+	ev.redacting = false
+	if ev.tracing && ev.trace == nil {
+		ev.trace = &Trace{
+			EventID:     ev.result.EventID,
+			EventSource: ev.result.EventSource,
+			Start:       time.Now(),
+			Entries:     make([]*TraceEntry, 0),
+		}
+		ev.result.Trace = ev.trace
+	}
+	ev.addTraceEntry(&TraceEntry{Kind: traceTrigger, Statement: ev.result.EventSource})
+}
+
+/* Persist the dry run result as a ConfigMap and/or publish it to a destination, if configured in the settings */
+func (p *Processor) publishDryRunResult(result *EvaluationResult) error {
+	configMapName := p.triggerDef.getSettingString(DRYRUNCONFIGMAP)
+	destination := p.triggerDef.getSettingString(DRYRUNDESTINATION)
+	if configMapName == "" && destination == "" {
+		return nil
+	}
+	if p.env == nil {
+		return fmt.Errorf("unable to publish dryrun result: no environment")
+	}
+
+	buf, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("unable to marshal dryrun result to JSON: %v", err)
+	}
+
+	if configMapName != "" {
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      utils.ToDomainName(configMapName + "-" + result.EventID),
+				Namespace: utils.GetKabaneroNamespace(),
+				Labels:    map[string]string{DRYRUNLABEL: "true"},
+			},
+			Data: map[string]string{DRYRUNRESULTKEY: string(buf)},
+		}
+		configMaps := p.env.KubeClient.CoreV1().ConfigMaps(configMap.Namespace)
+		_, err = configMaps.Create(configMap)
+		if apierrors.IsAlreadyExists(err) {
+			/* the event was delivered again. Replace the result of the previous delivery. */
+			_, err = configMaps.Update(configMap)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to create dryrun ConfigMap %s/%s: %v", configMap.Namespace, configMap.Name, err)
+		}
+		if klog.V(2) {
+			klog.Infof("Dryrun result saved in ConfigMap %s/%s", configMap.Namespace, configMap.Name)
+		}
+	}
+
+	if destination != "" {
+		err = p.env.MessageService.Send(destination, buf, nil)
+		if err != nil {
+			return fmt.Errorf("unable to send dryrun result to destination %s: %v", destination, err)
+		}
+		if klog.V(2) {
+			klog.Infof("Dryrun result sent to destination %s", destination)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/go-github/github"
+	"github.com/kabanero-io/kabanero-events/pkg/utils"
+)
+
+// SetGitHubAPIURL sets the URL of the GitHub API used by the GitHub functions of triggers, such as a proxy or a test server.
+// By default, it is the API of the host of the repository of the event.
+func (p *Processor) SetGitHubAPIURL(url string) {
+	if url != "" && !strings.HasSuffix(url, "/") {
+		url += "/"
+	}
+	p.gitHubAPIURL = url
+}
+
+/* repositoryContent is a path of the repository of an event, at the commit of the event */
+type repositoryContent struct {
+	exists  bool
+	isDir   bool
+	content string        // content of a file
+	entries []interface{} // entries of a directory: maps of name, path, type, and size
+}
+
+/* Return the header and body of a webhook message passed to a GitHub function */
+func webhookMessage(function string, messageVal ref.Val) (map[string][]string, map[string]interface{}, ref.Val) {
+	native, err := refToNative(messageVal)
+	if err != nil {
+		return nil, nil, types.NewErr("function %v: %v", function, err)
+	}
+	message, ok := native.(map[string]interface{})
+	if !ok {
+		return nil, nil, types.ValOrErr(messageVal, "unexpected type '%v' passed as first parameter to function %v", messageVal.Type(), function)
+	}
+	body, ok := message[BODY].(map[string]interface{})
+	if !ok {
+		return nil, nil, types.NewErr("function %v: the message has no body", function)
+	}
+	header, err := convertToHeaderMap(message[HEADER])
+	if err != nil {
+		return nil, nil, types.NewErr("function %v: invalid header of the message: %v", function, err)
+	}
+	return header, body, nil
+}
+
+/* Return the repository of a webhook message passed to a GitHub function */
+func (p *Processor) messageRepository(function string, header map[string][]string, body map[string]interface{}) (*utils.GitHubRepository, ref.Val) {
+	repo, err := utils.GetGitHubRepository(header, body)
+	if err != nil {
+		return nil, types.NewErr("function %v: %v", function, err)
+	}
+	if p.gitHubAPIURL != "" {
+		repo.APIURL = p.gitHubAPIURL
+	}
+	return repo, nil
+}
+
+/* Return a client of the GitHub API for the repository. The GitHub secret is read before the deadline of the context. */
+func (p *Processor) gitHubClient(ctx context.Context, repo *utils.GitHubRepository) (*github.Client, error) {
+	if p.env == nil || p.env.KubeClient == nil {
+		return nil, fmt.Errorf("no Kubernetes client is available to read the GitHub secret")
+	}
+	return utils.NewGitHubClient(p.kubeClient(), repo)
+}
+
+/*
+Return the file or directory at the path of the repository of the message. Paths read while processing an event are cached,
+so that the file functions read each path of a commit from GitHub once.
+*/
+func (p *Processor) getRepositoryContent(ev *evaluation, function string, messageVal ref.Val, pathVal ref.Val) (*repositoryContent, ref.Val) {
+	header, body, errVal := webhookMessage(function, messageVal)
+	if errVal != nil {
+		return nil, errVal
+	}
+	repo, errVal := p.messageRepository(function, header, body)
+	if errVal != nil {
+		return nil, errVal
+	}
+	path, errVal := stringParam(function, "second", pathVal)
+	if errVal != nil {
+		return nil, errVal
+	}
+	path = strings.Trim(path, "/")
+	if path == "." {
+		path = ""
+	}
+	key := fmt.Sprintf("github %v/%v/%v@%v:%v", repo.APIURL, repo.Owner, repo.Name, repo.Ref, path)
+	obj, err := ev.cachedLookup(key, func() (interface{}, error) {
+		client, err := p.gitHubClient(ev.ctx, repo)
+		if err != nil {
+			return nil, err
+		}
+		file, dir, exists, err := utils.GetGitHubContents(ev.ctx, client, repo, path)
+		if err != nil {
+			return nil, err
+		}
+		ret := &repositoryContent{exists: exists}
+		if file != nil {
+			if ret.content, err = file.GetContent(); err != nil {
+				return nil, err
+			}
+		} else if exists {
+			ret.isDir = true
+			ret.entries = make([]interface{}, len(dir))
+			for index, entry := range dir {
+				ret.entries[index] = map[string]interface{}{
+					"name": entry.GetName(),
+					"path": entry.GetPath(),
+					"type": entry.GetType(),
+					"size": int64(entry.GetSize()),
+				}
+			}
+		}
+		return ret, nil
+	})
+	if err != nil {
+		return nil, types.NewErr("function %v: %v", function, err)
+	}
+	return obj.(*repositoryContent), nil
+}
+
+/* Return the content of the file at the path of the repository of the message */
+func (p *Processor) getRepositoryFile(ev *evaluation, function string, messageVal ref.Val, pathVal ref.Val) (string, ref.Val) {
+	content, errVal := p.getRepositoryContent(ev, function, messageVal, pathVal)
+	if errVal != nil {
+		return "", errVal
+	}
+	if !content.exists {
+		return "", types.NewErr("function %v: file %v not found", function, pathVal)
+	}
+	if content.isDir {
+		return "", types.NewErr("function %v: %v is a directory", function, pathVal)
+	}
+	return content.content, nil
+}
+
+/* implementation of downloadFile(message, path): the content of a file of the repository, at the commit of the event */
+func (p *Processor) downloadFileCEL(ev *evaluation, messageVal ref.Val, pathVal ref.Val) ref.Val {
+	content, errVal := p.getRepositoryFile(ev, "downloadFile", messageVal, pathVal)
+	if errVal != nil {
+		return errVal
+	}
+	return types.String(content)
+}
+
+/* implementation of downloadJSON(message, path): the parsed JSON file of the repository, at the commit of the event */
+func (p *Processor) downloadJSONCEL(ev *evaluation, messageVal ref.Val, pathVal ref.Val) ref.Val {
+	content, errVal := p.getRepositoryFile(ev, "downloadJSON", messageVal, pathVal)
+	if errVal != nil {
+		return errVal
+	}
+	var native interface{}
+	if err := json.Unmarshal([]byte(content), &native); err != nil {
+		return types.NewErr("function downloadJSON: invalid JSON in %v: %v", pathVal, err)
+	}
+	return types.DefaultTypeAdapter.NativeToValue(native)
+}
+
+/* implementation of listDirectory(message, path): the entries of a directory of the repository, at the commit of the event */
+func (p *Processor) listDirectoryCEL(ev *evaluation, messageVal ref.Val, pathVal ref.Val) ref.Val {
+	content, errVal := p.getRepositoryContent(ev, "listDirectory", messageVal, pathVal)
+	if errVal != nil {
+		return errVal
+	}
+	if !content.exists {
+		return types.NewErr("function listDirectory: directory %v not found", pathVal)
+	}
+	if !content.isDir {
+		return types.NewErr("function listDirectory: %v is not a directory", pathVal)
+	}
+	return types.NewDynamicList(types.DefaultTypeAdapter, content.entries)
+}
+
+/* implementation of fileExists(message, path): whether the file or directory exists in the repository, at the commit of the event */
+func (p *Processor) fileExistsCEL(ev *evaluation, messageVal ref.Val, pathVal ref.Val) ref.Val {
+	content, errVal := p.getRepositoryContent(ev, "fileExists", messageVal, pathVal)
+	if errVal != nil {
+		return errVal
+	}
+	return types.Bool(content.exists)
+}
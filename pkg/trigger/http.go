@@ -0,0 +1,350 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/kabanero-io/kabanero-events/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+/* constants for HTTP settings */
+const (
+	HTTPALLOWEDHOSTS = "httpAllowedHosts"
+	HTTPTIMEOUT      = "httpTimeout"
+	HTTPCREDENTIALS  = "httpCredentials"
+)
+
+/* default time allowed for one HTTP request */
+const defaultHTTPTimeout = 10 * time.Second
+
+/* maximum size of the body of an HTTP response */
+const maxHTTPResponseSize = 1024 * 1024
+
+// SetHTTPTransport sets the transport of the HTTP functions of triggers, such as one trusting the certificate of a test
+// server. By default, it is the default transport of the net/http package.
+func (p *Processor) SetHTTPTransport(transport http.RoundTripper) {
+	p.httpTransport = transport
+}
+
+/* Return whether the host matches a pattern: a host name, or *.domain for the hosts of the domain */
+func hostMatches(host string, pattern string) bool {
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+/* Return an error unless the URL is an http or https URL to a host allowed by the httpAllowedHosts setting */
+func (p *Processor) checkHTTPURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme in URL %v. Expecting http or https", u)
+	}
+	for _, pattern := range p.triggerDef.getSettingStrings(HTTPALLOWEDHOSTS) {
+		if hostMatches(u.Hostname(), pattern) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %v is not allowed. Add it to the %v setting", u.Hostname(), HTTPALLOWEDHOSTS)
+}
+
+/* httpCredential is an entry of the httpCredentials setting: the Secret whose credentials are added to the requests to a host */
+type httpCredential struct {
+	host   string // host name, or *.domain
+	secret string // name of the Secret in the Kabanero namespace
+	header string // header set to the token of the Secret, or empty for the Authorization header
+}
+
+/* Return the entry of the httpCredentials setting for the host, or nil if none */
+func (p *Processor) getHTTPCredential(host string) (*httpCredential, error) {
+	list, ok := p.triggerDef.getSetting(HTTPCREDENTIALS).([]interface{})
+	if !ok {
+		return nil, nil
+	}
+	for _, item := range list {
+		obj, ok := item.(*Object)
+		if !ok {
+			return nil, fmt.Errorf("invalid entry %v of the %v setting. Expecting host and secret", item, HTTPCREDENTIALS)
+		}
+		credential := &httpCredential{}
+		for _, entry := range obj.Entries {
+			value, ok := entry.Value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%v: the value of %v is not a string", entry.Position, entry.Key)
+			}
+			switch entry.Key {
+			case "host":
+				credential.host = value
+			case "secret":
+				credential.secret = value
+			case "header":
+				credential.header = value
+			default:
+				return nil, fmt.Errorf("%v: unknown key %v in the %v setting", entry.Position, entry.Key, HTTPCREDENTIALS)
+			}
+		}
+		if credential.host == "" || credential.secret == "" {
+			return nil, fmt.Errorf("%v: entry of the %v setting without host or secret", obj.Position, HTTPCREDENTIALS)
+		}
+		if hostMatches(host, credential.host) {
+			return credential, nil
+		}
+	}
+	return nil, nil
+}
+
+/*
+Add the credentials for the host of the request from its Secret in the Kabanero namespace. A Secret with a token sets the header
+of the credential to the token, or the Authorization header to a bearer token. A Secret with a username and password uses basic authentication.
+Credentials are only sent over https, so that they are not sent in clear text.
+*/
+func (p *Processor) addHTTPCredentials(ev *evaluation, req *http.Request) error {
+	credential, err := p.getHTTPCredential(req.URL.Hostname())
+	if err != nil || credential == nil {
+		return err
+	}
+	if req.URL.Scheme != "https" {
+		return fmt.Errorf("the credentials of secret %v are only sent to https URLs, but the URL is %v", credential.secret, req.URL)
+	}
+	if p.env == nil || p.env.KubeClient == nil {
+		return fmt.Errorf("unable to read secret %v: no Kubernetes client is available", credential.secret)
+	}
+	namespace := utils.GetKabaneroNamespace()
+	obj, err := ev.callClient(fmt.Sprintf("get of secret %v/%v", namespace, credential.secret), func() (interface{}, error) {
+		return p.kubeClient().CoreV1().Secrets(namespace).Get(credential.secret, metav1.GetOptions{})
+	})
+	if err != nil {
+		return fmt.Errorf("unable to read secret %v/%v: %v", namespace, credential.secret, err)
+	}
+	secret := obj.(*corev1.Secret)
+	if token, ok := secret.Data["token"]; ok {
+		if credential.header != "" {
+			req.Header.Set(credential.header, string(token))
+		} else {
+			req.Header.Set("Authorization", "Bearer "+string(token))
+		}
+		return nil
+	}
+	username, hasUsername := secret.Data["username"]
+	password, hasPassword := secret.Data["password"]
+	if !hasUsername || !hasPassword {
+		return fmt.Errorf("secret %v/%v has neither a token, nor a username and password", namespace, credential.secret)
+	}
+	req.SetBasicAuth(string(username), string(password))
+	return nil
+}
+
+/* Return the headers parameter of an HTTP function */
+func httpHeadersParam(function string, position string, val ref.Val) (map[string]string, ref.Val) {
+	mapper, ok := val.(traits.Mapper)
+	if !ok {
+		return nil, types.ValOrErr(val, "unexpected type '%v' passed as %v parameter to function %v", val.Type(), position, function)
+	}
+	keys, items, err := getIterationItems(mapper)
+	if err != nil {
+		return nil, types.NewErr("function %v: %v", function, err)
+	}
+	headers := make(map[string]string)
+	for index, key := range keys {
+		name, nameOk := key.(types.String)
+		value, valueOk := items[index].(types.String)
+		if !nameOk || !valueOk {
+			return nil, types.NewErr("function %v: headers must be a map of strings to strings", function)
+		}
+		headers[string(name)] = string(value)
+	}
+	return headers, nil
+}
+
+/* Send an HTTP request, and return its status, headers, and body. The body is parsed if it is JSON. */
+func (p *Processor) sendHTTPRequest(ev *evaluation, function string, method string, urlStr string, body io.Reader, headers map[string]string) (map[string]interface{}, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %v: %v", urlStr, err)
+	}
+	if err = p.checkHTTPURL(u); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ev.ctx, p.triggerDef.getSettingDuration(HTTPTIMEOUT, defaultHTTPTimeout))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	if err = p.addHTTPCredentials(ev, req); err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Transport: p.httpTransport,
+		CheckRedirect: func(redirect *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			/* credentials of the original host are not sent to another host or port, nor in clear text */
+			if redirect.URL.Host != u.Host || redirect.URL.Scheme != "https" {
+				redirect.Header.Del("Authorization")
+				if credential, _ := p.getHTTPCredential(u.Hostname()); credential != nil && credential.header != "" {
+					redirect.Header.Del(credential.header)
+				}
+			}
+			return p.checkHTTPURL(redirect.URL)
+		},
+	}
+	if klog.V(5) {
+		klog.Infof("%v: %v %v", function, method, u)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHTTPResponseSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response of %v: %v", u, err)
+	}
+	if len(buf) > maxHTTPResponseSize {
+		return nil, fmt.Errorf("response of %v is larger than %v bytes", u, maxHTTPResponseSize)
+	}
+
+	respHeaders := make(map[string]interface{})
+	for name, values := range resp.Header {
+		respHeaders[name] = strings.Join(values, ", ")
+	}
+	var respBody interface{} = string(buf)
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); strings.HasSuffix(mediaType, "json") && len(buf) > 0 {
+		if err = json.Unmarshal(buf, &respBody); err != nil {
+			return nil, fmt.Errorf("invalid JSON in response of %v: %v", u, err)
+		}
+	}
+	return map[string]interface{}{
+		"status":  int64(resp.StatusCode),
+		"headers": respHeaders,
+		"body":    respBody,
+	}, nil
+}
+
+/* implementation of httpGet(url, headers) */
+func (p *Processor) httpGetCEL(ev *evaluation, urlVal ref.Val, headersVal ref.Val) ref.Val {
+	urlStr, errVal := stringParam("httpGet", "first", urlVal)
+	if errVal != nil {
+		return errVal
+	}
+	headers, errVal := httpHeadersParam("httpGet", "second", headersVal)
+	if errVal != nil {
+		return errVal
+	}
+	resp, err := p.sendHTTPRequest(ev, "httpGet", http.MethodGet, urlStr, nil, headers)
+	if err != nil {
+		return types.NewErr("function httpGet: %v", err)
+	}
+	return types.DefaultTypeAdapter.NativeToValue(resp)
+}
+
+/* implementation of httpPost(url, body, headers). A body that is not a string is sent as JSON. */
+func (p *Processor) httpPostCEL(ev *evaluation, values ...ref.Val) ref.Val {
+	if len(values) != 3 {
+		return types.NewErr("function httpPost expects 3 parameters, but got %v", len(values))
+	}
+	urlStr, errVal := stringParam("httpPost", "first", values[0])
+	if errVal != nil {
+		return errVal
+	}
+	headers, errVal := httpHeadersParam("httpPost", "third", values[2])
+	if errVal != nil {
+		return errVal
+	}
+	var body []byte
+	if str, ok := values[1].(types.String); ok {
+		body = []byte(str)
+	} else {
+		native, err := refToNative(values[1])
+		if err != nil {
+			return types.NewErr("function httpPost: %v", err)
+		}
+		if body, err = json.Marshal(native); err != nil {
+			return types.NewErr("function httpPost: %v", err)
+		}
+		if _, ok := headers["Content-Type"]; !ok {
+			headers["Content-Type"] = "application/json"
+		}
+	}
+	if p.triggerDef.isDryRun() {
+		return p.recordDryRunPost(ev, urlStr, body, headers)
+	}
+	resp, err := p.sendHTTPRequest(ev, "httpPost", http.MethodPost, urlStr, bytes.NewReader(body), headers)
+	if err != nil {
+		return types.NewErr("function httpPost: %v", err)
+	}
+	return types.DefaultTypeAdapter.NativeToValue(resp)
+}
+
+/*
+Record a POST request that is not sent because dryrun is set, as an event sent to the URL. The URL is checked as if the
+request were sent. Return a response with status 0, as there is no response.
+*/
+func (p *Processor) recordDryRunPost(ev *evaluation, urlStr string, body []byte, headers map[string]string) ref.Val {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return types.NewErr("function httpPost: invalid URL %v: %v", urlStr, err)
+	}
+	if err = p.checkHTTPURL(u); err != nil {
+		return types.NewErr("function httpPost: %v", err)
+	}
+	header := make(map[string][]string)
+	for name, value := range headers {
+		header[name] = []string{value}
+	}
+	/* a body sent as JSON is recorded as the value it was marshalled from */
+	var payload interface{} = string(body)
+	if headers["Content-Type"] == "application/json" {
+		if err = json.Unmarshal(body, &payload); err != nil {
+			payload = string(body)
+		}
+	}
+	ev.result.Events = append(ev.result.Events, &DryRunEvent{
+		Destination: u.String(),
+		Payload:     payload,
+		Header:      header,
+	})
+	klog.Infof("httpPost: dryrun is set. Request was not sent to %v", u)
+	return types.DefaultTypeAdapter.NativeToValue(map[string]interface{}{
+		"status":  int64(0),
+		"headers": map[string]interface{}{},
+		"body":    "",
+	})
+}
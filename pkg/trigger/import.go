@@ -0,0 +1,342 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"fmt"
+	"github.com/kabanero-io/kabanero-events/pkg/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"k8s.io/klog"
+)
+
+/* constants for imports and constants sections */
+const (
+	IMPORTS   = "imports"
+	CONSTANTS = "constants"
+	PATH      = "path"
+	ARCHIVE   = "archive"
+	SHA256    = "sha256"
+)
+
+/* value of a constant, evaluated when the trigger definition is loaded */
+type constantValue struct {
+	name      string
+	namespace string
+	value     ref.Val
+}
+
+/* Return a name qualified by a namespace */
+func qualifiedName(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+/* Read the libraries in the imports section of a trigger file. Functions and constants of a library are qualified by the import name. */
+func (td *EventTriggerDefinition) readImports(fileName string, yamlObj *Object, namespace string, importing []string) error {
+	importsObj, ok := yamlObj.Get(IMPORTS)
+	if !ok {
+		return nil
+	}
+	importsArray, ok := importsObj.([]interface{})
+	if !ok {
+		return fmt.Errorf("%v: %v is not an array but %T", yamlObj.GetEntry(IMPORTS).Position, IMPORTS, importsObj)
+	}
+
+	for _, importObj := range importsArray {
+		importMap, ok := importObj.(*Object)
+		if !ok {
+			return fmt.Errorf("%v: import %v is not an object but %T", yamlObj.GetEntry(IMPORTS).Position, importObj, importObj)
+		}
+		if err := checkKeys(importMap, "import", NAME, PATH, ARCHIVE, SHA256); err != nil {
+			return err
+		}
+		name, err := getString(importMap, NAME)
+		if err != nil {
+			return err
+		}
+		if name == "" || strings.Contains(name, ".") {
+			return fmt.Errorf("%v: name of import %v must not be empty or contain '.'", importMap.Position, name)
+		}
+		if namespace == "" && name == PARAMS {
+			return fmt.Errorf("%v: %v is reserved for the parameters of the trigger collection", importMap.Position, PARAMS)
+		}
+
+		files, tempDir, err := resolveImport(fileName, importMap)
+		if err != nil {
+			return err
+		}
+		err = td.readImportedFiles(importMap, files, qualifiedName(namespace, name), importing)
+		if tempDir != "" {
+			/* the files of an archive are no longer needed once read */
+			os.RemoveAll(tempDir)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* Read the trigger files of an import into the namespace of the library */
+func (td *EventTriggerDefinition) readImportedFiles(importMap *Object, files []string, libNamespace string, importing []string) error {
+	for _, libFile := range files {
+		absPath, err := filepath.Abs(libFile)
+		if err != nil {
+			return err
+		}
+		for _, imported := range importing {
+			if imported == absPath {
+				return fmt.Errorf("%v: import of %v is circular", importMap.Position, libFile)
+			}
+		}
+		if klog.V(5) {
+			klog.Infof("Importing %v as %v", libFile, libNamespace)
+		}
+		err = readTriggerFile(libFile, td, libNamespace, append(importing, absPath))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Return the trigger files of an import: a file or directory relative to the importing file, or a gzipped tar archive. The files
+of an archive are extracted into a temporary directory, which is also returned, to be removed by the caller.
+*/
+func resolveImport(fileName string, importMap *Object) ([]string, string, error) {
+	_, hasPath := importMap.Get(PATH)
+	_, hasArchive := importMap.Get(ARCHIVE)
+	if hasPath == hasArchive {
+		return nil, "", fmt.Errorf("%v: import must contain one of %v or %v", importMap.Position, PATH, ARCHIVE)
+	}
+
+	baseDir := filepath.Dir(fileName)
+	var dir, tempDir string
+	if hasPath {
+		path, err := getString(importMap, PATH)
+		if err != nil {
+			return nil, "", err
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, "", fmt.Errorf("%v: unable to import %v: %v", importMap.GetEntry(PATH).Position, path, err)
+		}
+		if !info.IsDir() {
+			return []string{path}, "", nil
+		}
+		dir = path
+	} else {
+		archive, err := getString(importMap, ARCHIVE)
+		if err != nil {
+			return nil, "", err
+		}
+		chkSum := ""
+		if _, ok := importMap.Get(SHA256); ok {
+			if chkSum, err = getString(importMap, SHA256); err != nil {
+				return nil, "", err
+			}
+		}
+		dir, err = extractArchive(baseDir, archive, chkSum)
+		if err != nil {
+			return nil, "", fmt.Errorf("%v: unable to import archive %v: %v", importMap.GetEntry(ARCHIVE).Position, archive, err)
+		}
+		tempDir = dir
+	}
+
+	files, err := findFiles(dir, []string{".yaml", ".yml"})
+	if err == nil && len(files) == 0 {
+		err = fmt.Errorf("%v: unable to locate trigger files to import at directory %v", importMap.Position, dir)
+	}
+	if err != nil {
+		if tempDir != "" {
+			os.RemoveAll(tempDir)
+		}
+		return nil, "", err
+	}
+	return files, tempDir, nil
+}
+
+/*
+Extract an archive into a temporary directory, and return the directory. An archive downloaded from a URL must have a
+checksum, as its contents are not otherwise known. The directory is removed if the archive can not be extracted.
+*/
+func extractArchive(baseDir string, archive string, chkSum string) (string, error) {
+	remote := strings.HasPrefix(archive, "http://") || strings.HasPrefix(archive, "https://")
+	if remote && chkSum == "" {
+		return "", fmt.Errorf("the %v checksum of the archive is required to import it from a URL", SHA256)
+	}
+	dir, err := ioutil.TempDir("", "trigger-import")
+	if err != nil {
+		return "", err
+	}
+	if remote {
+		err = utils.DownloadTrigger(archive, chkSum, dir, true)
+	} else {
+		err = extractLocalArchive(baseDir, archive, dir)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+/* Extract an archive relative to the importing file into a directory */
+func extractLocalArchive(baseDir string, archive string, dir string) error {
+	if !filepath.IsAbs(archive) {
+		archive = filepath.Join(baseDir, archive)
+	}
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return utils.DecompressGzipTar(file, dir)
+}
+
+/* Read the constants section of a trigger file */
+func (td *EventTriggerDefinition) readConstants(yamlObj *Object, namespace string) error {
+	constantsObj, ok := yamlObj.Get(CONSTANTS)
+	if !ok {
+		return nil
+	}
+	constants, ok := constantsObj.(*Object)
+	if !ok {
+		return fmt.Errorf("%v: %v is not an object but %T", yamlObj.GetEntry(CONSTANTS).Position, CONSTANTS, constantsObj)
+	}
+	ps, err := newParser()
+	if err != nil {
+		return err
+	}
+	for _, entry := range constants.Entries {
+		if strings.Contains(entry.Key, ".") {
+			return fmt.Errorf("%v: name of constant %v must not contain '.'", entry.Position, entry.Key)
+		}
+		if namespace == "" && entry.Key == PARAMS {
+			return fmt.Errorf("%v: %v is reserved for the parameters of the trigger collection", entry.Position, PARAMS)
+		}
+		expression, err := ps.parseExpression(entry)
+		if err != nil {
+			return err
+		}
+		name := qualifiedName(namespace, entry.Key)
+		for _, existing := range td.Constants {
+			if existing.Name == name {
+				return fmt.Errorf("%v: constant %v redeclared, previously declared at %v", entry.Position, name, existing.Position)
+			}
+		}
+		td.Constants = append(td.Constants, &Constant{Name: name, Namespace: namespace, Expression: expression, Position: entry.Position})
+	}
+	return nil
+}
+
+/*
+Evaluate the constants in the order they are declared. Constants may refer to constants declared before them. The constants
+of a library may refer to each other, and call the functions of the library, without the namespace of the library.
+*/
+func (p *Processor) evaluateConstants() error {
+	p.constants = make([]*constantValue, 0, len(p.triggerDef.Constants))
+	ev := p.newEvaluation(make(map[string]interface{}), CONSTANTS)
+	for _, constant := range p.triggerDef.Constants {
+		env, err := p.initializeEmptyCELEnv()
+		if err != nil {
+			return err
+		}
+		variables := make(map[string]interface{})
+		env, err = p.addConstants(env, variables, nil, constant.Namespace)
+		if err != nil {
+			return fmt.Errorf("%v: %v", constant.Position, err)
+		}
+		ev.namespace = constant.Namespace
+		out, err := p.evalExpression(ev, env, constant.Name, constant.Expression, variables)
+		if err != nil {
+			return fmt.Errorf("%v: %v", constant.Position, err)
+		}
+		p.constants = append(p.constants, &constantValue{name: constant.Name, namespace: constant.Namespace, value: out})
+		if klog.V(4) {
+			klog.Infof("constant %v set to %v", constant.Name, out)
+		}
+	}
+	return nil
+}
+
+/* Return the constants of the library with the given namespace, keyed by their names within the library */
+func (p *Processor) libraryConstants(namespace string) map[string]ref.Val {
+	local := make(map[string]ref.Val)
+	if namespace == "" {
+		return local
+	}
+	for _, constant := range p.constants {
+		if constant.namespace == namespace {
+			local[strings.TrimPrefix(constant.name, namespace+".")] = constant.value
+		}
+	}
+	return local
+}
+
+/*
+Add the constants as variables, except those whose top level name is hidden. The constants of the library with the given
+namespace are also added without the namespace, so that a library does not depend on the name it is imported as. They hide
+other constants with the same top level name.
+*/
+func (p *Processor) addConstants(env cel.Env, variables map[string]interface{}, hidden map[string]bool, namespace string) (cel.Env, error) {
+	local := p.libraryConstants(namespace)
+	var err error
+	for _, constant := range p.constants {
+		topName := strings.Split(constant.name, ".")[0]
+		if _, ok := local[topName]; ok || hidden[topName] {
+			continue
+		}
+		if env, err = addConstant(env, variables, constant.name, constant.value); err != nil {
+			return env, err
+		}
+	}
+	for name, value := range local {
+		if hidden[name] {
+			continue
+		}
+		if env, err = addConstant(env, variables, name, value); err != nil {
+			return env, err
+		}
+	}
+	return env, nil
+}
+
+func addConstant(env cel.Env, variables map[string]interface{}, name string, value ref.Val) (cel.Env, error) {
+	if mapValue, ok := value.Value().(map[string]interface{}); ok {
+		/* copy so that changes made while processing an event are not seen by other events */
+		value = types.NewDynamicMap(types.DefaultTypeAdapter, deepCopy(mapValue))
+	}
+	env, err := createOneVariable(env, name, "", value, variables)
+	if err != nil {
+		return env, fmt.Errorf("unable to set constant %v: %v", name, err)
+	}
+	return env, nil
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/packages"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
+	"github.com/google/cel-go/interpreter/functions"
+	"k8s.io/klog"
+)
+
+/* number of steps of an expression between checks of the deadline of the event */
+const deadlineCheckInterval = 64
+
+/* Set the deadline for processing the event. Return the function releasing the resources of the context. */
+func (ev *evaluation) startDeadline(timeout time.Duration) context.CancelFunc {
+	var cancel context.CancelFunc
+	ev.timeout = timeout
+	ev.ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	return cancel
+}
+
+/* Return an error if the deadline for processing the event has passed */
+func (ev *evaluation) checkDeadline() error {
+	if ev.ctx.Err() == nil {
+		return nil
+	}
+	return fmt.Errorf("processing of event timed out after %v", ev.timeout)
+}
+
+/* costTracker counts the steps of the evaluation of an expression, and ends it when the cost limit is exceeded or the deadline has passed */
+type costTracker struct {
+	ev    *evaluation
+	limit int
+	cost  int
+	err   error // reason the evaluation was ended
+}
+
+/* costInterpretable counts the evaluations of a step of an expression */
+type costInterpretable struct {
+	interpreter.Interpretable
+	tracker *costTracker
+}
+
+func (i *costInterpretable) Eval(activation interpreter.Activation) ref.Val {
+	if err := i.tracker.step(); err != nil {
+		return types.NewErr("%v", err)
+	}
+	return i.Interpretable.Eval(activation)
+}
+
+/* Decorate each step of an expression to count its evaluations */
+func (tracker *costTracker) decorator() interpreter.InterpretableDecorator {
+	return func(i interpreter.Interpretable) (interpreter.Interpretable, error) {
+		return &costInterpretable{Interpretable: i, tracker: tracker}, nil
+	}
+}
+
+func (tracker *costTracker) step() error {
+	if tracker.err != nil {
+		return tracker.err
+	}
+	tracker.cost++
+	if tracker.cost > tracker.limit {
+		tracker.err = fmt.Errorf("expression exceeded the cost limit of %v", tracker.limit)
+	} else if tracker.cost%deadlineCheckInterval == 0 {
+		tracker.err = tracker.ev.checkDeadline()
+	}
+	return tracker.err
+}
+
+/* Evaluate a checked expression with the functions of the evaluation, bounded by the cost limit and the deadline of the event */
+func (p *Processor) evalProgram(ev *evaluation, env cel.Env, checked cel.Ast, variables map[string]interface{}) (out ref.Val, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = ev.recoverPanic(r)
+		}
+	}()
+	if err = ev.checkDeadline(); err != nil {
+		return nil, err
+	}
+	checkedExpr, err := cel.AstToCheckedExpr(checked)
+	if err != nil {
+		return nil, err
+	}
+	dispatcher := interpreter.NewDispatcher()
+	if err = dispatcher.Add(functions.StandardOverloads()...); err != nil {
+		return nil, err
+	}
+	if err = dispatcher.Add(ev.funcs...); err != nil {
+		return nil, err
+	}
+	interp := interpreter.NewInterpreter(dispatcher, packages.DefaultPackage, env.TypeProvider(), env.TypeAdapter())
+	/* expressions evaluated by functions of the expression, such as filter and call, count against the cost of the expression */
+	tracker := ev.costTracker
+	if tracker == nil {
+		tracker = &costTracker{ev: ev, limit: p.triggerDef.getSettingInt(MAXCOST, defaultMaxCost)}
+		ev.costTracker = tracker
+		defer func() {
+			ev.costTracker = nil
+		}()
+	}
+	interpretable, err := interp.NewInterpretable(checkedExpr, tracker.decorator())
+	if err != nil {
+		return nil, err
+	}
+	activation, err := interpreter.NewAdaptingActivation(env.TypeAdapter(), variables)
+	if err != nil {
+		return nil, err
+	}
+
+	out = interpretable.Eval(activation)
+	if tracker.err == nil {
+		/* the deadline may have passed in a function called by the expression */
+		tracker.err = ev.checkDeadline()
+	}
+	if tracker.err != nil {
+		if klog.V(4) {
+			klog.Infof("evaluation ended after %v steps: %v", tracker.cost, tracker.err)
+		}
+		return nil, tracker.err
+	}
+	if types.IsError(out) {
+		return nil, out.Value().(error)
+	}
+	return out, nil
+}
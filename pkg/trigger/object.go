@@ -0,0 +1,179 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"fmt"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Position is the location of an element in a trigger file.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (pos Position) String() string {
+	return fmt.Sprintf("%s:%d:%d", pos.File, pos.Line, pos.Column)
+}
+
+// ObjectEntry is one key/value pair of an Object.
+type ObjectEntry struct {
+	Key      string
+	Value    interface{} // string, int, float64, bool, nil, []interface{}, or *Object
+	Position Position    // position of the key
+}
+
+// Object is a YAML mapping read from a trigger file. Unlike a Go map, it preserves the order in which the keys
+// are declared, and the position of each key in the file.
+type Object struct {
+	Entries  []*ObjectEntry
+	Position Position
+}
+
+// NewObject creates an empty Object.
+func NewObject(pos Position) *Object {
+	return &Object{
+		Entries:  make([]*ObjectEntry, 0),
+		Position: pos,
+	}
+}
+
+// Get returns the value of a key, and whether the key exists.
+func (obj *Object) Get(key string) (interface{}, bool) {
+	if entry := obj.GetEntry(key); entry != nil {
+		return entry.Value, true
+	}
+	return nil, false
+}
+
+// GetEntry returns the entry for a key, or nil if the key does not exist.
+func (obj *Object) GetEntry(key string) *ObjectEntry {
+	for _, entry := range obj.Entries {
+		if entry.Key == key {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Len returns the number of keys in the Object.
+func (obj *Object) Len() int {
+	return len(obj.Entries)
+}
+
+func (obj *Object) String() string {
+	return fmt.Sprintf("%v", obj.ToMap())
+}
+
+// ToMap converts the Object, and any nested Object, to map[string]interface{}.
+func (obj *Object) ToMap() map[string]interface{} {
+	ret := make(map[string]interface{})
+	for _, entry := range obj.Entries {
+		ret[entry.Key] = toPlainValue(entry.Value)
+	}
+	return ret
+}
+
+/* Convert nested Object into maps */
+func toPlainValue(value interface{}) interface{} {
+	switch val := value.(type) {
+	case *Object:
+		return val.ToMap()
+	case []interface{}:
+		ret := make([]interface{}, 0, len(val))
+		for _, element := range val {
+			ret = append(ret, toPlainValue(element))
+		}
+		return ret
+	default:
+		return value
+	}
+}
+
+/* Read the content of a YAML file into an Object */
+func readObject(fileName string, buf []byte) (*Object, error) {
+	var doc yamlv3.Node
+	err := yamlv3.Unmarshal(buf, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal %v. Error: %v", fileName, err)
+	}
+	if len(doc.Content) == 0 {
+		/* empty file */
+		return NewObject(Position{File: fileName, Line: 1, Column: 1}), nil
+	}
+	value, err := convertNode(fileName, doc.Content[0])
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := value.(*Object)
+	if !ok {
+		return nil, fmt.Errorf("%v: content of file is not an object, but %T", nodePosition(fileName, doc.Content[0]), value)
+	}
+	return obj, nil
+}
+
+func nodePosition(fileName string, node *yamlv3.Node) Position {
+	return Position{File: fileName, Line: node.Line, Column: node.Column}
+}
+
+/* Convert a YAML node into a value for the trigger definition */
+func convertNode(fileName string, node *yamlv3.Node) (interface{}, error) {
+	switch node.Kind {
+	case yamlv3.MappingNode:
+		obj := NewObject(nodePosition(fileName, node))
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyNode := node.Content[i]
+			pos := nodePosition(fileName, keyNode)
+			if keyNode.Kind != yamlv3.ScalarNode {
+				return nil, fmt.Errorf("%v: key is not a string", pos)
+			}
+			if existing := obj.GetEntry(keyNode.Value); existing != nil {
+				return nil, fmt.Errorf("%v: key %v already declared at %v", pos, keyNode.Value, existing.Position)
+			}
+			value, err := convertNode(fileName, node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			obj.Entries = append(obj.Entries, &ObjectEntry{Key: keyNode.Value, Value: value, Position: pos})
+		}
+		return obj, nil
+	case yamlv3.SequenceNode:
+		ret := make([]interface{}, 0, len(node.Content))
+		for _, elementNode := range node.Content {
+			element, err := convertNode(fileName, elementNode)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, element)
+		}
+		return ret, nil
+	case yamlv3.ScalarNode:
+		var value interface{}
+		err := node.Decode(&value)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", nodePosition(fileName, node), err)
+		}
+		return value, nil
+	case yamlv3.AliasNode:
+		return convertNode(fileName, node.Alias)
+	default:
+		return nil, fmt.Errorf("%v: unsupported YAML node", nodePosition(fileName, node))
+	}
+}
@@ -0,0 +1,290 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"fmt"
+	"github.com/kabanero-io/kabanero-events/pkg/utils"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	yamlv3 "gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/klog"
+)
+
+/* constants for parameters of a trigger collection */
+const (
+	PARAMS              = "params"              // name of the variable holding the parameters
+	PARAMETERSCONFIGMAP = "parametersConfigMap" // setting for the name of the ConfigMap overriding the parameters
+)
+
+/* delay before watching the parameters ConfigMap again after the watch fails */
+const parametersRewatchDelay = 10 * time.Second
+
+/* Read the parameters section of a trigger file */
+func (td *EventTriggerDefinition) readParameters(yamlObj *Object, ps *parser) error {
+	if _, ok := yamlObj.Get(PARAMETERS); !ok {
+		return nil
+	}
+	array, err := getArray(yamlObj, PARAMETERS)
+	if err != nil {
+		return err
+	}
+	parameters, err := ps.parseParameterList(yamlObj, array)
+	if err != nil {
+		return err
+	}
+	for _, param := range parameters {
+		if strings.Contains(param.Name, ".") {
+			return fmt.Errorf("%v: name of parameter %v must not contain '.'", param.Position, param.Name)
+		}
+		if param.Default == "" {
+			return fmt.Errorf("%v: parameter %v must have a default", param.Position, param.Name)
+		}
+		for _, existing := range td.Parameters {
+			if existing.Name == param.Name {
+				return fmt.Errorf("%v: parameter %v redeclared, previously declared at %v", param.Position, param.Name, existing.Position)
+			}
+		}
+		td.Parameters = append(td.Parameters, param)
+	}
+	return nil
+}
+
+/* Evaluate the defaults of the parameters, and apply the overrides currently set */
+func (p *Processor) evaluateParameters() error {
+	p.paramDefaults = make(map[string]interface{})
+	if len(p.triggerDef.Parameters) == 0 {
+		return nil
+	}
+	env, err := p.initializeEmptyCELEnv()
+	if err != nil {
+		return err
+	}
+	ev := p.newEvaluation(map[string]interface{}{}, PARAMS)
+	for _, param := range p.triggerDef.Parameters {
+		out, err := p.evalExpression(ev, env, param.Name, param.Default, map[string]interface{}{})
+		if err != nil {
+			return fmt.Errorf("%v: error evaluating default of parameter %v: %v", param.Position, param.Name, err)
+		}
+		if param.Type != "" && param.Type != TYPEANY && param.Type != out.Type().TypeName() {
+			return fmt.Errorf("%v: default of parameter %v should be of type %v, but is of type %v", param.Position, param.Name, param.Type, out.Type().TypeName())
+		}
+		/* convert to the same native values as variables */
+		if _, err = createOneVariable(env, param.Name, param.Default, out, p.paramDefaults); err != nil {
+			return fmt.Errorf("%v: %v", param.Position, err)
+		}
+	}
+	return p.SetParameterOverrides(nil)
+}
+
+// SetParameterOverrides overrides the defaults of the parameters of the trigger collection. Each value is a string,
+// as stored in a ConfigMap. A parameter of type string takes the value as is. Values of other parameters are
+// parsed as YAML. If any value is invalid, the parameters are left unchanged and an error is returned.
+func (p *Processor) SetParameterOverrides(overrides map[string]string) error {
+	params := deepCopy(p.paramDefaults)
+	declared := make(map[string]*Parameter)
+	for _, param := range p.triggerDef.Parameters {
+		declared[param.Name] = param
+	}
+
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		param, ok := declared[key]
+		if !ok {
+			return fmt.Errorf("unable to override parameter %v: parameter not declared", key)
+		}
+		value, err := parseParameterValue(param, overrides[key])
+		if err != nil {
+			return fmt.Errorf("unable to override parameter %v: %v", key, err)
+		}
+		params[key] = value
+	}
+
+	p.paramsMutex.Lock()
+	p.params = params
+	p.paramsMutex.Unlock()
+	if klog.V(2) {
+		klog.Infof("Trigger parameters set to %v", params)
+	}
+	return nil
+}
+
+/* Convert the string value of a parameter to the type of the parameter */
+func parseParameterValue(param *Parameter, str string) (interface{}, error) {
+	if param.Type == TYPESTRING {
+		return str, nil
+	}
+	var value interface{}
+	if err := yamlv3.Unmarshal([]byte(str), &value); err != nil {
+		return nil, err
+	}
+	value = normalizeYAMLValue(value)
+	typeName := ""
+	switch value.(type) {
+	case int64:
+		typeName = TYPEINT
+		if param.Type == TYPEDOUBLE {
+			value = float64(value.(int64))
+			typeName = TYPEDOUBLE
+		}
+	case float64:
+		typeName = TYPEDOUBLE
+	case bool:
+		typeName = TYPEBOOL
+	case string:
+		typeName = TYPESTRING
+	case []interface{}:
+		typeName = TYPELIST
+	case map[string]interface{}:
+		typeName = TYPEMAP
+	default:
+		return nil, fmt.Errorf("unsupported value %v", str)
+	}
+	if param.Type != "" && param.Type != TYPEANY && param.Type != typeName {
+		return nil, fmt.Errorf("value %v should be of type %v, but is of type %v", str, param.Type, typeName)
+	}
+	return value, nil
+}
+
+/* Convert the values decoded from YAML to the types used for variables */
+func normalizeYAMLValue(value interface{}) interface{} {
+	switch val := value.(type) {
+	case int:
+		return int64(val)
+	case []interface{}:
+		for index, element := range val {
+			val[index] = normalizeYAMLValue(element)
+		}
+		return val
+	case map[string]interface{}:
+		for key, element := range val {
+			val[key] = normalizeYAMLValue(element)
+		}
+		return val
+	default:
+		return value
+	}
+}
+
+/* Add the params variable, unless hidden */
+func (p *Processor) addParams(env cel.Env, variables map[string]interface{}, hidden map[string]bool) (cel.Env, error) {
+	if len(p.triggerDef.Parameters) == 0 || hidden[PARAMS] {
+		return env, nil
+	}
+	ident := decls.NewIdent(PARAMS, decls.NewMapType(decls.String, decls.Any), nil)
+	env, err := env.Extend(cel.Declarations(ident))
+	if err != nil {
+		return env, err
+	}
+	p.paramsMutex.RLock()
+	variables[PARAMS] = deepCopy(p.params)
+	p.paramsMutex.RUnlock()
+	return env, nil
+}
+
+// WatchParameters loads the overrides of the parameters from the ConfigMap named by the parametersConfigMap setting in
+// the Kabanero namespace, and watches the ConfigMap for changes. When the ConfigMap is deleted, the defaults are used.
+func (p *Processor) WatchParameters() error {
+	configMapName := p.triggerDef.getSettingString(PARAMETERSCONFIGMAP)
+	if configMapName == "" || len(p.triggerDef.Parameters) == 0 {
+		return nil
+	}
+	if p.env == nil || p.env.KubeClient == nil {
+		return fmt.Errorf("unable to watch parameters ConfigMap %v: no Kubernetes client", configMapName)
+	}
+	namespace := utils.GetKabaneroNamespace()
+	configMaps := p.env.KubeClient.CoreV1().ConfigMaps(namespace)
+
+	resourceVersion := ""
+	configMap, err := configMaps.Get(configMapName, metav1.GetOptions{})
+	if err == nil {
+		resourceVersion = configMap.ResourceVersion
+		if err = p.SetParameterOverrides(configMap.Data); err != nil {
+			return fmt.Errorf("invalid parameters in ConfigMap %s/%s: %v", namespace, configMapName, err)
+		}
+	} else if k8serrors.IsNotFound(err) {
+		klog.Infof("Parameters ConfigMap %s/%s not found. Using defaults", namespace, configMapName)
+	} else {
+		return fmt.Errorf("unable to get parameters ConfigMap %s/%s: %v", namespace, configMapName, err)
+	}
+
+	go func() {
+		for p.ctx.Err() == nil {
+			resourceVersion = p.watchParametersConfigMap(namespace, configMapName, resourceVersion)
+			select {
+			case <-p.ctx.Done():
+			case <-time.After(parametersRewatchDelay):
+			}
+		}
+	}()
+	return nil
+}
+
+/* Apply changes to the parameters ConfigMap until the watch ends, or the processor is stopped. Return the last resource version seen. */
+func (p *Processor) watchParametersConfigMap(namespace string, configMapName string, resourceVersion string) string {
+	watcher, err := p.env.KubeClient.CoreV1().ConfigMaps(namespace).Watch(metav1.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", configMapName).String(),
+		ResourceVersion: resourceVersion,
+	})
+	if err != nil {
+		klog.Errorf("Unable to watch parameters ConfigMap %s/%s: %v", namespace, configMapName, err)
+		return resourceVersion
+	}
+	defer watcher.Stop()
+
+	for {
+		var event watch.Event
+		var open bool
+		select {
+		case <-p.ctx.Done():
+			return resourceVersion
+		case event, open = <-watcher.ResultChan():
+		}
+		if !open {
+			return resourceVersion
+		}
+		configMap, ok := event.Object.(*corev1.ConfigMap)
+		if !ok {
+			/* the watch failed, e.g. because the resource version is too old. Start over from the latest version. */
+			klog.Errorf("Watch of parameters ConfigMap %s/%s ended: %v", namespace, configMapName, event.Object)
+			return ""
+		}
+		resourceVersion = configMap.ResourceVersion
+		switch event.Type {
+		case watch.Added, watch.Modified:
+			err = p.SetParameterOverrides(configMap.Data)
+		case watch.Deleted:
+			err = p.SetParameterOverrides(nil)
+		}
+		if err != nil {
+			klog.Errorf("Invalid parameters in ConfigMap %s/%s. Keeping previous values: %v", namespace, configMapName, err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"k8s.io/client-go/util/jsonpath"
+	"k8s.io/klog"
+)
+
+/* maximum number of compiled queries kept in the cache */
+const maxCachedQueries = 256
+
+/*
+prefix of the error of JSONPath for an index or slice out of range, which is not a distinct error type. The error ends the
+search, so a query that is not definite selects nothing if an index or slice is out of the range of any of the lists selected.
+The lastTag, firstTags and secondModified cases of TestQueryFunction select out of range, so that a change of the wording is detected.
+*/
+const jsonPathIndexError = "array index out of bounds"
+
+/* compiledQuery is a parsed JSONPath expression */
+type compiledQuery struct {
+	mutex    sync.Mutex // a JSONPath keeps state while finding results, and can only be used by one evaluation at a time
+	path     *jsonpath.JSONPath
+	definite bool            // whether the expression selects at most one value, and returns it rather than a list
+	steps    []jsonpath.Node // fields and array indexes of a definite expression
+}
+
+/* queryCache holds compiled queries, as triggers usually run the same few queries for every event */
+type queryCache struct {
+	mutex   sync.Mutex
+	queries map[string]*compiledQuery
+}
+
+var jsonPathCache = &queryCache{queries: make(map[string]*compiledQuery)}
+
+/* Return the compiled query, compiling it if not cached */
+func (cache *queryCache) compile(expression string) (*compiledQuery, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if query, ok := cache.queries[expression]; ok {
+		return query, nil
+	}
+	template := expression
+	if !strings.HasPrefix(template, "{") {
+		if !strings.HasPrefix(template, "$") && !strings.HasPrefix(template, ".") && !strings.HasPrefix(template, "[") {
+			template = "." + template
+		}
+		template = "{" + template + "}"
+	}
+	parser, err := jsonpath.Parse("query", template)
+	if err != nil {
+		return nil, err
+	}
+	path := jsonpath.New("query").AllowMissingKeys(true)
+	if err = path.Parse(template); err != nil {
+		return nil, err
+	}
+	if len(cache.queries) >= maxCachedQueries {
+		/* queries computed from events may be unbounded. Start over rather than track usage. */
+		if klog.V(5) {
+			klog.Infof("query cache is full. Clearing %v queries", len(cache.queries))
+		}
+		cache.queries = make(map[string]*compiledQuery)
+	}
+	query := &compiledQuery{path: path, definite: isDefinite(parser.Root)}
+	if query.definite {
+		query.steps = parser.Root.Nodes[0].(*jsonpath.ListNode).Nodes
+	}
+	cache.queries[expression] = query
+	return query, nil
+}
+
+/* Return whether a parsed expression selects at most one value: a single block of fields and single array indexes */
+func isDefinite(root *jsonpath.ListNode) bool {
+	if len(root.Nodes) != 1 {
+		return false
+	}
+	block, ok := root.Nodes[0].(*jsonpath.ListNode)
+	if !ok {
+		return false
+	}
+	for _, node := range block.Nodes {
+		switch node := node.(type) {
+		case *jsonpath.FieldNode:
+		case *jsonpath.ArrayNode:
+			if !node.Params[1].Derived || node.Params[2].Known {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Return whether an index of a definite query is out of the range of its list in the data. The steps are from a separate parse
+of the expression, as a JSONPath does not expose its nodes.
+*/
+func (query *compiledQuery) indexOutOfRange(data interface{}) bool {
+	current := reflect.ValueOf(data)
+	for _, step := range query.steps {
+		for current.IsValid() && current.Kind() == reflect.Interface {
+			current = current.Elem()
+		}
+		if !current.IsValid() {
+			return false
+		}
+		switch node := step.(type) {
+		case *jsonpath.FieldNode:
+			if current.Kind() != reflect.Map || current.Type().Key().Kind() != reflect.String {
+				return false
+			}
+			current = current.MapIndex(reflect.ValueOf(node.Value).Convert(current.Type().Key()))
+		case *jsonpath.ArrayNode:
+			if current.Kind() != reflect.Slice && current.Kind() != reflect.Array {
+				return false
+			}
+			index := node.Params[0].Value
+			if index < 0 {
+				index += current.Len()
+			}
+			if index < 0 || index >= current.Len() {
+				return true
+			}
+			current = current.Index(index)
+		}
+	}
+	return false
+}
+
+/* Find the values selected by the query */
+func (query *compiledQuery) find(data interface{}) ([]interface{}, error) {
+	if query.definite && query.indexOutOfRange(data) {
+		/* a missing index is like a missing key */
+		return []interface{}{}, nil
+	}
+	query.mutex.Lock()
+	defer query.mutex.Unlock()
+	results, err := query.path.FindResults(data)
+	if err != nil {
+		if !query.definite && strings.HasPrefix(err.Error(), jsonPathIndexError) {
+			/* an index or slice out of range of one of the lists selected selects nothing */
+			return []interface{}{}, nil
+		}
+		return nil, err
+	}
+	values := make([]interface{}, 0)
+	for _, result := range results {
+		for _, value := range result {
+			values = append(values, value.Interface())
+		}
+	}
+	return values, nil
+}
+
+/*
+implementation of query(obj, expression [, default]): the values selected by a JSONPath expression. An expression selecting at most
+one value, with only fields and array indexes, returns the value, or else the default, or null if none. Other expressions return a list.
+*/
+func (p *Processor) queryCEL(values ...ref.Val) ref.Val {
+	if len(values) != 2 && len(values) != 3 {
+		return types.NewErr("function query expects 2 or 3 parameters, but got %v", len(values))
+	}
+	expression, errVal := stringParam("query", "second", values[1])
+	if errVal != nil {
+		return errVal
+	}
+	query, err := jsonPathCache.compile(expression)
+	if err != nil {
+		return types.NewErr("function query: invalid expression %v: %v", expression, err)
+	}
+	data, err := refToNative(values[0])
+	if err != nil {
+		return types.NewErr("function query: %v", err)
+	}
+	results, err := query.find(data)
+	if err != nil {
+		return types.NewErr("function query: error evaluating %v: %v", expression, err)
+	}
+	if !query.definite {
+		return types.NewDynamicList(types.DefaultTypeAdapter, results)
+	}
+	if len(results) == 0 {
+		if len(values) == 3 {
+			return values[2]
+		}
+		return types.NullValue
+	}
+	return types.DefaultTypeAdapter.NativeToValue(results[0])
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"k8s.io/klog"
+)
+
+/* maximum number of compiled patterns kept in the cache */
+const maxCachedPatterns = 256
+
+/* regexCache holds compiled regular expressions, as triggers usually match the same few patterns for every event */
+type regexCache struct {
+	mutex    sync.Mutex
+	patterns map[string]*regexp.Regexp
+}
+
+var patternCache = &regexCache{patterns: make(map[string]*regexp.Regexp)}
+
+/* Return the compiled pattern, compiling it if not cached */
+func (cache *regexCache) compile(pattern string) (*regexp.Regexp, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if re, ok := cache.patterns[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(cache.patterns) >= maxCachedPatterns {
+		/* patterns computed from events may be unbounded. Start over rather than track usage. */
+		if klog.V(5) {
+			klog.Infof("regular expression cache is full. Clearing %v patterns", len(cache.patterns))
+		}
+		cache.patterns = make(map[string]*regexp.Regexp)
+	}
+	cache.patterns[pattern] = re
+	return re, nil
+}
+
+/* Return the string and the compiled pattern of the parameters of a regex function */
+func regexParams(function string, strVal ref.Val, patternVal ref.Val) (string, *regexp.Regexp, ref.Val) {
+	str, ok := strVal.(types.String)
+	if !ok {
+		return "", nil, types.ValOrErr(strVal, "unexpected type '%v' passed as first parameter to function %v", strVal.Type(), function)
+	}
+	pattern, ok := patternVal.(types.String)
+	if !ok {
+		return "", nil, types.ValOrErr(patternVal, "unexpected type '%v' passed as second parameter to function %v", patternVal.Type(), function)
+	}
+	re, err := patternCache.compile(string(pattern))
+	if err != nil {
+		return "", nil, types.NewErr("invalid regular expression %v passed to function %v: %v", pattern, function, err)
+	}
+	return string(str), re, nil
+}
+
+/* implementation of regexFind: return the leftmost match of the pattern, or the empty string if none */
+func (p *Processor) regexFindCEL(strVal ref.Val, patternVal ref.Val) ref.Val {
+	str, re, errVal := regexParams("regexFind", strVal, patternVal)
+	if errVal != nil {
+		return errVal
+	}
+	return types.String(re.FindString(str))
+}
+
+/* implementation of regexFindAll: return all non-overlapping matches of the pattern */
+func (p *Processor) regexFindAllCEL(strVal ref.Val, patternVal ref.Val) ref.Val {
+	str, re, errVal := regexParams("regexFindAll", strVal, patternVal)
+	if errVal != nil {
+		return errVal
+	}
+	matches := re.FindAllString(str, -1)
+	if matches == nil {
+		matches = []string{}
+	}
+	return types.NewStringList(types.DefaultTypeAdapter, matches)
+}
+
+/* implementation of regexCaptures: return the groups of the leftmost match by number, and by name for named groups, or an empty map if no match */
+func (p *Processor) regexCapturesCEL(strVal ref.Val, patternVal ref.Val) ref.Val {
+	str, re, errVal := regexParams("regexCaptures", strVal, patternVal)
+	if errVal != nil {
+		return errVal
+	}
+	captures := make(map[string]interface{})
+	match := re.FindStringSubmatch(str)
+	if match != nil {
+		names := re.SubexpNames()
+		for index, group := range match {
+			captures[strconv.Itoa(index)] = group
+			if names[index] != "" {
+				captures[names[index]] = group
+			}
+		}
+	}
+	return types.NewDynamicMap(types.DefaultTypeAdapter, captures)
+}
+
+/* implementation of regexReplace: replace all matches of the pattern. $1 or ${name} in the replacement expand to the groups of the match */
+func (p *Processor) regexReplaceCEL(values ...ref.Val) ref.Val {
+	if len(values) != 3 {
+		return types.NewErr("function regexReplace expects 3 parameters, but got %v", len(values))
+	}
+	str, re, errVal := regexParams("regexReplace", values[0], values[1])
+	if errVal != nil {
+		return errVal
+	}
+	replacement, ok := values[2].(types.String)
+	if !ok {
+		return types.ValOrErr(values[2], "unexpected type '%v' passed as third parameter to function regexReplace", values[2].Type())
+	}
+	return types.String(re.ReplaceAllString(str, string(replacement)))
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+)
+
+/* resources that can not be read by triggers, even if their kinds are listed in the readableKinds setting */
+var unreadableResources = map[schema.GroupResource]bool{{Group: "", Resource: "secrets"}: true}
+
+/*
+Create the clients used while processing events. The clients do not take a context, so their requests time out after the time
+allowed to process an event. They are created once, so that the events share their connections. Without the configuration
+of the clients, such as in tests, the clients of the environment are used.
+*/
+func (p *Processor) initClients() error {
+	p.eventDynamic = nil
+	p.eventKube = nil
+	if p.env == nil || p.env.KubeConfig == nil {
+		return nil
+	}
+	config := rest.CopyConfig(p.env.KubeConfig)
+	config.Timeout = p.triggerDef.getSettingDuration(TIMEOUT, defaultTimeout)
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("unable to create a dynamic client with a timeout: %v", err)
+	}
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("unable to create a Kubernetes client with a timeout: %v", err)
+	}
+	p.eventDynamic = dynamicClient
+	p.eventKube = kubeClient
+	return nil
+}
+
+/* Return the dynamic client used while processing events */
+func (p *Processor) dynamicClient() dynamic.Interface {
+	if p.eventDynamic != nil {
+		return p.eventDynamic
+	}
+	return p.env.DynamicClient
+}
+
+/* Return the Kubernetes client used while processing events */
+func (p *Processor) kubeClient() kubernetes.Interface {
+	if p.eventKube != nil {
+		return p.eventKube
+	}
+	return p.env.KubeClient
+}
+
+/* Return the client for the resources of the given kind, if triggers are allowed to read them */
+func (p *Processor) readableResource(ev *evaluation, function string, apiVersion string, kind string, namespace string) (dynamic.ResourceInterface, error) {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil || gv.Version == "" {
+		return nil, fmt.Errorf("function %v: invalid apiVersion %v", function, apiVersion)
+	}
+	/* the resource is named after the kind in lower case, so compare resources rather than kinds, whatever the case of the kind */
+	gvr := gv.WithResource(kindToPlural(kind))
+	allowed := false
+	for _, readable := range p.triggerDef.getSettingStrings(READABLEKINDS) {
+		/* a readable kind is Kind for the core group, or Kind.group for other groups, such as PipelineRun.tekton.dev */
+		gk := schema.ParseGroupKind(readable)
+		if (schema.GroupResource{Group: gk.Group, Resource: kindToPlural(gk.Kind)}) == gvr.GroupResource() {
+			allowed = true
+			break
+		}
+	}
+	if !allowed || unreadableResources[gvr.GroupResource()] {
+		return nil, fmt.Errorf("function %v: kind %v is not readable. Add it to the %v setting", function, kind, READABLEKINDS)
+	}
+	if p.env == nil || p.env.DynamicClient == nil {
+		return nil, fmt.Errorf("function %v: no Kubernetes client is available", function)
+	}
+	resource := p.dynamicClient().Resource(gvr)
+	if namespace == "" {
+		return resource, nil
+	}
+	return resource.Namespace(namespace), nil
+}
+
+/*
+Call the Kubernetes client. The client does not take a context, so stop waiting when the deadline of the event has passed.
+The requests of the clients returned by kubeClient and dynamicClient time out after the time allowed to process an event, so
+the call ends by then. Other clients are not cancelled, so the call may complete later, which is logged.
+*/
+func (ev *evaluation) callClient(description string, call func() (interface{}, error)) (interface{}, error) {
+	type result struct {
+		obj interface{}
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		obj, err := call()
+		done <- result{obj, err}
+		if ev.ctx.Err() != nil {
+			klog.Infof("%v completed after the time to process event %v: %v", description, ev.result.EventID, err)
+		}
+	}()
+	select {
+	case res := <-done:
+		return res.obj, res.err
+	case <-ev.ctx.Done():
+		return nil, fmt.Errorf("%v did not complete in time: %v", description, ev.ctx.Err())
+	}
+}
+
+/* Return the value cached for the event, or else call lookup and cache its value */
+func (ev *evaluation) cachedLookup(key string, lookup func() (interface{}, error)) (interface{}, error) {
+	if value, ok := ev.lookups[key]; ok {
+		if klog.V(5) {
+			klog.Infof("using cached value for %v", key)
+		}
+		return value, nil
+	}
+	value, err := lookup()
+	if err != nil {
+		return nil, err
+	}
+	if ev.lookups == nil {
+		ev.lookups = make(map[string]interface{})
+	}
+	ev.lookups[key] = value
+	return value, nil
+}
+
+/* Return the string parameters of a resource function */
+func resourceParams(function string, values []ref.Val) ([]string, ref.Val) {
+	if len(values) != 4 {
+		return nil, types.NewErr("function %v expects 4 parameters, but got %v", function, len(values))
+	}
+	positions := []string{"first", "second", "third", "fourth"}
+	params := make([]string, len(values))
+	for index, val := range values {
+		str, errVal := stringParam(function, positions[index], val)
+		if errVal != nil {
+			return nil, errVal
+		}
+		params[index] = str
+	}
+	return params, nil
+}
+
+/*
+implementation of getResource(apiVersion, kind, namespace, name): the resource, or an empty map if not found, as values can not
+be compared with null. The namespace is empty for resources that are not namespaced.
+*/
+func (p *Processor) getResourceCEL(ev *evaluation, values ...ref.Val) ref.Val {
+	params, errVal := resourceParams("getResource", values)
+	if errVal != nil {
+		return errVal
+	}
+	apiVersion, kind, namespace, name := params[0], params[1], params[2], params[3]
+	if name == "" {
+		return types.NewErr("function getResource: the name of the resource is empty")
+	}
+	resource, err := p.readableResource(ev, "getResource", apiVersion, kind, namespace)
+	if err != nil {
+		return types.NewErr("%v", err)
+	}
+	key := fmt.Sprintf("getResource %v %v %v/%v", apiVersion, kind, namespace, name)
+	obj, err := ev.cachedLookup(key, func() (interface{}, error) {
+		return ev.callClient(fmt.Sprintf("get of %v %v/%v", kind, namespace, name), func() (interface{}, error) {
+			obj, err := resource.Get(name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return obj.Object, nil
+		})
+	})
+	if err != nil {
+		return types.NewErr("function getResource: %v", err)
+	}
+	if obj == nil {
+		return types.NewDynamicMap(types.DefaultTypeAdapter, map[string]interface{}{})
+	}
+	return types.DefaultTypeAdapter.NativeToValue(obj)
+}
+
+/*
+implementation of listResources(apiVersion, kind, namespace, labelSelector): the resources matching the label selector, such
+as "app=web,tier!=db". An empty namespace lists the resources of all namespaces, and an empty selector lists all resources.
+*/
+func (p *Processor) listResourcesCEL(ev *evaluation, values ...ref.Val) ref.Val {
+	params, errVal := resourceParams("listResources", values)
+	if errVal != nil {
+		return errVal
+	}
+	apiVersion, kind, namespace, selector := params[0], params[1], params[2], params[3]
+	if _, err := labels.Parse(selector); err != nil {
+		return types.NewErr("function listResources: invalid label selector %v: %v", selector, err)
+	}
+	resource, err := p.readableResource(ev, "listResources", apiVersion, kind, namespace)
+	if err != nil {
+		return types.NewErr("%v", err)
+	}
+	key := fmt.Sprintf("listResources %v %v %v %v", apiVersion, kind, namespace, selector)
+	items, err := ev.cachedLookup(key, func() (interface{}, error) {
+		return ev.callClient(fmt.Sprintf("list of %v in namespace %q", kind, namespace), func() (interface{}, error) {
+			list, err := resource.List(metav1.ListOptions{LabelSelector: selector})
+			if err != nil {
+				return nil, err
+			}
+			items := make([]interface{}, len(list.Items))
+			for index, item := range list.Items {
+				items[index] = item.Object
+			}
+			return items, nil
+		})
+	})
+	if err != nil {
+		return types.NewErr("function listResources: %v", err)
+	}
+	return types.NewDynamicList(types.DefaultTypeAdapter, items)
+}
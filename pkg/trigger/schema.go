@@ -0,0 +1,397 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"k8s.io/klog"
+)
+
+/* constants for the eventSchemas section */
+const (
+	EVENTSCHEMAS = "eventSchemas"
+	SCHEMA       = "schema"
+	SCHEMAFILE   = "schemaFile"
+)
+
+/* prefix of the names of the CEL object types created from schemas */
+const schemaTypePrefix = "kabanero.schema"
+
+// EventSchema is the JSON schema of the messages of an event source
+type EventSchema struct {
+	EventSource string
+	Schema      map[string]interface{}
+	Builtin     bool // whether the schema is a built-in schema, whose objects allow properties not declared
+	Position    Position
+}
+
+/* Read the eventSchemas section of a trigger file */
+func (td *EventTriggerDefinition) readEventSchemas(fileName string, yamlObj *Object) error {
+	if _, ok := yamlObj.Get(EVENTSCHEMAS); !ok {
+		return nil
+	}
+	array, err := getArray(yamlObj, EVENTSCHEMAS)
+	if err != nil {
+		return err
+	}
+	for _, schemaObj := range array {
+		schemaMap, ok := schemaObj.(*Object)
+		if !ok {
+			return fmt.Errorf("%v: event schema %v is not an object but %T", yamlObj.GetEntry(EVENTSCHEMAS).Position, schemaObj, schemaObj)
+		}
+		if err := checkKeys(schemaMap, "event schema", EVENTSOURCE, SCHEMA, SCHEMAFILE); err != nil {
+			return err
+		}
+		eventSource, err := getString(schemaMap, EVENTSOURCE)
+		if err != nil {
+			return err
+		}
+		if existing, ok := td.Schemas[eventSource]; ok {
+			return fmt.Errorf("%v: schema for event source %v redeclared, previously declared at %v", schemaMap.Position, eventSource, existing.Position)
+		}
+		schema, err := readSchema(fileName, schemaMap)
+		if err != nil {
+			return err
+		}
+		schemaObj, _ := schemaMap.Get(SCHEMA)
+		_, builtin := schemaObj.(string)
+		td.Schemas[eventSource] = &EventSchema{EventSource: eventSource, Schema: schema, Builtin: builtin, Position: schemaMap.Position}
+	}
+	return nil
+}
+
+/* Return the schema of an event schema declaration: the name of a built-in schema, an inline schema, or a JSON or YAML file relative to the trigger file */
+func readSchema(fileName string, schemaMap *Object) (map[string]interface{}, error) {
+	schemaObj, hasSchema := schemaMap.Get(SCHEMA)
+	_, hasFile := schemaMap.Get(SCHEMAFILE)
+	if hasSchema == hasFile {
+		return nil, fmt.Errorf("%v: event schema must contain one of %v or %v", schemaMap.Position, SCHEMA, SCHEMAFILE)
+	}
+
+	if hasSchema {
+		switch schema := schemaObj.(type) {
+		case string:
+			builtin, err := builtinSchema(schema)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", schemaMap.GetEntry(SCHEMA).Position, err)
+			}
+			return builtin, nil
+		case *Object:
+			return schema.ToMap(), nil
+		default:
+			return nil, fmt.Errorf("%v: %v is not a string or object but %T", schemaMap.GetEntry(SCHEMA).Position, SCHEMA, schemaObj)
+		}
+	}
+
+	path, err := getString(schemaMap, SCHEMAFILE)
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(fileName), path)
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%v: unable to read schema: %v", schemaMap.GetEntry(SCHEMAFILE).Position, err)
+	}
+	/* YAML is a superset of JSON */
+	schema, err := readObject(path, buf)
+	if err != nil {
+		return nil, err
+	}
+	return schema.ToMap(), nil
+}
+
+/*
+schemaObject is an object type created from a schema with properties. Properties not declared are of any type, as messages
+may contain them, but the type checker reports accesses to them in expressions if the object is closed.
+*/
+type schemaObject struct {
+	fields map[string]*exprpb.Type
+	closed bool // whether expressions may only access the declared properties
+}
+
+/* schemaTypeProvider provides the object types created from schemas to the CEL type checker. Other types are provided by the default provider. */
+type schemaTypeProvider struct {
+	ref.TypeProvider
+	objects map[string]*schemaObject
+}
+
+func newSchemaTypeProvider() *schemaTypeProvider {
+	return &schemaTypeProvider{
+		TypeProvider: types.NewRegistry(),
+		objects:      make(map[string]*schemaObject),
+	}
+}
+
+func (provider *schemaTypeProvider) FindType(typeName string) (*exprpb.Type, bool) {
+	if _, ok := provider.objects[typeName]; ok {
+		return decls.NewTypeType(decls.NewObjectType(typeName)), true
+	}
+	return provider.TypeProvider.FindType(typeName)
+}
+
+func (provider *schemaTypeProvider) FindFieldType(messageType string, fieldName string) (*ref.FieldType, bool) {
+	object, ok := provider.objects[messageType]
+	if !ok {
+		return provider.TypeProvider.FindFieldType(messageType, fieldName)
+	}
+	if fieldType, ok := object.fields[fieldName]; ok {
+		return &ref.FieldType{SupportsPresence: true, Type: fieldType}, true
+	}
+	return &ref.FieldType{SupportsPresence: true, Type: decls.Dyn}, true
+}
+
+/* Return whether a field of an object type created from a schema may be accessed by expressions */
+func (provider *schemaTypeProvider) fieldDeclared(messageType string, fieldName string) bool {
+	object, ok := provider.objects[messageType]
+	if !ok || !object.closed {
+		return true
+	}
+	_, ok = object.fields[fieldName]
+	return ok
+}
+
+/* Create the CEL types of the messages of the event sources with a schema */
+func (p *Processor) initSchemas() error {
+	p.schemaProvider = nil
+	p.inputTypes = make(map[string]*exprpb.Type)
+	if len(p.triggerDef.Schemas) == 0 {
+		return nil
+	}
+	p.schemaProvider = newSchemaTypeProvider()
+	for eventSource, schema := range p.triggerDef.Schemas {
+		converter := &schemaConverter{
+			provider: p.schemaProvider,
+			root:     schema.Schema,
+			prefix:   schemaTypePrefix + "." + eventSource,
+			refs:     make(map[string]*exprpb.Type),
+			builtin:  schema.Builtin,
+		}
+		inputType, err := converter.convert(schema.Schema, converter.prefix)
+		if err != nil {
+			return fmt.Errorf("%v: invalid schema for event source %v: %v", schema.Position, eventSource, err)
+		}
+		if klog.V(4) {
+			klog.Infof("type of messages of event source %v: %v", eventSource, inputType)
+		}
+		p.inputTypes[eventSource] = inputType
+	}
+	return nil
+}
+
+/* Return the CEL type of the messages of an event source */
+func (p *Processor) inputType(eventSource string) *exprpb.Type {
+	if inputType, ok := p.inputTypes[eventSource]; ok {
+		return inputType
+	}
+	return decls.NewMapType(decls.String, decls.Any)
+}
+
+/* schemaConverter converts a JSON schema to CEL types. Objects with properties become object types named after their path in the schema. */
+type schemaConverter struct {
+	provider *schemaTypeProvider
+	root     map[string]interface{}  // the whole schema, to resolve references
+	prefix   string                  // prefix of the names of the object types
+	refs     map[string]*exprpb.Type // types of the references converted so far
+	builtin  bool                    // whether objects are closed unless additionalProperties is set, as for the built-in schemas
+}
+
+/* Convert a schema. name is the name of the object type if the schema is an object with properties. */
+func (c *schemaConverter) convert(schema interface{}, name string) (*exprpb.Type, error) {
+	schemaMap, ok := schema.(map[string]interface{})
+	if !ok {
+		/* true, or a schema we do not understand, allows any value */
+		return decls.Dyn, nil
+	}
+	if reference, ok := schemaMap["$ref"]; ok {
+		return c.convertRef(reference)
+	}
+	for _, key := range []string{"anyOf", "oneOf"} {
+		if alternatives, ok := schemaMap[key]; ok {
+			return c.convertAlternatives(alternatives, name)
+		}
+	}
+
+	schemaTypes := make([]string, 0)
+	nullable := false
+	switch schemaType := schemaMap["type"].(type) {
+	case string:
+		schemaTypes = append(schemaTypes, schemaType)
+	case []interface{}:
+		for _, element := range schemaType {
+			str, ok := element.(string)
+			if !ok {
+				return nil, fmt.Errorf("type %v of %v is not a string", element, name)
+			}
+			if str == "null" {
+				nullable = true
+			} else {
+				schemaTypes = append(schemaTypes, str)
+			}
+		}
+	case nil:
+		if _, ok := schemaMap["properties"]; ok {
+			schemaTypes = append(schemaTypes, "object")
+		}
+	default:
+		return nil, fmt.Errorf("type of %v is not a string or array", name)
+	}
+	if len(schemaTypes) != 1 {
+		return decls.Dyn, nil
+	}
+
+	switch schemaTypes[0] {
+	case "object":
+		if _, ok := schemaMap["properties"]; nullable && !ok {
+			/* a map can not be compared to null */
+			return decls.Dyn, nil
+		}
+		return c.convertObject(schemaMap, name)
+	case "array":
+		if nullable {
+			return decls.Dyn, nil
+		}
+		itemType, err := c.convert(schemaMap["items"], name+".items")
+		if err != nil {
+			return nil, err
+		}
+		return decls.NewListType(itemType), nil
+	case "string":
+		return primitiveType(decls.String, nullable), nil
+	case "integer", "number":
+		/* numbers decoded from JSON are doubles */
+		return primitiveType(decls.Double, nullable), nil
+	case "boolean":
+		return primitiveType(decls.Bool, nullable), nil
+	default:
+		return nil, fmt.Errorf("unsupported type %v of %v", schemaTypes[0], name)
+	}
+}
+
+/*
+Convert the alternatives of anyOf or oneOf. A schema or null, such as an optional object, is converted as the schema with a
+type that may be null. Other alternatives accept any value.
+*/
+func (c *schemaConverter) convertAlternatives(alternatives interface{}, name string) (*exprpb.Type, error) {
+	list, ok := alternatives.([]interface{})
+	if !ok || len(list) != 2 {
+		return decls.Dyn, nil
+	}
+	for index, alternative := range list {
+		alternativeMap, ok := alternative.(map[string]interface{})
+		if !ok || len(alternativeMap) != 1 || alternativeMap["type"] != "null" {
+			continue
+		}
+		altType, err := c.convert(list[1-index], name)
+		switch {
+		case err != nil:
+			return nil, err
+		case altType.GetPrimitive() != exprpb.Type_PRIMITIVE_TYPE_UNSPECIFIED:
+			return primitiveType(altType, true), nil
+		case altType.GetWrapper() != exprpb.Type_PRIMITIVE_TYPE_UNSPECIFIED, altType.GetMessageType() != "":
+			/* objects from schemas may be compared to null */
+			return altType, nil
+		default:
+			/* lists and maps can not be compared to null */
+			return decls.Dyn, nil
+		}
+	}
+	return decls.Dyn, nil
+}
+
+/* Return the type of a primitive. A primitive that may be null is a wrapper, so that it can be compared to null. */
+func primitiveType(primitive *exprpb.Type, nullable bool) *exprpb.Type {
+	if nullable {
+		return decls.NewWrapperType(primitive)
+	}
+	return primitive
+}
+
+/* Convert an object. An object without properties is a map. */
+func (c *schemaConverter) convertObject(schemaMap map[string]interface{}, name string) (*exprpb.Type, error) {
+	additional := schemaMap["additionalProperties"]
+	properties, ok := schemaMap["properties"].(map[string]interface{})
+	if !ok {
+		valueType, err := c.convert(additional, name+".additionalProperties")
+		if err != nil {
+			return nil, err
+		}
+		return decls.NewMapType(decls.String, valueType), nil
+	}
+
+	object := &schemaObject{
+		fields: make(map[string]*exprpb.Type),
+		closed: additional == false || (c.builtin && additional == nil),
+	}
+	/* register before converting the properties, so that recursive references resolve to this type */
+	c.provider.objects[name] = object
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fieldType, err := c.convert(properties[key], name+"."+key)
+		if err != nil {
+			return nil, err
+		}
+		object.fields[key] = fieldType
+	}
+	return decls.NewObjectType(name), nil
+}
+
+/* Convert a reference to a definition within the schema */
+func (c *schemaConverter) convertRef(reference interface{}) (*exprpb.Type, error) {
+	refString, ok := reference.(string)
+	if !ok || !strings.HasPrefix(refString, "#/") {
+		return nil, fmt.Errorf("unsupported reference %v: only references within the schema are supported", reference)
+	}
+	if refType, ok := c.refs[refString]; ok {
+		return refType, nil
+	}
+
+	var target interface{} = c.root
+	components := strings.Split(strings.TrimPrefix(refString, "#/"), "/")
+	for _, component := range components {
+		targetMap, ok := target.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unable to resolve reference %v", refString)
+		}
+		if target, ok = targetMap[component]; !ok {
+			return nil, fmt.Errorf("unable to resolve reference %v", refString)
+		}
+	}
+	name := c.prefix + "." + strings.Join(components, ".")
+	/* a recursive reference is only possible through an object, which is registered by name */
+	c.refs[refString] = decls.NewObjectType(name)
+	refType, err := c.convert(target, name)
+	if err != nil {
+		return nil, err
+	}
+	c.refs[refString] = refType
+	return refType, nil
+}
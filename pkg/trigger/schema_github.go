@@ -0,0 +1,210 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+/* Names of the built-in schemas for messages from the webhook listener for GitHub */
+const (
+	githubSchema            = "github" // any of the events below
+	githubPushSchema        = "github-push"
+	githubPullRequestSchema = "github-pull_request"
+	githubCreateSchema      = "github-create"
+)
+
+/*
+Definitions and the properties of the body of each GitHub event. Fields whose type varies between events have no type.
+Objects allow other properties, as GitHub adds fields to its events, but expressions may only access the declared properties.
+
+	The kabanero property is not sent by GitHub, but may be set by triggers to pass information along with the event.
+*/
+const githubSchemaJSON = `{
+  "definitions": {
+    "user": {
+      "type": "object",
+      "properties": {
+        "login": {"type": "string"}, "id": {"type": "integer"}, "node_id": {"type": "string"},
+        "avatar_url": {"type": "string"}, "gravatar_id": {"type": "string"}, "url": {"type": "string"},
+        "html_url": {"type": "string"}, "followers_url": {"type": "string"}, "following_url": {"type": "string"},
+        "gists_url": {"type": "string"}, "starred_url": {"type": "string"}, "subscriptions_url": {"type": "string"},
+        "organizations_url": {"type": "string"}, "repos_url": {"type": "string"}, "events_url": {"type": "string"},
+        "received_events_url": {"type": "string"}, "type": {"type": "string"}, "site_admin": {"type": "boolean"},
+        "name": {"type": ["string", "null"]}, "email": {"type": ["string", "null"]}
+      }
+    },
+    "gitUser": {
+      "type": "object",
+      "properties": {
+        "name": {"type": "string"}, "email": {"type": ["string", "null"]}, "username": {"type": "string"},
+        "date": {"type": "string"}
+      }
+    },
+    "repository": {
+      "type": "object",
+      "properties": {
+        "id": {"type": "integer"}, "node_id": {"type": "string"}, "name": {"type": "string"},
+        "full_name": {"type": "string"}, "private": {"type": "boolean"}, "owner": {"$ref": "#/definitions/user"},
+        "html_url": {"type": "string"}, "description": {"type": ["string", "null"]}, "fork": {"type": "boolean"},
+        "url": {"type": "string"}, "forks_url": {"type": "string"}, "keys_url": {"type": "string"},
+        "collaborators_url": {"type": "string"}, "teams_url": {"type": "string"}, "hooks_url": {"type": "string"},
+        "issue_events_url": {"type": "string"}, "events_url": {"type": "string"}, "assignees_url": {"type": "string"},
+        "branches_url": {"type": "string"}, "tags_url": {"type": "string"}, "blobs_url": {"type": "string"},
+        "git_tags_url": {"type": "string"}, "git_refs_url": {"type": "string"}, "trees_url": {"type": "string"},
+        "statuses_url": {"type": "string"}, "languages_url": {"type": "string"}, "stargazers_url": {"type": "string"},
+        "contributors_url": {"type": "string"}, "subscribers_url": {"type": "string"},
+        "subscription_url": {"type": "string"}, "commits_url": {"type": "string"},
+        "git_commits_url": {"type": "string"}, "comments_url": {"type": "string"},
+        "issue_comment_url": {"type": "string"}, "contents_url": {"type": "string"}, "compare_url": {"type": "string"},
+        "merges_url": {"type": "string"}, "archive_url": {"type": "string"}, "downloads_url": {"type": "string"},
+        "issues_url": {"type": "string"}, "pulls_url": {"type": "string"}, "milestones_url": {"type": "string"},
+        "notifications_url": {"type": "string"}, "labels_url": {"type": "string"}, "releases_url": {"type": "string"},
+        "deployments_url": {"type": "string"}, "created_at": {}, "updated_at": {"type": "string"}, "pushed_at": {},
+        "git_url": {"type": "string"}, "ssh_url": {"type": "string"}, "clone_url": {"type": "string"},
+        "svn_url": {"type": "string"}, "homepage": {"type": ["string", "null"]}, "size": {"type": "integer"},
+        "stargazers_count": {"type": "integer"}, "watchers_count": {"type": "integer"},
+        "language": {"type": ["string", "null"]}, "has_issues": {"type": "boolean"},
+        "has_projects": {"type": "boolean"}, "has_downloads": {"type": "boolean"}, "has_wiki": {"type": "boolean"},
+        "has_pages": {"type": "boolean"}, "forks_count": {"type": "integer"}, "mirror_url": {"type": ["string", "null"]},
+        "archived": {"type": "boolean"}, "disabled": {"type": "boolean"}, "open_issues_count": {"type": "integer"},
+        "license": {"type": ["object", "null"]}, "forks": {"type": "integer"}, "open_issues": {"type": "integer"},
+        "watchers": {"type": "integer"}, "default_branch": {"type": "string"}, "stargazers": {"type": "integer"},
+        "master_branch": {"type": "string"}, "organization": {"type": "string"}, "visibility": {"type": "string"},
+        "topics": {"type": "array", "items": {"type": "string"}}, "is_template": {"type": "boolean"},
+        "allow_forking": {"type": "boolean"}, "web_commit_signoff_required": {"type": "boolean"}
+      }
+    },
+    "commit": {
+      "type": "object",
+      "properties": {
+        "id": {"type": "string"}, "tree_id": {"type": "string"}, "distinct": {"type": "boolean"},
+        "message": {"type": "string"}, "timestamp": {"type": "string"}, "url": {"type": "string"},
+        "author": {"$ref": "#/definitions/gitUser"}, "committer": {"$ref": "#/definitions/gitUser"},
+        "added": {"type": "array", "items": {"type": "string"}},
+        "removed": {"type": "array", "items": {"type": "string"}},
+        "modified": {"type": "array", "items": {"type": "string"}}
+      }
+    },
+    "branch": {
+      "type": "object",
+      "properties": {
+        "label": {"type": "string"}, "ref": {"type": "string"}, "sha": {"type": "string"},
+        "user": {"$ref": "#/definitions/user"},
+        "repo": {"anyOf": [{"$ref": "#/definitions/repository"}, {"type": "null"}]}
+      }
+    },
+    "pullRequest": {
+      "type": "object",
+      "properties": {
+        "url": {"type": "string"}, "id": {"type": "integer"}, "node_id": {"type": "string"},
+        "html_url": {"type": "string"}, "diff_url": {"type": "string"}, "patch_url": {"type": "string"},
+        "issue_url": {"type": "string"}, "number": {"type": "integer"}, "state": {"type": "string"},
+        "locked": {"type": "boolean"}, "title": {"type": "string"}, "user": {"$ref": "#/definitions/user"},
+        "body": {"type": ["string", "null"]}, "created_at": {"type": "string"}, "updated_at": {"type": "string"},
+        "closed_at": {"type": ["string", "null"]}, "merged_at": {"type": ["string", "null"]},
+        "merge_commit_sha": {"type": ["string", "null"]}, "assignee": {}, "assignees": {"type": "array"},
+        "requested_reviewers": {"type": "array"}, "requested_teams": {"type": "array"}, "labels": {"type": "array"},
+        "milestone": {}, "draft": {"type": "boolean"}, "commits_url": {"type": "string"},
+        "review_comments_url": {"type": "string"}, "review_comment_url": {"type": "string"},
+        "comments_url": {"type": "string"}, "statuses_url": {"type": "string"},
+        "head": {"$ref": "#/definitions/branch"}, "base": {"$ref": "#/definitions/branch"},
+        "_links": {"type": "object"}, "author_association": {"type": "string"}, "auto_merge": {},
+        "active_lock_reason": {"type": ["string", "null"]}, "merged": {"type": "boolean"},
+        "mergeable": {"type": ["boolean", "null"]}, "rebaseable": {"type": ["boolean", "null"]},
+        "mergeable_state": {"type": "string"}, "merged_by": {}, "comments": {"type": "integer"},
+        "review_comments": {"type": "integer"}, "maintainer_can_modify": {"type": "boolean"},
+        "commits": {"type": "integer"}, "additions": {"type": "integer"}, "deletions": {"type": "integer"},
+        "changed_files": {"type": "integer"}
+      }
+    }
+  },
+  "events": {
+    "common": {
+      "sender": {"$ref": "#/definitions/user"}, "repository": {"$ref": "#/definitions/repository"},
+      "organization": {"type": "object"}, "installation": {"type": "object"}, "enterprise": {"type": "object"},
+      "kabanero": {"type": "object"}
+    },
+    "push": {
+      "ref": {"type": "string"}, "before": {"type": "string"}, "after": {"type": "string"},
+      "created": {"type": "boolean"}, "deleted": {"type": "boolean"}, "forced": {"type": "boolean"},
+      "base_ref": {"type": ["string", "null"]}, "compare": {"type": "string"},
+      "commits": {"type": "array", "items": {"$ref": "#/definitions/commit"}},
+      "head_commit": {"anyOf": [{"$ref": "#/definitions/commit"}, {"type": "null"}]},
+      "pusher": {"anyOf": [{"$ref": "#/definitions/gitUser"}, {"type": "null"}]}
+    },
+    "pull_request": {
+      "action": {"type": "string"}, "number": {"type": "integer"},
+      "pull_request": {"$ref": "#/definitions/pullRequest"}, "changes": {"type": "object"},
+      "label": {"type": "object"},
+      "requested_reviewer": {"anyOf": [{"$ref": "#/definitions/user"}, {"type": "null"}]},
+      "before": {"type": "string"}, "after": {"type": "string"}
+    },
+    "create": {
+      "ref": {"type": "string"}, "ref_type": {"type": "string"}, "master_branch": {"type": "string"},
+      "description": {"type": ["string", "null"]}, "pusher_type": {"type": "string"}
+    }
+  }
+}`
+
+/* Return the names of the built-in schemas */
+func builtinSchemaNames() []string {
+	return []string{githubSchema, githubPushSchema, githubPullRequestSchema, githubCreateSchema}
+}
+
+/* Return a built-in schema of the messages sent by the webhook listener */
+func builtinSchema(name string) (map[string]interface{}, error) {
+	var events []string
+	switch name {
+	case githubSchema:
+		events = []string{"push", "pull_request", "create"}
+	case githubPushSchema, githubPullRequestSchema, githubCreateSchema:
+		events = []string{strings.TrimPrefix(name, githubSchema+"-")}
+	default:
+		return nil, fmt.Errorf("unknown built-in schema %v. Built-in schemas are: %v", name, strings.Join(builtinSchemaNames(), ", "))
+	}
+
+	var github struct {
+		Definitions map[string]interface{}            `json:"definitions"`
+		Events      map[string]map[string]interface{} `json:"events"`
+	}
+	if err := json.Unmarshal([]byte(githubSchemaJSON), &github); err != nil {
+		return nil, err
+	}
+	properties := make(map[string]interface{})
+	for _, event := range append([]string{"common"}, events...) {
+		for key, value := range github.Events[event] {
+			properties[key] = value
+		}
+	}
+	return map[string]interface{}{
+		"definitions": github.Definitions,
+		"type":        "object",
+		"properties": map[string]interface{}{
+			"header": map[string]interface{}{
+				"type":                 "object",
+				"additionalProperties": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			},
+			"body": map[string]interface{}{
+				"type":       "object",
+				"properties": properties,
+			},
+		},
+	}, nil
+}
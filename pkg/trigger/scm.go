@@ -0,0 +1,196 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+/* types of normalized SCM events that differ from the name of the GitHub event */
+const (
+	SCMPUSH   = "push"
+	SCMTAG    = "tag"
+	SCMDELETE = "delete"
+)
+
+/* Return the value at the path of keys within nested maps, or nil if not found */
+func mapPath(obj interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		m, ok := obj.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		obj = m[key]
+	}
+	return obj
+}
+
+/* Return the string at the path of keys, or the empty string if not a string */
+func mapString(obj interface{}, keys ...string) string {
+	str, _ := mapPath(obj, keys...).(string)
+	return str
+}
+
+/* Return the bool at the path of keys, or false if not a bool */
+func mapBool(obj interface{}, keys ...string) bool {
+	b, _ := mapPath(obj, keys...).(bool)
+	return b
+}
+
+/* Return the number at the path of keys as an int, or 0 if not a number. Numbers of JSON messages are doubles. */
+func mapInt(obj interface{}, keys ...string) int64 {
+	switch number := mapPath(obj, keys...).(type) {
+	case float64:
+		return int64(number)
+	case int64:
+		return number
+	case int:
+		return int64(number)
+	}
+	return 0
+}
+
+/* Return the normalized pull request of a pull_request event, or of the issue of an issue_comment event on a pull request */
+func scmPullRequest(event string, body map[string]interface{}) map[string]interface{} {
+	pr := map[string]interface{}{
+		"number":     int64(0),
+		"title":      "",
+		"url":        "",
+		"htmlURL":    "",
+		"headBranch": "",
+		"headSHA":    "",
+		"baseBranch": "",
+		"draft":      false,
+		"merged":     false,
+		"revision":   "",
+	}
+	switch {
+	case event == "pull_request":
+		pr["number"] = mapInt(body, "pull_request", "number")
+		pr["title"] = mapString(body, "pull_request", "title")
+		pr["url"] = mapString(body, "pull_request", "url")
+		pr["htmlURL"] = mapString(body, "pull_request", "html_url")
+		pr["headBranch"] = mapString(body, "pull_request", "head", "ref")
+		pr["headSHA"] = mapString(body, "pull_request", "head", "sha")
+		pr["baseBranch"] = mapString(body, "pull_request", "base", "ref")
+		pr["draft"] = mapBool(body, "pull_request", "draft")
+		pr["merged"] = mapBool(body, "pull_request", "merged")
+	case event == "issue_comment" && mapPath(body, "issue", "pull_request") != nil:
+		/* the comment only has the number and URLs of the pull request */
+		pr["number"] = mapInt(body, "issue", "number")
+		pr["title"] = mapString(body, "issue", "title")
+		pr["url"] = mapString(body, "issue", "pull_request", "url")
+		pr["htmlURL"] = mapString(body, "issue", "pull_request", "html_url")
+	default:
+		return pr
+	}
+	pr["revision"] = fmt.Sprintf("refs/pull/%v/head", pr["number"])
+	return pr
+}
+
+/*
+Return the normalized event of a GitHub webhook message. The type is the kind of change: a push to a branch, a tag, the deletion
+of a branch or tag, a pull request, a release, or a comment on an issue or pull request. Other events have the type of the GitHub
+event. All keys are set, to empty values if they do not apply to the event.
+*/
+func scmGitHubEvent(event string, body map[string]interface{}) map[string]interface{} {
+	ret := map[string]interface{}{
+		"provider": "github",
+		"event":    event,
+		"type":     event,
+		"action":   mapString(body, "action"),
+		"sender":   mapString(body, "sender", "login"),
+		"repository": map[string]interface{}{
+			"owner":         mapString(body, "repository", "owner", "login"),
+			"name":          mapString(body, "repository", "name"),
+			"fullName":      mapString(body, "repository", "full_name"),
+			"htmlURL":       mapString(body, "repository", "html_url"),
+			"cloneURL":      mapString(body, "repository", "clone_url"),
+			"sshURL":        mapString(body, "repository", "ssh_url"),
+			"defaultBranch": mapString(body, "repository", "default_branch"),
+			"private":       mapBool(body, "repository", "private"),
+		},
+		"ref":         "",
+		"branch":      "",
+		"tag":         "",
+		"sha":         "",
+		"pullRequest": scmPullRequest(event, body),
+		"release": map[string]interface{}{
+			"name":       mapString(body, "release", "name"),
+			"prerelease": mapBool(body, "release", "prerelease"),
+			"draft":      mapBool(body, "release", "draft"),
+		},
+		"comment": map[string]interface{}{
+			"body":   mapString(body, "comment", "body"),
+			"author": mapString(body, "comment", "user", "login"),
+		},
+	}
+
+	switch event {
+	case "push":
+		ref := mapString(body, "ref")
+		ret["ref"] = ref
+		if strings.HasPrefix(ref, "refs/tags/") {
+			ret["type"] = SCMTAG
+			ret["tag"] = strings.TrimPrefix(ref, "refs/tags/")
+		} else {
+			ret["type"] = SCMPUSH
+			ret["branch"] = strings.TrimPrefix(ref, "refs/heads/")
+		}
+		if mapBool(body, "deleted") {
+			ret["type"] = SCMDELETE
+		} else {
+			ret["sha"] = mapString(body, "after")
+		}
+	case "delete", "create":
+		/* the ref of create and delete events is the name of the branch or tag */
+		name := mapString(body, "ref")
+		if mapString(body, "ref_type") == "tag" {
+			ret["ref"] = "refs/tags/" + name
+			ret["tag"] = name
+		} else {
+			ret["ref"] = "refs/heads/" + name
+			ret["branch"] = name
+		}
+	case "pull_request":
+		ret["branch"] = mapString(body, "pull_request", "base", "ref")
+		ret["ref"] = "refs/heads/" + ret["branch"].(string)
+		ret["sha"] = mapString(body, "pull_request", "head", "sha")
+	case "release":
+		tag := mapString(body, "release", "tag_name")
+		ret["tag"] = tag
+		ret["ref"] = "refs/tags/" + tag
+	}
+	return ret
+}
+
+/* implementation of scmEvent(message): the normalized event of a webhook message */
+func (p *Processor) scmEventCEL(messageVal ref.Val) ref.Val {
+	header, body, errVal := webhookMessage("scmEvent", messageVal)
+	if errVal != nil {
+		return errVal
+	}
+	if events := header[http.CanonicalHeaderKey("x-github-event")]; len(events) > 0 {
+		return types.DefaultTypeAdapter.NativeToValue(scmGitHubEvent(events[0], body))
+	}
+	return types.NewErr("function scmEvent: unsupported message. Only GitHub events, with the X-Github-Event header, are supported")
+}
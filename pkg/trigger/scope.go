@@ -0,0 +1,377 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"k8s.io/klog"
+)
+
+/* localScope records the variables declared by let in a body, and the values they hide */
+type localScope struct {
+	names       []string                // local variables, in the order they are declared
+	hidden      map[string]interface{}  // previous values of the local variables that already existed
+	hiddenTypes map[string]*exprpb.Type // declared types of the local variables that already existed
+}
+
+func newLocalScope() *localScope {
+	return &localScope{
+		names:       make([]string, 0),
+		hidden:      make(map[string]interface{}),
+		hiddenTypes: make(map[string]*exprpb.Type),
+	}
+}
+
+/* Evaluate a let statement, declaring its variables in the local scope */
+func (p *Processor) evalLet(ev *evaluation, env cel.Env, variables map[string]interface{}, stmt *Let, locals *localScope) (cel.Env, error) {
+	ev.addTraceEntry(&TraceEntry{Kind: traceLet})
+	var err error
+	for _, assignment := range stmt.Assignments {
+		name := assignment.Variable
+		previous, exists := variables[name]
+		if !locals.declared(name) {
+			locals.names = append(locals.names, name)
+			if exists {
+				locals.hidden[name] = previous
+			}
+			if varType, ok := ev.varTypes[name]; ok {
+				locals.hiddenTypes[name] = varType
+			}
+		}
+		if exists {
+			/* a CEL identifier may only be declared once, so remove the previous declaration */
+			delete(variables, name)
+			delete(ev.varTypes, name)
+			if env, err = p.declareVariables(ev, variables); err != nil {
+				return env, newStatementError(assignment.Position, assignment.Expression, err)
+			}
+		}
+		if env, err = p.evalAssignment(ev, env, variables, assignment); err != nil {
+			return env, err
+		}
+	}
+	return env, nil
+}
+
+func (locals *localScope) declared(name string) bool {
+	for _, local := range locals.names {
+		if local == name {
+			return true
+		}
+	}
+	return false
+}
+
+/* Remove the local variables at the end of a body, restoring the values and types they hid */
+func (p *Processor) endLocalScope(ev *evaluation, variables map[string]interface{}, locals *localScope) (cel.Env, error) {
+	for _, name := range locals.names {
+		if previous, ok := locals.hidden[name]; ok {
+			variables[name] = previous
+		} else {
+			delete(variables, name)
+		}
+		if varType, ok := locals.hiddenTypes[name]; ok {
+			ev.varTypes[name] = varType
+		}
+		if klog.V(6) {
+			klog.Infof("end of scope of local variable %v", name)
+		}
+	}
+	return p.declareVariables(ev, variables)
+}
+
+/*
+Add the names of the variables that statements may set, declare, or unset. Nested foreach statements only set their into
+variable, as their bodies have their own scopes. The bodies of functions are not included, as they have their own scopes.
+*/
+func assignedVariables(statements []Statement, names map[string]bool) {
+	for _, statement := range statements {
+		switch stmt := statement.(type) {
+		case *Assignment:
+			if len(stmt.Path) > 0 {
+				names[stmt.Path[0].Key] = true
+			} else {
+				names[strings.Split(stmt.Variable, ".")[0]] = true
+			}
+		case *Let:
+			for _, assignment := range stmt.Assignments {
+				names[assignment.Variable] = true
+			}
+		case *Unset:
+			for _, name := range stmt.Variables {
+				names[strings.Split(name, ".")[0]] = true
+			}
+		case *If:
+			assignedVariables(stmt.Body, names)
+		case *Switch:
+			for _, switchCase := range stmt.Cases {
+				assignedVariables(switchCase.Body, names)
+			}
+			if stmt.Default != nil {
+				assignedVariables(stmt.Default.Body, names)
+			}
+		case *Body:
+			assignedVariables(stmt.Body, names)
+		case *Foreach:
+			if stmt.Into != "" {
+				names[stmt.Into] = true
+			}
+		case *Try:
+			assignedVariables(stmt.Body, names)
+			assignedVariables(stmt.Catch, names)
+			names[stmt.ErrorVariable] = true
+		}
+	}
+}
+
+/* Evaluate an unset statement */
+func (p *Processor) evalUnset(ev *evaluation, env cel.Env, variables map[string]interface{}, stmt *Unset) (cel.Env, error) {
+	redeclare := false
+	for _, name := range stmt.Variables {
+		ev.addTraceEntry(&TraceEntry{Kind: traceUnset, Variable: name})
+		components := strings.Split(name, ".")
+		if len(components) == 1 {
+			if _, ok := variables[name]; ok {
+				delete(variables, name)
+				delete(ev.varTypes, name)
+				redeclare = true
+			}
+			continue
+		}
+		/* unset a key of a map. Nothing to do if the map does not exist */
+		current := variables
+		for _, component := range components[:len(components)-1] {
+			next, ok := current[component].(map[string]interface{})
+			if !ok {
+				current = nil
+				break
+			}
+			current = next
+		}
+		if current != nil {
+			delete(current, components[len(components)-1])
+		}
+	}
+	if !redeclare {
+		return env, nil
+	}
+	env, err := p.declareVariables(ev, variables)
+	if err != nil {
+		return env, newStatementError(stmt.Position, strings.Join(stmt.Variables, ", "), err)
+	}
+	return env, nil
+}
+
+/*
+Create a CEL environment that declares exactly the given variables, with their declared types, such as the type of the input
+variable from the schema of the event source, so that they keep the types they were checked with. Other variables have the
+types of their values.
+*/
+func (p *Processor) declareVariables(ev *evaluation, variables map[string]interface{}) (cel.Env, error) {
+	varTypes := make(map[string]*exprpb.Type)
+	for name, value := range variables {
+		if varType, ok := ev.varTypes[name]; ok {
+			varTypes[name] = varType
+		} else {
+			varTypes[name] = celTypeOf(value)
+		}
+	}
+	return p.declareTypes(varTypes)
+}
+
+/* Create a CEL environment that declares exactly the given variables, with the given types */
+func (p *Processor) declareTypes(varTypes map[string]*exprpb.Type) (cel.Env, error) {
+	env, err := p.initializeEmptyCELEnv()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(varTypes))
+	for name := range varTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	idents := make([]*exprpb.Decl, 0, len(names))
+	for _, name := range names {
+		idents = append(idents, decls.NewIdent(name, varTypes[name], nil))
+	}
+	return env.Extend(cel.Declarations(idents...))
+}
+
+/* Return the CEL type for the value of a variable */
+func celTypeOf(value interface{}) *exprpb.Type {
+	switch value.(type) {
+	case int64:
+		return decls.Int
+	case float64:
+		return decls.Double
+	case string:
+		return decls.String
+	case bool:
+		return decls.Bool
+	}
+	if value != nil {
+		switch reflect.TypeOf(value).Kind() {
+		case reflect.Slice:
+			return decls.NewListType(decls.Any)
+		case reflect.Map:
+			return decls.NewMapType(decls.String, decls.Any)
+		}
+	}
+	return decls.Dyn
+}
+
+/* Evaluate an assignment whose variable contains list indexes */
+func (p *Processor) setIndexedVariable(ev *evaluation, env cel.Env, stmt *Assignment, variables map[string]interface{}) error {
+	out, err := p.evalExpression(ev, env, stmt.Variable, stmt.Expression, variables)
+	ev.traceAssignment(stmt.Variable, stmt.Expression, out, err)
+	if err != nil {
+		return err
+	}
+	return setPathValue(variables, stmt.Path, out.Value())
+}
+
+/* Set the element of nested maps and lists at the path. Lists along the path are copied, as they may be shared. */
+func setPathValue(variables map[string]interface{}, path []PathElement, value interface{}) error {
+	var parent interface{} = variables
+	for index, element := range path {
+		last := index == len(path)-1
+		name := pathString(path[:index+1])
+		switch container := parent.(type) {
+		case map[string]interface{}:
+			if element.Key == "" {
+				return fmt.Errorf("unable to set %v: %v is not a list", pathString(path), pathString(path[:index]))
+			}
+			if last {
+				container[element.Key] = value
+				return nil
+			}
+			child, ok := container[element.Key]
+			if !ok {
+				return fmt.Errorf("unable to set %v: %v is not set", pathString(path), name)
+			}
+			child = copyContainer(child)
+			container[element.Key] = child
+			parent = child
+		case []interface{}:
+			if element.Key != "" {
+				return fmt.Errorf("unable to set %v: %v is not a map", pathString(path), pathString(path[:index]))
+			}
+			if element.Index >= len(container) {
+				return fmt.Errorf("unable to set %v: index %v out of range for list of length %v", pathString(path), element.Index, len(container))
+			}
+			if last {
+				container[element.Index] = value
+				return nil
+			}
+			child := copyContainer(container[element.Index])
+			container[element.Index] = child
+			parent = child
+		default:
+			return fmt.Errorf("unable to set %v: %v is not a map or list, but %T", pathString(path), pathString(path[:index]), parent)
+		}
+	}
+	return nil
+}
+
+/* Return a copy of a list as []interface{}, or of a map as map[string]interface{}. Maps of variables are not copied. */
+func copyContainer(value interface{}) interface{} {
+	if val, ok := value.(ref.Val); ok {
+		switch val.(type) {
+		case traits.Lister, traits.Mapper:
+			keys, items, err := getIterationItems(val)
+			if err != nil {
+				return value
+			}
+			if _, isList := val.(traits.Lister); isList {
+				ret := make([]interface{}, len(items))
+				for index, item := range items {
+					ret[index] = item
+				}
+				return ret
+			}
+			ret := make(map[string]interface{})
+			for index, key := range keys {
+				ret[fmt.Sprintf("%v", key.Value())] = items[index]
+			}
+			return ret
+		}
+		return value
+	}
+	if _, ok := value.(map[string]interface{}); ok || value == nil {
+		return value
+	}
+	container := reflect.ValueOf(value)
+	switch container.Kind() {
+	case reflect.Slice:
+		ret := make([]interface{}, container.Len())
+		for index := range ret {
+			ret[index] = container.Index(index).Interface()
+		}
+		return ret
+	case reflect.Map:
+		ret := make(map[string]interface{})
+		for _, key := range container.MapKeys() {
+			keyString := fmt.Sprintf("%v", key.Interface())
+			if keyVal, ok := key.Interface().(ref.Val); ok {
+				keyString = fmt.Sprintf("%v", keyVal.Value())
+			}
+			ret[keyString] = container.MapIndex(key).Interface()
+		}
+		return ret
+	}
+	return value
+}
+
+/* Convert a path back to a variable name */
+func pathString(path []PathElement) string {
+	var builder strings.Builder
+	for index, element := range path {
+		if element.Key == "" {
+			builder.WriteString("[" + strconv.Itoa(element.Index) + "]")
+			continue
+		}
+		if index > 0 {
+			builder.WriteString(".")
+		}
+		builder.WriteString(element.Key)
+	}
+	return builder.String()
+}
+
+/* Return the variables of the current trigger passed to templates by applyResources */
+func (ev *evaluation) templateVariables() map[string]interface{} {
+	if ev.exports == nil {
+		return shallowCopy(ev.variables)
+	}
+	ret := make(map[string]interface{})
+	for _, name := range ev.exports {
+		if value, ok := ev.variables[name]; ok {
+			ret[name] = value
+		}
+	}
+	return ret
+}
@@ -0,0 +1,446 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+/* parts of a version that may be bumped */
+const (
+	SEMVERMAJOR = "major"
+	SEMVERMINOR = "minor"
+	SEMVERPATCH = "patch"
+)
+
+/* semver is a semantic version, as defined by https://semver.org */
+type semver struct {
+	prefix     string // "v" if the version was written with it, as in tags
+	major      int64
+	minor      int64
+	patch      int64
+	prerelease []string
+	build      []string
+}
+
+/* Parse a semantic version, optionally prefixed with v */
+func parseSemver(str string) (*semver, error) {
+	version := &semver{}
+	rest := str
+	if strings.HasPrefix(rest, "v") {
+		version.prefix = "v"
+		rest = rest[1:]
+	}
+	if index := strings.Index(rest, "+"); index >= 0 {
+		build, err := parseIdentifiers(rest[index+1:], false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid semantic version %v: build %v", str, err)
+		}
+		version.build = build
+		rest = rest[:index]
+	}
+	if index := strings.Index(rest, "-"); index >= 0 {
+		prerelease, err := parseIdentifiers(rest[index+1:], true)
+		if err != nil {
+			return nil, fmt.Errorf("invalid semantic version %v: pre-release %v", str, err)
+		}
+		version.prerelease = prerelease
+		rest = rest[:index]
+	}
+	numbers := strings.Split(rest, ".")
+	if len(numbers) != 3 {
+		return nil, fmt.Errorf("invalid semantic version %v: expecting major.minor.patch", str)
+	}
+	for i, target := range []*int64{&version.major, &version.minor, &version.patch} {
+		number, err := parseNumber(numbers[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid semantic version %v: %v", str, err)
+		}
+		*target = number
+	}
+	return version, nil
+}
+
+/* Parse a version number, which may not have leading zeros */
+func parseNumber(str string) (int64, error) {
+	if str == "" || strings.Trim(str, "0123456789") != "" {
+		return 0, fmt.Errorf("%q is not a number", str)
+	}
+	if len(str) > 1 && str[0] == '0' {
+		return 0, fmt.Errorf("number %v has leading zeros", str)
+	}
+	return strconv.ParseInt(str, 10, 64)
+}
+
+/* Parse the dot separated identifiers of a pre-release or build. Numeric pre-release identifiers may not have leading zeros. */
+func parseIdentifiers(str string, prerelease bool) ([]string, error) {
+	identifiers := strings.Split(str, ".")
+	for _, identifier := range identifiers {
+		if identifier == "" {
+			return nil, fmt.Errorf("%q has an empty identifier", str)
+		}
+		for _, c := range identifier {
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-') {
+				return nil, fmt.Errorf("identifier %v contains %q", identifier, c)
+			}
+		}
+		if prerelease && isNumeric(identifier) && len(identifier) > 1 && identifier[0] == '0' {
+			return nil, fmt.Errorf("identifier %v has leading zeros", identifier)
+		}
+	}
+	return identifiers, nil
+}
+
+func isNumeric(identifier string) bool {
+	return strings.Trim(identifier, "0123456789") == ""
+}
+
+func (version *semver) String() string {
+	str := fmt.Sprintf("%v%d.%d.%d", version.prefix, version.major, version.minor, version.patch)
+	if len(version.prerelease) > 0 {
+		str += "-" + strings.Join(version.prerelease, ".")
+	}
+	if len(version.build) > 0 {
+		str += "+" + strings.Join(version.build, ".")
+	}
+	return str
+}
+
+/* Compare the precedence of versions: -1, 0, or 1. A pre-release has lower precedence than its release, and the build is ignored. */
+func (version *semver) compare(other *semver) int {
+	for _, pair := range [][2]int64{{version.major, other.major}, {version.minor, other.minor}, {version.patch, other.patch}} {
+		if pair[0] != pair[1] {
+			return compareInts(pair[0], pair[1])
+		}
+	}
+	switch {
+	case len(version.prerelease) == 0 && len(other.prerelease) == 0:
+		return 0
+	case len(version.prerelease) == 0:
+		return 1
+	case len(other.prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(version.prerelease) && i < len(other.prerelease); i++ {
+		if result := compareIdentifiers(version.prerelease[i], other.prerelease[i]); result != 0 {
+			return result
+		}
+	}
+	return compareInts(int64(len(version.prerelease)), int64(len(other.prerelease)))
+}
+
+/* Compare pre-release identifiers. Numeric identifiers compare numerically, and are lower than alphanumeric identifiers. */
+func compareIdentifiers(a, b string) int {
+	aNumeric, bNumeric := isNumeric(a), isNumeric(b)
+	switch {
+	case aNumeric && bNumeric:
+		aInt, _ := strconv.ParseInt(a, 10, 64)
+		bInt, _ := strconv.ParseInt(b, 10, 64)
+		return compareInts(aInt, bInt)
+	case aNumeric:
+		return -1
+	case bNumeric:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+/* Return the next version. The pre-release and build are dropped. Bumping a pre-release to the release it precedes only drops the pre-release. */
+func (version *semver) bump(part string) (*semver, error) {
+	next := &semver{prefix: version.prefix, major: version.major, minor: version.minor, patch: version.patch}
+	isPrerelease := len(version.prerelease) > 0
+	switch part {
+	case SEMVERMAJOR:
+		if !isPrerelease || version.minor != 0 || version.patch != 0 {
+			next.major, next.minor, next.patch = version.major+1, 0, 0
+		}
+	case SEMVERMINOR:
+		if !isPrerelease || version.patch != 0 {
+			next.minor, next.patch = version.minor+1, 0
+		}
+	case SEMVERPATCH:
+		if !isPrerelease {
+			next.patch = version.patch + 1
+		}
+	default:
+		return nil, fmt.Errorf("unknown part %v of version. Expecting one of %v, %v, or %v", part, SEMVERMAJOR, SEMVERMINOR, SEMVERPATCH)
+	}
+	return next, nil
+}
+
+/* comparator is one condition of a version constraint */
+type comparator struct {
+	operator string
+	version  *semver
+}
+
+func (c *comparator) matches(version *semver) bool {
+	result := version.compare(c.version)
+	switch c.operator {
+	case "=":
+		return result == 0
+	case "!=":
+		return result != 0
+	case ">":
+		return result > 0
+	case ">=":
+		return result >= 0
+	case "<":
+		return result < 0
+	default: // "<="
+		return result <= 0
+	}
+}
+
+/* operators of comparators, longest first so that a prefix does not hide a longer operator */
+var constraintOperators = []string{">=", "<=", "!=", ">", "<", "=", "~", "^"}
+
+/* Parse a constraint: alternatives separated by ||, each a list of comparators separated by spaces or commas that must all match */
+func parseConstraint(constraint string) ([][]*comparator, error) {
+	alternatives := make([][]*comparator, 0)
+	for _, alternative := range strings.Split(constraint, "||") {
+		comparators := make([]*comparator, 0)
+		fields := strings.Fields(strings.Replace(alternative, ",", " ", -1))
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			operator := "="
+			for _, op := range constraintOperators {
+				if strings.HasPrefix(field, op) {
+					operator = op
+					break
+				}
+			}
+			versionStr := strings.TrimPrefix(field, operator)
+			if versionStr == "" && i+1 < len(fields) {
+				/* operator separated from the version by a space */
+				i++
+				versionStr = fields[i]
+			}
+			expanded, err := expandComparator(operator, versionStr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid constraint %v: %v", constraint, err)
+			}
+			comparators = append(comparators, expanded...)
+		}
+		if len(comparators) == 0 {
+			return nil, fmt.Errorf("invalid constraint %v: empty alternative", constraint)
+		}
+		alternatives = append(alternatives, comparators)
+	}
+	return alternatives, nil
+}
+
+/*
+Expand a comparator with a possibly partial version. ~ allows patch updates, and ^ updates that do not change the leftmost non-zero number.
+A partial version stands for all the versions it is a prefix of, so >1.2 is >=1.3.0, and <=1.2 is <1.3.0.
+*/
+func expandComparator(operator string, versionStr string) ([]*comparator, error) {
+	/* wildcards in place of numbers make the version partial */
+	parts := strings.Split(versionStr, ".")
+	for len(parts) > 0 && (parts[len(parts)-1] == "x" || parts[len(parts)-1] == "X" || parts[len(parts)-1] == "*") {
+		parts = parts[:len(parts)-1]
+	}
+	if len(parts) == 0 {
+		if operator == ">" || operator == "<" || operator == "!=" {
+			/* no version follows or precedes all versions */
+			return []*comparator{{operator: "<", version: &semver{prerelease: []string{"0"}}}}, nil
+		}
+		return []*comparator{{operator: ">=", version: &semver{}}}, nil
+	}
+	versionStr = strings.Join(parts, ".")
+	numParts := len(strings.Split(strings.SplitN(strings.SplitN(versionStr, "-", 2)[0], "+", 2)[0], "."))
+	fullStr := versionStr
+	if numParts < 3 {
+		if strings.ContainsAny(versionStr, "-+") {
+			return nil, fmt.Errorf("version %v with a pre-release or build must have a patch number", versionStr)
+		}
+		fullStr += strings.Repeat(".0", 3-numParts)
+	}
+	version, err := parseSemver(fullStr)
+	if err != nil {
+		return nil, err
+	}
+
+	/* the version following all the versions a partial version is a prefix of */
+	var next *semver
+	switch numParts {
+	case 1:
+		next = &semver{major: version.major + 1}
+	case 2:
+		next = &semver{major: version.major, minor: version.minor + 1}
+	}
+
+	var upper *semver
+	switch operator {
+	case "~":
+		if numParts == 1 {
+			upper = next
+		} else {
+			upper = &semver{major: version.major, minor: version.minor + 1}
+		}
+	case "^":
+		switch {
+		case version.major != 0 || numParts == 1:
+			upper = &semver{major: version.major + 1}
+		case version.minor != 0 || numParts == 2:
+			upper = &semver{minor: version.minor + 1}
+		default:
+			upper = &semver{patch: version.patch + 1}
+		}
+	case "=":
+		if numParts < 3 {
+			/* a partial version matches any version it is a prefix of */
+			return expandComparator("~", versionStr)
+		}
+		return []*comparator{{operator: operator, version: version}}, nil
+	case ">":
+		if next != nil {
+			return []*comparator{{operator: ">=", version: next}}, nil
+		}
+		return []*comparator{{operator: operator, version: version}}, nil
+	case "<=":
+		if next != nil {
+			next.prerelease = []string{"0"}
+			return []*comparator{{operator: "<", version: next}}, nil
+		}
+		return []*comparator{{operator: operator, version: version}}, nil
+	case "<":
+		if numParts < 3 {
+			/* the pre-releases of the first version of the prefix precede it */
+			version.prerelease = []string{"0"}
+		}
+		return []*comparator{{operator: operator, version: version}}, nil
+	default:
+		return []*comparator{{operator: operator, version: version}}, nil
+	}
+	/* exclude the pre-releases of the upper bound, which precede it */
+	upper.prerelease = []string{"0"}
+	return []*comparator{{operator: ">=", version: version}, {operator: "<", version: upper}}, nil
+}
+
+/* Return whether the version satisfies the constraint */
+func satisfies(version *semver, constraint string) (bool, error) {
+	alternatives, err := parseConstraint(constraint)
+	if err != nil {
+		return false, err
+	}
+	for _, comparators := range alternatives {
+		matched := true
+		for _, c := range comparators {
+			if !c.matches(version) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+/* Return the version parameter of a semver function */
+func semverParam(function string, position string, val ref.Val) (*semver, ref.Val) {
+	str, ok := val.(types.String)
+	if !ok {
+		return nil, types.ValOrErr(val, "unexpected type '%v' passed as %v parameter to function %v", val.Type(), position, function)
+	}
+	version, err := parseSemver(string(str))
+	if err != nil {
+		return nil, types.NewErr("function %v: %v", function, err)
+	}
+	return version, nil
+}
+
+/* implementation of semver: return the parts of a version */
+func (p *Processor) semverCEL(val ref.Val) ref.Val {
+	version, errVal := semverParam("semver", "first", val)
+	if errVal != nil {
+		return errVal
+	}
+	prerelease := strings.Join(version.prerelease, ".")
+	return types.NewDynamicMap(types.DefaultTypeAdapter, map[string]interface{}{
+		"major":        version.major,
+		"minor":        version.minor,
+		"patch":        version.patch,
+		"prerelease":   prerelease,
+		"build":        strings.Join(version.build, "."),
+		"isPrerelease": prerelease != "",
+	})
+}
+
+/* implementation of semverCompare: -1, 0, or 1 when the first version precedes, equals, or follows the second */
+func (p *Processor) semverCompareCEL(aVal ref.Val, bVal ref.Val) ref.Val {
+	a, errVal := semverParam("semverCompare", "first", aVal)
+	if errVal != nil {
+		return errVal
+	}
+	b, errVal := semverParam("semverCompare", "second", bVal)
+	if errVal != nil {
+		return errVal
+	}
+	return types.Int(a.compare(b))
+}
+
+/* implementation of semverSatisfies: whether the version satisfies a constraint such as ">=1.2.0 <2.0.0" */
+func (p *Processor) semverSatisfiesCEL(versionVal ref.Val, constraintVal ref.Val) ref.Val {
+	version, errVal := semverParam("semverSatisfies", "first", versionVal)
+	if errVal != nil {
+		return errVal
+	}
+	constraint, ok := constraintVal.(types.String)
+	if !ok {
+		return types.ValOrErr(constraintVal, "unexpected type '%v' passed as second parameter to function semverSatisfies", constraintVal.Type())
+	}
+	result, err := satisfies(version, string(constraint))
+	if err != nil {
+		return types.NewErr("function semverSatisfies: %v", err)
+	}
+	return types.Bool(result)
+}
+
+/* implementation of semverBump: the next major, minor, or patch version */
+func (p *Processor) semverBumpCEL(versionVal ref.Val, partVal ref.Val) ref.Val {
+	version, errVal := semverParam("semverBump", "first", versionVal)
+	if errVal != nil {
+		return errVal
+	}
+	part, ok := partVal.(types.String)
+	if !ok {
+		return types.ValOrErr(partVal, "unexpected type '%v' passed as second parameter to function semverBump", partVal.Type())
+	}
+	next, err := version.bump(string(part))
+	if err != nil {
+		return types.NewErr("function semverBump: %v", err)
+	}
+	return types.String(next.String())
+}
@@ -0,0 +1,314 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/go-github/github"
+	"github.com/kabanero-io/kabanero-events/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog"
+)
+
+/* constants for reporting the status of PipelineRuns */
+const (
+	PIPELINERUNCHECKS       = "pipelineRunChecks"
+	PIPELINERUNCHECKTIMEOUT = "pipelineRunCheckTimeout"
+)
+
+/* default time to watch a PipelineRun for its check run */
+const defaultPipelineRunCheckTimeout = 2 * time.Hour
+
+/* time allowed to report the status of a PipelineRun to GitHub */
+const pipelineRunReportTimeout = 30 * time.Second
+
+/* maximum length of the description of a commit status */
+const maxStatusDescription = 140
+
+/* states of a commit status */
+var commitStates = map[string]bool{"pending": true, "success": true, "failure": true, "error": true}
+
+/* Return the repository and commit of a webhook message, or an error if the event is not for a commit */
+func (p *Processor) messageCommit(function string, header map[string][]string, body map[string]interface{}) (*utils.GitHubRepository, ref.Val) {
+	repo, errVal := p.messageRepository(function, header, body)
+	if errVal != nil {
+		return nil, errVal
+	}
+	if repo.Ref == "" || repo.Ref == zeroSHA {
+		return nil, types.NewErr("function %v: the event has no commit. Expecting a push or pull_request event", function)
+	}
+	return repo, nil
+}
+
+/*
+implementation of setCommitStatus(message, state, context, description, targetURL): set the status of the commit of a push or
+pull request. The state is pending, success, failure, or error. Return true if the status is set, or false if dryrun is set.
+*/
+func (p *Processor) setCommitStatusCEL(ev *evaluation, values ...ref.Val) ref.Val {
+	if len(values) != 5 {
+		return types.NewErr("function setCommitStatus expects 5 parameters, but got %v", len(values))
+	}
+	header, body, errVal := webhookMessage("setCommitStatus", values[0])
+	if errVal != nil {
+		return errVal
+	}
+	positions := []string{"second", "third", "fourth", "fifth"}
+	params := make([]string, len(positions))
+	for index, position := range positions {
+		str, errVal := stringParam("setCommitStatus", position, values[index+1])
+		if errVal != nil {
+			return errVal
+		}
+		params[index] = str
+	}
+	state, statusContext, description, targetURL := params[0], params[1], params[2], params[3]
+	if !commitStates[state] {
+		return types.NewErr("function setCommitStatus: invalid state %v. Expecting pending, success, failure, or error", state)
+	}
+	if statusContext == "" {
+		return types.NewErr("function setCommitStatus: the context is empty")
+	}
+	if len(description) > maxStatusDescription {
+		description = description[:maxStatusDescription-3] + "..."
+	}
+	repo, errVal := p.messageCommit("setCommitStatus", header, body)
+	if errVal != nil {
+		return errVal
+	}
+	if p.triggerDef.isDryRun() {
+		klog.Infof("setCommitStatus: dryrun is set. Status %v %v of commit %v of %v/%v not set", statusContext, state, repo.Ref, repo.Owner, repo.Name)
+		return types.False
+	}
+	client, err := p.gitHubClient(ev.ctx, repo)
+	if err != nil {
+		return types.NewErr("function setCommitStatus: %v", err)
+	}
+	if err = utils.SetGitHubCommitStatus(ev.ctx, client, repo, repo.Ref, state, statusContext, description, targetURL); err != nil {
+		return types.NewErr("function setCommitStatus: %v", err)
+	}
+	return types.True
+}
+
+/*
+pipelineRunReport reports the status of a PipelineRun to the commit of the event that created it, as a check run, or as a
+commit status if GitHub does not allow the credentials to create check runs.
+*/
+type pipelineRunReport struct {
+	client     *github.Client
+	repo       *utils.GitHubRepository
+	name       string // name of the check run, or context of the commit status
+	checkRunID int64  // ID of the check run, or 0 to report commit statuses
+	state      string // last state reported
+}
+
+/* Return whether the state is the conclusion of a completed PipelineRun */
+func isCompletedState(state string) bool {
+	return state != "queued" && state != "in_progress"
+}
+
+/*
+Report the state of the PipelineRun: queued, in_progress, or the conclusion of a completed check run: success, failure,
+cancelled, timed_out, or neutral if the outcome is not known. A state already reported is not reported again.
+*/
+func (r *pipelineRunReport) report(ctx context.Context, state string, summary string) error {
+	if state == r.state {
+		return nil
+	}
+	r.state = state
+	if r.checkRunID != 0 {
+		if isCompletedState(state) {
+			return utils.UpdateGitHubCheckRun(ctx, r.client, r.repo, r.checkRunID, r.name, "completed", state, summary)
+		}
+		return utils.UpdateGitHubCheckRun(ctx, r.client, r.repo, r.checkRunID, r.name, state, "", summary)
+	}
+	var commitState string
+	switch state {
+	case "queued", "in_progress":
+		commitState = "pending"
+	case "success":
+		commitState = "success"
+	case "failure", "timed_out":
+		commitState = "failure"
+	case "cancelled", "neutral":
+		/* a commit status has no state for an unknown outcome, and must not stay pending */
+		commitState = "error"
+	default:
+		/* the outcome is not known */
+		return nil
+	}
+	if len(summary) > maxStatusDescription {
+		summary = summary[:maxStatusDescription-3] + "..."
+	}
+	return utils.SetGitHubCommitStatus(ctx, r.client, r.repo, r.repo.Ref, commitState, r.name, summary, "")
+}
+
+/* Return the state of a PipelineRun from its Succeeded condition, and the message of the condition */
+func pipelineRunState(obj *unstructured.Unstructured) (string, string) {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, conditionObj := range conditions {
+		condition, ok := conditionObj.(map[string]interface{})
+		if !ok || condition["type"] != "Succeeded" {
+			continue
+		}
+		message, _ := condition["message"].(string)
+		switch condition["status"] {
+		case "True":
+			return "success", message
+		case "False":
+			switch condition["reason"] {
+			case "PipelineRunCancelled":
+				return "cancelled", message
+			case "PipelineRunTimeout":
+				return "timed_out", message
+			}
+			return "failure", message
+		default:
+			return "in_progress", message
+		}
+	}
+	return "queued", ""
+}
+
+/*
+Report a PipelineRun created by applyResources to the commit of the event as a check run, if the pipelineRunChecks setting is
+set, and keep updating the check run until the PipelineRun completes. Reporting does not fail the trigger, so errors are logged.
+*/
+func (p *Processor) reportPipelineRun(ev *evaluation, obj *unstructured.Unstructured) {
+	if enabled, _ := p.triggerDef.getSetting(PIPELINERUNCHECKS).(bool); !enabled || obj.GetKind() != "PipelineRun" {
+		return
+	}
+	header, err := convertToHeaderMap(ev.message[HEADER])
+	body, ok := ev.message[BODY].(map[string]interface{})
+	if err != nil || !ok {
+		if klog.V(4) {
+			klog.Infof("Not reporting the status of PipelineRun %v/%v: the event is not a webhook message", obj.GetNamespace(), obj.GetName())
+		}
+		return
+	}
+	repo, errVal := p.messageCommit("pipelineRunChecks", header, body)
+	if errVal != nil {
+		if klog.V(4) {
+			klog.Infof("Not reporting the status of PipelineRun %v/%v: %v", obj.GetNamespace(), obj.GetName(), errVal)
+		}
+		return
+	}
+	gv, err := schema.ParseGroupVersion(obj.GetAPIVersion())
+	if err != nil {
+		klog.Errorf("Unable to report the status of PipelineRun %v/%v: %v", obj.GetNamespace(), obj.GetName(), err)
+		return
+	}
+	client, err := p.gitHubClient(ev.ctx, repo)
+	if err != nil {
+		klog.Errorf("Unable to report the status of PipelineRun %v/%v: %v", obj.GetNamespace(), obj.GetName(), err)
+		return
+	}
+
+	/* name the check after the pipeline, so that it is the same for all PipelineRuns of the pipeline */
+	name, _, _ := unstructured.NestedString(obj.Object, "spec", "pipelineRef", "name")
+	if name == "" {
+		name = obj.GetName()
+	}
+	report := &pipelineRunReport{client: client, repo: repo, name: name}
+	report.checkRunID, err = utils.CreateGitHubCheckRun(ev.ctx, client, repo, name, repo.Ref, obj.GetNamespace()+"/"+obj.GetName())
+	if err == nil {
+		report.state = "queued"
+	} else {
+		klog.Infof("Reporting the status of PipelineRun %v/%v as a commit status: %v", obj.GetNamespace(), obj.GetName(), err)
+		if err = report.report(ev.ctx, "queued", "PipelineRun "+obj.GetName()+" created"); err != nil {
+			klog.Errorf("Unable to report the status of PipelineRun %v/%v: %v", obj.GetNamespace(), obj.GetName(), err)
+			return
+		}
+	}
+	resource := p.env.DynamicClient.Resource(gv.WithResource(kindToPlural(obj.GetKind()))).Namespace(obj.GetNamespace())
+	go p.watchPipelineRun(report, resource, obj.GetName(), p.triggerDef.getSettingDuration(PIPELINERUNCHECKTIMEOUT, defaultPipelineRunCheckTimeout))
+}
+
+/* Watch a PipelineRun and report its state until it completes, until the timeout, or until the processor is stopped */
+func (p *Processor) watchPipelineRun(report *pipelineRunReport, resource dynamic.ResourceInterface, name string, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(p.ctx, timeout)
+	defer cancel()
+	for ctx.Err() == nil {
+		watcher, err := resource.Watch(metav1.ListOptions{FieldSelector: "metadata.name=" + name})
+		if err != nil {
+			klog.Errorf("Unable to watch PipelineRun %v: %v", name, err)
+			return
+		}
+		completed := report.reportEvents(ctx, watcher, name)
+		watcher.Stop()
+		if completed {
+			return
+		}
+		/* the watch ended before the PipelineRun completed, as watches of the API server expire */
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+	if p.ctx.Err() != nil {
+		if klog.V(4) {
+			klog.Infof("Stopped reporting the status of PipelineRun %v", name)
+		}
+		return
+	}
+	reportCtx, reportCancel := context.WithTimeout(context.Background(), pipelineRunReportTimeout)
+	defer reportCancel()
+	if err := report.report(reportCtx, "neutral", fmt.Sprintf("The status of PipelineRun %v is unknown: it did not complete in %v", name, timeout)); err != nil {
+		klog.Errorf("Unable to report the status of PipelineRun %v: %v", name, err)
+	}
+}
+
+/* Report the state of the PipelineRun for each event of the watch. Return true when the PipelineRun is completed. */
+func (r *pipelineRunReport) reportEvents(ctx context.Context, watcher watch.Interface, name string) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return false
+			}
+			obj, isUnstructured := event.Object.(*unstructured.Unstructured)
+			if !isUnstructured || obj.GetName() != name {
+				continue
+			}
+			state, summary := pipelineRunState(obj)
+			if event.Type == watch.Deleted && !isCompletedState(state) {
+				state, summary = "cancelled", fmt.Sprintf("PipelineRun %v was deleted", name)
+			} else if event.Type != watch.Added && event.Type != watch.Modified && event.Type != watch.Deleted {
+				continue
+			}
+			reportCtx, cancel := context.WithTimeout(ctx, pipelineRunReportTimeout)
+			err := r.report(reportCtx, state, summary)
+			cancel()
+			if err != nil {
+				klog.Errorf("Unable to report the status of PipelineRun %v: %v", name, err)
+			}
+			if isCompletedState(state) || event.Type == watch.Deleted {
+				return true
+			}
+		}
+	}
+}
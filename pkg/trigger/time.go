@@ -0,0 +1,269 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+/* Times are passed to and returned from CEL functions as strings in this format */
+const timeFormat = time.RFC3339
+
+/* abbreviations of the days of the week in time windows, in the order of time.Weekday */
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// SetClock sets the function returning the current time for the time functions of triggers. It is time.Now by default.
+func (p *Processor) SetClock(clock func() time.Time) {
+	p.clock = clock
+}
+
+/* Return the current time */
+func (p *Processor) now() time.Time {
+	if p.clock == nil {
+		return time.Now()
+	}
+	return p.clock()
+}
+
+/* Return the string parameter of a time function */
+func timeStringParam(function string, position string, val ref.Val) (string, ref.Val) {
+	str, ok := val.(types.String)
+	if !ok {
+		return "", types.ValOrErr(val, "unexpected type '%v' passed as %v parameter to function %v", val.Type(), position, function)
+	}
+	return string(str), nil
+}
+
+/* Return the time parameter of a time function */
+func timeParam(function string, position string, val ref.Val) (time.Time, ref.Val) {
+	str, errVal := timeStringParam(function, position, val)
+	if errVal != nil {
+		return time.Time{}, errVal
+	}
+	t, err := time.Parse(timeFormat, str)
+	if err != nil {
+		return time.Time{}, types.NewErr("function %v: invalid time %v. Expecting a time such as %v", function, str, timeFormat)
+	}
+	return t, nil
+}
+
+/* Return the location parameter of a time function. The names are those of the IANA time zone database, such as Europe/Berlin. */
+func locationParam(function string, position string, val ref.Val) (*time.Location, ref.Val) {
+	name, errVal := timeStringParam(function, position, val)
+	if errVal != nil {
+		return nil, errVal
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, types.NewErr("function %v: unknown time zone %v: %v", function, name, err)
+	}
+	return location, nil
+}
+
+/* implementation of now: the current time in UTC */
+func (p *Processor) nowCEL(values ...ref.Val) ref.Val {
+	return types.String(p.now().UTC().Format(timeFormat))
+}
+
+/* implementation of formatTime(time, layout [, timezone]): format a time with a Go layout, such as 2006-01-02 15:04 */
+func (p *Processor) formatTimeCEL(values ...ref.Val) ref.Val {
+	if len(values) != 2 && len(values) != 3 {
+		return types.NewErr("function formatTime expects 2 or 3 parameters, but got %v", len(values))
+	}
+	t, errVal := timeParam("formatTime", "first", values[0])
+	if errVal != nil {
+		return errVal
+	}
+	layout, errVal := timeStringParam("formatTime", "second", values[1])
+	if errVal != nil {
+		return errVal
+	}
+	if len(values) == 3 {
+		location, errVal := locationParam("formatTime", "third", values[2])
+		if errVal != nil {
+			return errVal
+		}
+		t = t.In(location)
+	}
+	return types.String(t.Format(layout))
+}
+
+/* implementation of parseTime(str, layout [, timezone]): parse a time with a Go layout. The time zone applies if the layout has none. */
+func (p *Processor) parseTimeCEL(values ...ref.Val) ref.Val {
+	if len(values) != 2 && len(values) != 3 {
+		return types.NewErr("function parseTime expects 2 or 3 parameters, but got %v", len(values))
+	}
+	str, errVal := timeStringParam("parseTime", "first", values[0])
+	if errVal != nil {
+		return errVal
+	}
+	layout, errVal := timeStringParam("parseTime", "second", values[1])
+	if errVal != nil {
+		return errVal
+	}
+	location := time.UTC
+	if len(values) == 3 {
+		if location, errVal = locationParam("parseTime", "third", values[2]); errVal != nil {
+			return errVal
+		}
+	}
+	t, err := time.ParseInLocation(layout, str, location)
+	if err != nil {
+		return types.NewErr("function parseTime: %v", err)
+	}
+	return types.String(t.UTC().Format(timeFormat))
+}
+
+/* implementation of timezone(time, timezone): the same time, with the offset of the time zone */
+func (p *Processor) timezoneCEL(timeVal ref.Val, locationVal ref.Val) ref.Val {
+	t, errVal := timeParam("timezone", "first", timeVal)
+	if errVal != nil {
+		return errVal
+	}
+	location, errVal := locationParam("timezone", "second", locationVal)
+	if errVal != nil {
+		return errVal
+	}
+	return types.String(t.In(location).Format(timeFormat))
+}
+
+/* implementation of inTimeWindow(window, timezone [, time]): whether the time, by default now, is within the window in the time zone */
+func (p *Processor) inTimeWindowCEL(values ...ref.Val) ref.Val {
+	if len(values) != 2 && len(values) != 3 {
+		return types.NewErr("function inTimeWindow expects 2 or 3 parameters, but got %v", len(values))
+	}
+	windowStr, errVal := timeStringParam("inTimeWindow", "first", values[0])
+	if errVal != nil {
+		return errVal
+	}
+	location, errVal := locationParam("inTimeWindow", "second", values[1])
+	if errVal != nil {
+		return errVal
+	}
+	t := p.now()
+	if len(values) == 3 {
+		if t, errVal = timeParam("inTimeWindow", "third", values[2]); errVal != nil {
+			return errVal
+		}
+	}
+	window, err := parseTimeWindow(windowStr)
+	if err != nil {
+		return types.NewErr("function inTimeWindow: %v", err)
+	}
+	return types.Bool(window.contains(t.In(location)))
+}
+
+/* timeWindow is a range of time of the day on some days of the week */
+type timeWindow struct {
+	days  [7]bool       // indexed by time.Weekday
+	start time.Duration // since midnight
+	end   time.Duration // since midnight. The window crosses midnight if end <= start.
+}
+
+/* Parse a time window: days of the week, a time range, or both, such as "Mon-Fri 08:00-18:00", "Sat,Sun", or "22:00-06:00" */
+func parseTimeWindow(str string) (*timeWindow, error) {
+	window := &timeWindow{end: 24 * time.Hour}
+	fields := strings.Fields(str)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid time window %q. Expecting days, a time range, or both, such as \"Mon-Fri 08:00-18:00\"", str)
+	}
+	if len(fields) == 2 || strings.Contains(fields[0], ":") {
+		var err error
+		rangeStr := fields[len(fields)-1]
+		bounds := strings.Split(rangeStr, "-")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid time range %v in time window %q. Expecting a range such as 08:00-18:00", rangeStr, str)
+		}
+		if window.start, err = parseTimeOfDay(bounds[0]); err != nil {
+			return nil, fmt.Errorf("invalid time window %q: %v", str, err)
+		}
+		if window.end, err = parseTimeOfDay(bounds[1]); err != nil {
+			return nil, fmt.Errorf("invalid time window %q: %v", str, err)
+		}
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) == 0 {
+		for day := range window.days {
+			window.days[day] = true
+		}
+		return window, nil
+	}
+	for _, daysStr := range strings.Split(fields[0], ",") {
+		bounds := strings.Split(daysStr, "-")
+		if len(bounds) > 2 {
+			return nil, fmt.Errorf("invalid days %v in time window %q. Expecting days such as Mon-Fri or Sat,Sun", daysStr, str)
+		}
+		first, err := parseWeekday(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid time window %q: %v", str, err)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = parseWeekday(bounds[1]); err != nil {
+				return nil, fmt.Errorf("invalid time window %q: %v", str, err)
+			}
+		}
+		/* a range of days may wrap around the end of the week, as in Fri-Mon */
+		for day := first; ; day = (day + 1) % 7 {
+			window.days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	return window, nil
+}
+
+/* Parse a time of the day such as 08:00 or 24:00 */
+func parseTimeOfDay(str string) (time.Duration, error) {
+	var hours, minutes int
+	if n, err := fmt.Sscanf(str, "%d:%d", &hours, &minutes); err != nil || n != 2 || len(str) != 5 {
+		return 0, fmt.Errorf("invalid time %v. Expecting a time such as 08:00", str)
+	}
+	if hours < 0 || minutes < 0 || minutes > 59 || hours > 24 || hours == 24 && minutes != 0 {
+		return 0, fmt.Errorf("invalid time %v", str)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+func parseWeekday(str string) (int, error) {
+	for day, name := range weekdays {
+		if strings.ToLower(str) == name {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid day %v. Expecting one of Mon, Tue, Wed, Thu, Fri, Sat, or Sun", str)
+}
+
+/* Return whether a time is within the window. The part of a window after midnight belongs to the day the window started. */
+func (window *timeWindow) contains(t time.Time) bool {
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	day := int(t.Weekday())
+	if window.start < window.end {
+		return window.days[day] && sinceMidnight >= window.start && sinceMidnight < window.end
+	}
+	/* the window crosses midnight */
+	if sinceMidnight >= window.start {
+		return window.days[day]
+	}
+	return sinceMidnight < window.end && window.days[(day+6)%7]
+}
@@ -0,0 +1,292 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/kabanero-io/kabanero-events/pkg/endpoints"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter/functions"
+	"k8s.io/klog"
+)
+
+/* constants for tracing */
+const (
+	TRACE           = "trace"
+	TRACEHEADERSET  = "traceHeader"
+	TRACEHEADER     = "X-Kabanero-Trace"
+	TRACEREDACTED   = "<redacted>"
+	EVENTIDHEADER   = "X-Kabanero-Event-Id"
+	GITHUBDELIVERY  = "X-Github-Delivery"
+	maxTraces       = 100 // maximum number of traces kept in memory
+	traceAssignment = "assignment"
+	traceIf         = "if"
+	traceSwitch     = "switch"
+	traceDefault    = "default"
+	traceFunction   = "function"
+	traceTrigger    = "trigger"
+	traceForeach    = "foreach"
+	traceCatch      = "catch"
+	traceLet        = "let"
+	traceUnset      = "unset"
+)
+
+// Trace records the statements evaluated while processing one event.
+type Trace struct {
+	EventID     string        `json:"eventID"`
+	EventSource string        `json:"eventSource"`
+	Start       time.Time     `json:"start"`
+	Entries     []*TraceEntry `json:"entries"`
+}
+
+// TraceEntry records one statement or built-in function call.
+type TraceEntry struct {
+	Trigger   int           `json:"trigger"`             // index of the trigger for the event source
+	Kind      string        `json:"kind"`                // trigger, assignment, if, switch, default, foreach, catch, let, unset, or function
+	Statement string        `json:"statement,omitempty"` // expression or condition evaluated
+	Variable  string        `json:"variable,omitempty"`  // variable assigned
+	Value     interface{}   `json:"value,omitempty"`     // value assigned
+	Taken     *bool         `json:"taken,omitempty"`     // whether if or default branch was taken
+	Function  string        `json:"function,omitempty"`  // name of built-in function called
+	Arguments []interface{} `json:"arguments,omitempty"` // arguments of built-in function
+	Result    interface{}   `json:"result,omitempty"`    // result of built-in function
+	Duration  string        `json:"duration,omitempty"`  // time spent in built-in function
+	Error     string        `json:"error,omitempty"`
+}
+
+/* traceStore keeps the most recent traces, keyed by event ID */
+type traceStore struct {
+	mutex  sync.Mutex
+	traces map[string]*Trace
+	order  []string // event IDs in the order they were stored
+}
+
+func newTraceStore() *traceStore {
+	return &traceStore{
+		traces: make(map[string]*Trace),
+		order:  make([]string, 0),
+	}
+}
+
+func (store *traceStore) put(trace *Trace) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, exists := store.traces[trace.EventID]; !exists {
+		store.order = append(store.order, trace.EventID)
+	}
+	store.traces[trace.EventID] = trace
+	for len(store.order) > maxTraces {
+		delete(store.traces, store.order[0])
+		store.order = store.order[1:]
+	}
+}
+
+func (store *traceStore) get(eventID string) *Trace {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.traces[eventID]
+}
+
+func (store *traceStore) ids() []string {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	ret := make([]string, len(store.order))
+	copy(ret, store.order)
+	return ret
+}
+
+// GetTrace returns the trace of an event, or nil if the event was not traced.
+func (p *Processor) GetTrace(eventID string) *Trace {
+	return p.traces.get(eventID)
+}
+
+// TraceIDs returns the IDs of the events whose traces are available.
+func (p *Processor) TraceIDs() []string {
+	return p.traces.ids()
+}
+
+/* sequence number for generated event IDs */
+var eventSequence uint64
+
+/* Return the ID of an event: the Kabanero event ID or Github delivery ID in the header if present, otherwise a generated ID */
+func getEventID(message map[string]interface{}) string {
+	for _, key := range []string{EVENTIDHEADER, GITHUBDELIVERY} {
+		if id := getMessageHeader(message, key); id != "" {
+			return id
+		}
+	}
+	return fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102150405"), atomic.AddUint64(&eventSequence, 1))
+}
+
+/* Return the first value of a header of the message, matched case insensitively, or the empty string */
+func getMessageHeader(message map[string]interface{}, key string) string {
+	header, err := convertToHeaderMap(message[HEADER])
+	if err != nil {
+		return ""
+	}
+	for name, values := range header {
+		if strings.EqualFold(name, key) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+/*
+Return true if tracing is requested by the settings, or by the header of the message. As any sender of events could set the
+header, it is honored only if the traceHeader setting is true.
+*/
+func (p *Processor) isTraceRequested(message map[string]interface{}) bool {
+	if b, ok := p.triggerDef.getSetting(TRACE).(bool); ok && b {
+		return true
+	}
+	if b, ok := p.triggerDef.getSetting(TRACEHEADERSET).(bool); !ok || !b {
+		return false
+	}
+	return strings.EqualFold(getMessageHeader(message, TRACEHEADER), "true")
+}
+
+/*
+built-in functions whose arguments and results may carry credentials or cluster data, and are not recorded in traces. Once
+one of them is called, any value of the trigger may be derived from its result, so no later value is recorded either.
+*/
+var redactedFunctions = map[string]bool{"httpGet": true, "httpPost": true, "getResource": true, "listResources": true}
+
+/* Add an entry to the trace, if tracing the current trigger */
+func (ev *evaluation) addTraceEntry(entry *TraceEntry) {
+	if !ev.tracing {
+		return
+	}
+	entry.Trigger = ev.triggerIndex
+	ev.trace.Entries = append(ev.trace.Entries, entry)
+}
+
+func (ev *evaluation) traceAssignment(variable string, statement string, value interface{}, err error) {
+	if !ev.tracing {
+		return
+	}
+	entry := &TraceEntry{Kind: traceAssignment, Variable: variable, Statement: statement, Value: ev.traceValue(value)}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	ev.addTraceEntry(entry)
+}
+
+func (ev *evaluation) traceBranch(kind string, condition string, taken bool, err error) {
+	if !ev.tracing {
+		return
+	}
+	entry := &TraceEntry{Kind: kind, Statement: condition, Taken: &taken}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	ev.addTraceEntry(entry)
+}
+
+func (ev *evaluation) traceCall(function string, args []ref.Val, result ref.Val, start time.Time) {
+	if !ev.tracing {
+		return
+	}
+	if redactedFunctions[function] {
+		ev.redacting = true
+	}
+	entry := &TraceEntry{Kind: traceFunction, Function: function, Duration: time.Since(start).String()}
+	for _, arg := range args {
+		entry.Arguments = append(entry.Arguments, ev.traceValue(arg))
+	}
+	if result != nil {
+		if err, ok := result.Value().(error); ok {
+			entry.Error = err.Error()
+		} else {
+			entry.Result = ev.traceValue(result)
+		}
+	}
+	ev.addTraceEntry(entry)
+}
+
+/* Wrap implementations of built-in functions to record calls in the trace */
+func (ev *evaluation) tracedUnary(function string, op functions.UnaryOp) functions.UnaryOp {
+	return func(value ref.Val) ref.Val {
+		start := time.Now()
+		ret := op(value)
+		ev.traceCall(function, []ref.Val{value}, ret, start)
+		return ret
+	}
+}
+
+func (ev *evaluation) tracedBinary(function string, op functions.BinaryOp) functions.BinaryOp {
+	return func(lhs ref.Val, rhs ref.Val) ref.Val {
+		start := time.Now()
+		ret := op(lhs, rhs)
+		ev.traceCall(function, []ref.Val{lhs, rhs}, ret, start)
+		return ret
+	}
+}
+
+func (ev *evaluation) tracedFunction(function string, op functions.FunctionOp) functions.FunctionOp {
+	return func(values ...ref.Val) ref.Val {
+		start := time.Now()
+		ret := op(values...)
+		ev.traceCall(function, values, ret, start)
+		return ret
+	}
+}
+
+/* Return the value to record in the trace, or the redacted marker if the value may be derived from a redacted function */
+func (ev *evaluation) traceValue(value interface{}) interface{} {
+	if ev.redacting {
+		return TRACEREDACTED
+	}
+	return traceValue(value)
+}
+
+/*
+Convert a value to a copy that can be marshalled as JSON, so that the trace is not changed by later statements modifying the
+value. Values that can't be marshalled are formatted as strings.
+*/
+func traceValue(value interface{}) interface{} {
+	if val, ok := value.(ref.Val); ok {
+		value = val.Value()
+	}
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.UseNumber()
+	var copied interface{}
+	if err = decoder.Decode(&copied); err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return copied
+}
+
+/* Save the trace of an evaluation */
+func (p *Processor) saveTrace(ev *evaluation) {
+	if ev.trace == nil {
+		return
+	}
+	p.traces.put(ev.trace)
+	klog.Infof("Trace for event %v from %v is available at %v%v on the trace listener", ev.trace.EventID, ev.trace.EventSource, endpoints.TRACEPATH, ev.trace.EventID)
+}
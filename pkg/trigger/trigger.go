@@ -18,16 +18,20 @@ package trigger
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/kabanero-io/kabanero-events/pkg/endpoints"
 	"github.com/kabanero-io/kabanero-events/pkg/messages"
 	"github.com/kabanero-io/kabanero-events/pkg/utils"
-	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"net/http"
 	//	"os"
 	"path/filepath"
 	"reflect"
+	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
 	"text/template"
@@ -37,6 +41,7 @@ import (
 	"github.com/google/cel-go/checker/decls"
 	"github.com/google/cel-go/common/types"
 	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
 	"github.com/google/cel-go/interpreter/functions"
 	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -45,73 +50,101 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog"
 )
 
-/* Trigger file syntax
+/* Trigger file syntax. The README describes each section and statement in detail.
 
-keyword: block, default, if, switch
+keywords: body, if, switch, default, foreach, return, stop, fail, try, let, unset
 
-Settings section:
-settrings :
+imports section: libraries of functions and constants, qualified by the name of the library
+imports:
+  - name: <ident>
+    path: <file or directory>
+  - name: <ident>
+    archive: <path or URL of a .tar.gz archive>
+    sha256: <checksum>
+
+settings section:
+settings:
   dryrun: bool
+  <setting>: <value>
+
+constants section: evaluated once, in order, when loading
+constants:
+  <ident>: <expr>
 
-eventTrigger section:
-EventTriggers:
+parameters section: available as params.<ident>, and overridden by the parametersConfigMap setting
+parameters:
+  - name: <ident>
+    type: int | double | bool | string | list | map | any
+    default: <expr>
+
+eventSchemas section: the JSON Schema used to type check the input of the triggers of an event source
+eventSchemas:
   - eventSource: <ident>
-   input: <variable>
-   body:
-    ...
-  - eventSources:
-    ...
+    schema: <name of a built-in schema, or a JSON Schema>
+  - eventSource: <ident>
+    schemaFile: <file>
 
-function section:
+eventTriggers section: eventSource, input, and body are required
+eventTriggers:
+  - eventSource: <ident>
+    input: <ident>
+    export: [ <ident>, ... ]
+    body:
+      <statements>
+
+functions section: a single input and output, or named parameters and outputs
 functions:
   - name: <ident>
     input: <ident>
     output: <ident>
-	body:
+    body:
+      <statements>
   - name: <ident>
-    ...
-
-
-And a body:
-body:
-  - <ident> : <value>
-  - <ident>: <expr>
-  - if: <condition>
-	<ident>: <expr>
-  - if: <condition>
-	body:
-	    - <ident>: <expr>
-	    - <ident>: <expr>
-  - switch:
-	- if: <condition>
-	  <ident>: <expr>
-	- if: <condition>
-	  body:
-        - <ident>: <expr>
-        - <ident>: <expr>
-	- default:
-	   - <ident>: <expr>
-    - <ident>: <expr>
-
-body:
-  - <ident> : <value>
-	<ident>: <value>
+    parameters:
+      - name: <ident>
+        type: int | double | bool | string | list | map | any
+        default: <expr>
+    outputs: [ <ident>, ... ]
+    body:
+      <statements>
+
+statements:
+  - <variable>: <expr>
+    <variable>: <expr>
+  - let:
+      <ident>: <expr>
+  - unset: <variable> | [ <variable>, ... ]
+  - body:
+      <statements>
   - if: <condition>
-    <ident>: <value>
+    <statement>
   - if: <condition>
-    <ident>: <value>
-    <ident>: <value>
+    body:
+      <statements>
   - switch:
-	- if: <condition>
-	  <ident>: <value>
-	- if: <condition>
-	  body:
-        - <ident>: <value>
-        - <ident>: <value>
-	- <ident>: <value>
+      - if: <condition>
+        <statement>
+      - default:
+          <statements>
+  - foreach: <list or map expr>
+    item: <ident>
+    index: <ident>
+    body:
+      <statements>
+    collect: <expr>
+    into: <variable>
+  - return: <expr>
+  - stop: <expr>
+  - fail: <expr>
+  - try:
+      <statements>
+    catch:
+      <statements>
+    error: <ident>
 */
 
 /* constants for parsing */
@@ -144,8 +177,42 @@ const (
 	EVENTTRIGGERS = "eventTriggers"
 	SYSTEMERROR   = "systemError"
 	FUNCTIONS     = "functions"
+	FOREACH       = "foreach"
+	ITEM          = "item"
+	INDEX         = "index"
+	COLLECT       = "collect"
+	INTO          = "into"
+	MAXITERATIONS = "maxIterations"
+	MAXCALLDEPTH  = "maxCallDepth"
+	MAXCOST       = "maxExpressionCost"
+	TIMEOUT       = "evaluationTimeout"
+	READABLEKINDS = "readableKinds"
+	PARAMETERS    = "parameters"
+	OUTPUTS       = "outputs"
+	TYPE          = "type"
+	TYPEANY       = "any"
+	RETURN        = "return"
+	STOP          = "stop"
+	FAIL          = "fail"
+	TRY           = "try"
+	CATCH         = "catch"
+	ERROR         = "error"
+	LET           = "let"
+	UNSET         = "unset"
+	EXPORT        = "export"
 )
 
+/* defaults for limits that can be changed in the settings */
+const (
+	defaultMaxIterations = 1000             // maximum number of iterations of a foreach statement
+	defaultMaxCallDepth  = 32               // maximum depth of nested calls of functions
+	defaultMaxCost       = 100000           // maximum number of steps to evaluate one expression
+	defaultTimeout       = 60 * time.Second // maximum time to process one event
+)
+
+/* maximum number of arguments of a function */
+const maxCallArguments = 8
+
 const (
 	// IfFlag is flag for If statement
 	IfFlag uint = 1 << iota
@@ -155,6 +222,20 @@ const (
 	DefaultFlag
 	// BodyFlag is flag for body statement
 	BodyFlag
+	// ForeachFlag is flag for foreach statement
+	ForeachFlag
+	// ReturnFlag is flag for return statement
+	ReturnFlag
+	// StopFlag is flag for stop statement
+	StopFlag
+	// FailFlag is flag for fail statement
+	FailFlag
+	// TryFlag is flag for try statement
+	TryFlag
+	// LetFlag is flag for let statement
+	LetFlag
+	// UnsetFlag is flag for unset statement
+	UnsetFlag
 )
 
 var keywords = map[string]uint{
@@ -162,6 +243,13 @@ var keywords = map[string]uint{
 	SWITCH:  SwitchFlag,
 	DEFAULT: DefaultFlag,
 	BODY:    BodyFlag,
+	FOREACH: ForeachFlag,
+	RETURN:  ReturnFlag,
+	STOP:    StopFlag,
+	FAIL:    FailFlag,
+	TRY:     TryFlag,
+	LET:     LetFlag,
+	UNSET:   UnsetFlag,
 }
 
 func isKeyword(variableName string) bool {
@@ -174,38 +262,100 @@ Return
 - number of the keywords in the map
 - flag for all the keywords found
 */
-func countKeywords(mymap map[interface{}]interface{}) (int, uint) {
+func countKeywords(object *Object) (int, uint) {
 	count := 0
 	flag := uint(0)
-	for keyObj := range mymap {
-		key, ok := keyObj.(string)
+	for _, entry := range object.Entries {
+		mask, ok := keywords[entry.Key]
 		if ok {
-			mask, ok := keywords[key]
-			if ok {
-				count++
-				flag |= mask
-			}
+			count++
+			flag |= mask
 		}
 	}
 	return count, flag
 }
 
-func (td *EventTriggerDefinition) isDryRun() bool {
+/* Return the first value found for a setting, or nil if not set */
+func (td *EventTriggerDefinition) getSetting(name string) interface{} {
 	for _, setting := range td.Setting {
-		if val := setting["dryrun"]; val != nil {
-			if b, ok := val.(bool); ok {
-				return b
-			}
+		if val, _ := setting.Get(name); val != nil {
+			return val
 		}
 	}
+	return nil
+}
+
+/* Return the value of a string setting, or the empty string if not set */
+func (td *EventTriggerDefinition) getSettingString(name string) string {
+	if str, ok := td.getSetting(name).(string); ok {
+		return str
+	}
+	return ""
+}
+
+/* Return the values of a setting that is a list of strings, or nil if not set */
+func (td *EventTriggerDefinition) getSettingStrings(name string) []string {
+	list, ok := td.getSetting(name).([]interface{})
+	if !ok {
+		return nil
+	}
+	ret := make([]string, 0, len(list))
+	for _, item := range list {
+		if str, ok := item.(string); ok {
+			ret = append(ret, str)
+		}
+	}
+	return ret
+}
+
+func (td *EventTriggerDefinition) getSettingInt(name string, defaultValue int) int {
+	if i, ok := td.getSetting(name).(int); ok {
+		return i
+	}
+	return defaultValue
+}
+
+/* Return the value of a duration setting, such as "30s", or the default if not set or invalid */
+func (td *EventTriggerDefinition) getSettingDuration(name string, defaultValue time.Duration) time.Duration {
+	str, ok := td.getSetting(name).(string)
+	if !ok {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(str)
+	if err != nil || duration <= 0 {
+		klog.Errorf("Invalid duration %v for setting %v. Using the default %v", str, name, defaultValue)
+		return defaultValue
+	}
+	return duration
+}
+
+func (td *EventTriggerDefinition) isDryRun() bool {
+	if b, ok := td.getSetting(DRYRUN).(bool); ok {
+		return b
+	}
 	return false
 }
 
 // EventTriggerDefinition represents an event trigger definition
 type EventTriggerDefinition struct {
-	Setting       []map[interface{}]interface{}            // all settings
-	EventTriggers map[string][]map[interface{}]interface{} // event source name to triggers
-	Functions     map[string]map[interface{}]interface{}   // function name to function body
+	Setting       []*Object               // all settings
+	EventTriggers map[string][]*Trigger   // event source name to triggers
+	Functions     map[string]*Function    // function name, qualified by the namespace of its library, to function
+	Constants     []*Constant             // constants in the order they are evaluated
+	Parameters    []*Parameter            // parameters of the trigger collection
+	Schemas       map[string]*EventSchema // event source name to schema of its messages
+}
+
+// NewEventTriggerDefinition creates an empty event trigger definition
+func NewEventTriggerDefinition() *EventTriggerDefinition {
+	return &EventTriggerDefinition{
+		Setting:       make([]*Object, 0),
+		EventTriggers: make(map[string][]*Trigger),
+		Functions:     make(map[string]*Function),
+		Constants:     make([]*Constant, 0),
+		Parameters:    make([]*Parameter, 0),
+		Schemas:       make(map[string]*EventSchema),
+	}
 }
 
 // Processor contains the event trigger definition and the file it was loaded from
@@ -214,16 +364,39 @@ type Processor struct {
 	env              *endpoints.Environment
 	triggerDir       string // directory where trigger file is stored
 	triggerFuncDecls cel.EnvOption
-	triggerFuncs     cel.ProgramOption
+	traces           *traceStore            // traces of recently traced events
+	constants        []*constantValue       // values of constants, in the order they are declared
+	paramDefaults    map[string]interface{} // default values of the parameters
+	params           map[string]interface{} // current values of the parameters
+	paramsMutex      sync.RWMutex
+	schemaProvider   *schemaTypeProvider     // object types created from event schemas, or nil if none
+	inputTypes       map[string]*exprpb.Type // event source name to type of its messages
+	clock            func() time.Time        // current time for the time functions, or nil for time.Now
+	gitHubAPIURL     string                  // URL of the GitHub API for the GitHub functions, or empty for the host of the repository
+	httpTransport    http.RoundTripper       // transport of the HTTP functions, or nil for the default transport
+	ctx              context.Context         // context of the work done in the background, canceled when the processor is stopped
+	stop             context.CancelFunc
+	eventKube        kubernetes.Interface // clients used while processing events, or nil to use those of the environment
+	eventDynamic     dynamic.Interface
 }
 
 // NewProcessor creates a new trigger processor.
 func NewProcessor(env *endpoints.Environment) *Processor {
+	ctx, stop := context.WithCancel(context.Background())
 	return &Processor{
-		env: env,
+		env:    env,
+		traces: newTraceStore(),
+		ctx:    ctx,
+		stop:   stop,
 	}
 }
 
+// Stop stops the work of the processor in the background: watching the parameters ConfigMap, and reporting the status of
+// PipelineRuns.
+func (p *Processor) Stop() {
+	p.stop()
+}
+
 // Initialize initializes a Processor with the specified trigger directory
 func (p *Processor) Initialize(dir string) error {
 	if klog.V(6) {
@@ -231,11 +404,7 @@ func (p *Processor) Initialize(dir string) error {
 		defer klog.Infof("Leaving Processor.initialize %v", dir)
 	}
 	var err error
-	p.triggerDef = &EventTriggerDefinition{
-		Setting:       make([]map[interface{}]interface{}, 0),
-		EventTriggers: make(map[string][]map[interface{}]interface{}, 0),
-		Functions:     make(map[string]map[interface{}]interface{}, 0),
-	}
+	p.triggerDef = NewEventTriggerDefinition()
 	files, err := findFiles(dir, []string{".yaml", ".yml"})
 	if err != nil {
 		return err
@@ -251,9 +420,22 @@ func (p *Processor) Initialize(dir string) error {
 	}
 	p.triggerDir = dir
 
+	if err = p.initSchemas(); err != nil {
+		return err
+	}
+
 	// Initialize CEL functions
 	p.initCELFuncs()
-	return nil
+	if err = p.evaluateParameters(); err != nil {
+		return err
+	}
+	if err = p.evaluateConstants(); err != nil {
+		return err
+	}
+	if err = p.initClients(); err != nil {
+		return err
+	}
+	return p.checkTriggers()
 }
 
 func (p *Processor) messageListener(provider messages.Provider, node *messages.EventNode) {
@@ -267,25 +449,44 @@ func (p *Processor) messageListener(provider messages.Provider, node *messages.E
 		if klog.V(6) {
 			klog.Infof("messageListener for %v received messages %v", node.Name, string(buf))
 		}
-		var messageMap map[string]interface{}
-		err = json.Unmarshal(buf, &messageMap)
-		if err != nil {
-			klog.Errorf("Unable to unmarshal message from node %v", node.Name)
-			continue
+		p.handleMessage(node, buf)
+	}
+}
+
+/* Process a message received by a listener. A panic is logged, and does not end the listener. */
+func (p *Processor) handleMessage(node *messages.EventNode, buf []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			klog.Errorf("Recovered from panic processing message from destination %v: %v\n%s", node.Name, r, debug.Stack())
 		}
-		_, err = p.ProcessMessage(messageMap, node.Name)
-		if err != nil {
-			klog.Errorf("Error processing message from destination %v. Message: %v, Error: %v", node.Name, messageMap, err)
-		} else if klog.V(6) {
-			klog.Infof("Finished processing message for  %v", node.Name)
+	}()
+	var messageMap map[string]interface{}
+	err := json.Unmarshal(buf, &messageMap)
+	if err != nil {
+		klog.Errorf("Unable to unmarshal message from node %v", node.Name)
+		return
+	}
+	_, result, err := p.ProcessMessage(messageMap, node.Name)
+	if result != nil && result.DryRun {
+		if err := p.publishDryRunResult(result); err != nil {
+			klog.Errorf("Error publishing dryrun result for destination %v: %v", node.Name, err)
 		}
 	}
+	if err != nil {
+		klog.Errorf("Error processing message from destination %v. Message: %v, Error: %v", node.Name, messageMap, err)
+	} else if klog.V(6) {
+		klog.Infof("Finished processing message for  %v", node.Name)
+	}
 }
 
 // StartListeners starts all event source listeners.
 func (p *Processor) StartListeners() error {
 	triggers := p.triggerDef.EventTriggers
 	for dest := range triggers {
+		if dest == SYSTEMERROR {
+			/* built-in event source for errors, not a destination */
+			continue
+		}
 		destNode := p.env.MessageService.GetNode(dest)
 		if destNode == nil {
 			return fmt.Errorf("unable to find an eventDestination with the name '%s' in trigger definitions. Verify that it has been defined", dest)
@@ -303,51 +504,10 @@ func (p *Processor) StartListeners() error {
 	return nil
 }
 
-/* Helper to fetch parameters of trigger object
-  input
-	 tringger: the object containing trigger definition
-	 output:
-	   eventSource: []string
-	   input: string
-	   body: []interface{}
-*/
-func (p *Processor) parseTrigger(trigger map[interface{}]interface{}) ([]string, string, []interface{}, error) {
-	eventSourceArray := make([]string, 0)
-	eventSourceObj, ok := trigger[EVENTSOURCE]
-	if !ok {
-		return eventSourceArray, "", nil, fmt.Errorf("trigger object %v does not contain eventSource", trigger)
-	}
-	eventSource, ok := eventSourceObj.(string)
-	if !ok {
-		return eventSourceArray, "", nil, fmt.Errorf("trigger object %v eventSource if not a string but a %T", eventSource, eventSourceObj)
-
-	}
-	eventSourceArray = append(eventSourceArray, eventSource)
-
-	inputObj, ok := trigger[INPUT]
-	if !ok {
-		return eventSourceArray, "", nil, fmt.Errorf("trigger object %v does not contain input", trigger)
-	}
-	input, ok := inputObj.(string)
-	if !ok {
-		return eventSourceArray, "", nil, fmt.Errorf("trigger object %v input not string but %T", eventSource, inputObj)
-
-	}
-
-	bodyObj, ok := trigger[BODY]
-	if !ok {
-		return eventSourceArray, "", nil, fmt.Errorf("trigger object %v does not contain body", trigger)
-	}
-	body, ok := bodyObj.([]interface{})
-	if !ok {
-		return eventSourceArray, "", nil, fmt.Errorf("trigger object %v body not []interface{} but %T", eventSource, bodyObj)
-
-	}
-	return eventSourceArray, input, body, nil
-}
-
 // ProcessMessage processes an event message.
-func (p *Processor) ProcessMessage(message map[string]interface{}, eventSource string) ([]map[string]interface{}, error) {
+// It returns the variables of each trigger evaluated, and the result of the evaluation.
+// When dryrun is set, the result records the resources and events that were not created or sent.
+func (p *Processor) ProcessMessage(message map[string]interface{}, eventSource string) ([]map[string]interface{}, *EvaluationResult, error) {
 	if klog.V(5) {
 		klog.Infof("Entering Processor.ProcessMessage. message: %v, eventSource: %v", message, eventSource)
 		defer klog.Infof("Leaving Processor.ProcessMessage")
@@ -360,294 +520,385 @@ func (p *Processor) ProcessMessage(message map[string]interface{}, eventSource s
 	if !ok {
 		err := fmt.Errorf("no trigger found for event source %v", eventSource)
 		klog.Error(err)
-		return nil, err
+		return nil, nil, err
 	}
 	if klog.V(5) {
 		klog.Infof("Found triggerArray")
 	}
 
+	ev := p.newEvaluation(message, eventSource)
+	defer p.saveTrace(ev)
+	cancel := ev.startDeadline(p.triggerDef.getSettingDuration(TIMEOUT, defaultTimeout))
+	defer cancel()
 	savedVariables := make([]map[string]interface{}, 0)
-	for _, trigger := range triggerArray {
+	for index, trigger := range triggerArray {
 		/* evaluate all trigger definitions for the event source*/
-		eventSources, inputVariable, bodyArray, err := p.parseTrigger(trigger)
+		ev.startTrigger(index, trigger)
+		env, variables, err := p.initializeCELEnv(message, trigger.Input, p.inputType(eventSource))
 		if err != nil {
-			klog.Error(err)
-			return nil, err
-		}
-		if klog.V(5) {
-			klog.Infof("ProcessMessage after parseTrigger: eventSources: %v", eventSources)
-		}
-
-		env, variables, err := p.initializeCELEnv(message, inputVariable)
-		if err != nil {
-			return nil, err
+			return nil, ev.result, err
 		}
+		ev.variables = variables
+		ev.varTypes = map[string]*exprpb.Type{trigger.Input: p.inputType(eventSource)}
 		if klog.V(5) {
 			klog.Infof("ProcessMessage after initializeCELEnv")
 		}
 
-		depth := 1
-		_, err = p.evalArrayObject(env, variables, bodyArray, depth)
+		err = p.evalTrigger(ev, env, variables, trigger)
+		if _, stopped := err.(*stopError); stopped {
+			/* stop ends the current trigger only. The reason is already logged and recorded. */
+			err = nil
+		}
 		if err != nil {
-			klog.Errorf("Error evaluating trigger %v: ERROR MESSAGE: %v", trigger, err)
-			return nil, err
+			klog.Errorf("Error evaluating trigger at %v: ERROR MESSAGE: %v", trigger.Position, err)
+			p.routeSystemError(ev, message, trigger, err)
+			return nil, ev.result, err
 		}
 		if klog.V(5) {
-			klog.Infof("ProcessMessage after evalArrayObject")
+			klog.Infof("ProcessMessage after evalStatements")
 		}
 		savedVariables = append(savedVariables, variables)
 	}
-	return savedVariables, nil
+	return savedVariables, ev.result, nil
 }
 
-/* Eval body  Array
+/* Evaluate the body of a trigger. A panic not attributed to a statement is returned as an error of the trigger. */
+func (p *Processor) evalTrigger(ev *evaluation, env cel.Env, variables map[string]interface{}, trigger *Trigger) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = newStatementError(trigger.Position, "", ev.recoverPanic(r))
+		}
+	}()
+	_, err = p.evalStatements(ev, env, variables, trigger.Body)
+	return err
+}
+
+/* Evaluate statements in order
    env: the CEL execution environment
    variables: variables gathered so far
-   bodyParam: body to evaluate
-   depth: depth of recursion
+   statements: statements to evaluate
    Return:
 	 cel.Env: updated execution environment
 	 error: any error
 */
-func (p *Processor) evalArrayObject(env cel.Env, variables map[string]interface{}, bodyArray []interface{}, depth int) (cel.Env, error) {
-
+func (p *Processor) evalStatements(ev *evaluation, env cel.Env, variables map[string]interface{}, statements []Statement) (cel.Env, error) {
 	var err error
-	for _, objectObj := range bodyArray {
-		object, ok := objectObj.(map[interface{}]interface{})
-		if !ok {
-			return env, fmt.Errorf("body object %v not map[interface{}]interface{}, but of type %T", objectObj, objectObj)
+	var locals *localScope // variables declared by let, removed at the end of the statements
+	for _, statement := range statements {
+		if err = ev.checkDeadline(); err != nil {
+			/* attribute the timeout to the statement that could not be evaluated in time */
+			err = newStatementError(statement.Pos(), "", err)
+			break
 		}
-		numKeywords, flags := countKeywords(object)
-		switch {
-		case (flags & IfFlag) != 0:
-			/* If statement, only allow If or If and BODY */
-			env, _, err := p.evalIfWithSyntaxCheck(env, variables, object, numKeywords, flags, depth)
-			if err != nil {
-				return env, err
-			}
-			continue
-		case (flags & SwitchFlag) != 0:
-			if numKeywords > 1 {
-				err = fmt.Errorf("switch contains more than one keyword: %v", object)
-				return env, err
-			}
-			if len(object) > 1 {
-				err = fmt.Errorf("switch also contains assignment: %v", object)
-				return env, err
-			}
-			env, err := p.evalSwitch(env, variables, object, numKeywords, flags, depth)
-			if err != nil {
-				return env, err
-			}
-			continue
-		case (flags & BodyFlag) != 0:
-			/* evaluate body */
-			if numKeywords > 1 {
-				err = fmt.Errorf("body contains more than one keyword: %v", object)
-				return env, err
-			}
-			if len(object) > 1 {
-				err = fmt.Errorf("body also contains assignment: %v", object)
-				return env, err
-			}
-			env, err := p.evalBody(env, variables, object, numKeywords, flags, depth)
-			if err != nil {
-				return env, err
-			}
-			continue
-		case (flags & DefaultFlag) != 0:
-			return env, fmt.Errorf("unexpected keyword default outside of a swtich: %v", objectObj)
-		default:
-			/* just plain assignment */
-			if len(object) > 1 {
-				err = fmt.Errorf("multiple assignments in one object: %v", object)
-				return env, err
-			}
-			env, err = p.evalAssignment(env, variables, object, numKeywords, flags, depth)
-			if err != nil {
-				return env, err
+		if stmt, ok := statement.(*Let); ok {
+			if locals == nil {
+				locals = newLocalScope()
 			}
+			env, err = p.evalLet(ev, env, variables, stmt, locals)
+		} else {
+			env, err = p.evalStatement(ev, env, variables, statement)
 		}
-	}
-	return env, nil
-}
-
-func (p *Processor) evalAssignment(env cel.Env, variables map[string]interface{}, object map[interface{}]interface{}, numKeywords int, flags uint, depth int) (cel.Env, error) {
-	if klog.V(6) {
-		klog.Infof("Entering evalAssignment object: %v", object)
-		defer klog.Infof("Leaving evalAssignment object")
-	}
-	var err error
-	for variableNameObj, valObj := range object {
-		if klog.V(6) {
-			klog.Infof("processing name: %v, type %T, object: %v, type %T", variableNameObj, variableNameObj, valObj, valObj)
-		}
-		variableName, ok := variableNameObj.(string)
-		if !ok {
-			return env, fmt.Errorf("varialbe %s is not  string, but of type %T", variableNameObj, variableNameObj)
-		}
-		if isKeyword(variableName) {
-			continue
-		}
-
-		/* Format value as string for CEL parsing */
-		var val string
-		switch valObj.(type) {
-		case int:
-			val = fmt.Sprintf("%v", valObj)
-		case int64:
-			val = fmt.Sprintf("%v", valObj)
-		case int32:
-			val = fmt.Sprintf("%v", valObj)
-		case float32:
-			val = fmt.Sprintf("%v", valObj)
-		case float64:
-			val = fmt.Sprintf("%v", valObj)
-		case bool:
-			val = fmt.Sprintf("%v", valObj)
-		case string:
-			val = valObj.(string)
-		default:
-			return env, fmt.Errorf("Value of variables not stored as  YAML primitive types or string when assgining %v to %v. Type of value is %T", variableName, valObj, valObj)
-		}
-		env, err = p.setOneVariable(env, variableName, val, variables)
 		if err != nil {
-			return env, err
+			break
 		}
 	}
-	return env, nil
+	if locals != nil {
+		var scopeErr error
+		env, scopeErr = p.endLocalScope(ev, variables, locals)
+		if err == nil {
+			err = scopeErr
+		}
+	}
+	return env, err
 }
 
-/*
- * Evaluate body
- */
-func (p *Processor) evalBody(env cel.Env, variables map[string]interface{}, object map[interface{}]interface{}, numKeyword int, flags uint, depth int) (cel.Env, error) {
-	/* check if recursive body exists */
-	nestedBodyObj := object[BODY]
-	nestedBody, ok := nestedBodyObj.([]interface{})
-	if ok {
-		return p.evalArrayObject(env, variables, nestedBody, depth)
+func (p *Processor) evalStatement(ev *evaluation, env cel.Env, variables map[string]interface{}, statement Statement) (newEnv cel.Env, err error) {
+	defer func() {
+		/* attribute a panic to the innermost statement, so that try statements and systemError triggers can handle it */
+		if r := recover(); r != nil {
+			newEnv, err = env, newStatementError(statement.Pos(), "", ev.recoverPanic(r))
+		}
+	}()
+	switch stmt := statement.(type) {
+	case *Assignment:
+		return p.evalAssignment(ev, env, variables, stmt)
+	case *If:
+		env, _, err := p.evalIf(ev, env, variables, stmt)
+		return env, err
+	case *Switch:
+		return p.evalSwitch(ev, env, variables, stmt)
+	case *Body:
+		return p.evalStatements(ev, env, variables, stmt.Body)
+	case *Foreach:
+		return p.evalForeach(ev, env, variables, stmt)
+	case *Exit:
+		return env, p.evalExit(ev, env, variables, stmt)
+	case *Try:
+		return p.evalTry(ev, env, variables, stmt)
+	case *Unset:
+		return p.evalUnset(ev, env, variables, stmt)
+	default:
+		return env, fmt.Errorf("%v: unsupported statement %T", statement.Pos(), statement)
 	}
-
-	err := fmt.Errorf("body %v contains nested body that is not []interface, but of type %T", nestedBodyObj, nestedBody)
-	return env, err
 }
 
-func (p *Processor) evalIfWithSyntaxCheck(env cel.Env, variables map[string]interface{}, object map[interface{}]interface{}, numKeywords int, flags uint, depth int) (cel.Env, bool, error) {
+func (p *Processor) evalAssignment(ev *evaluation, env cel.Env, variables map[string]interface{}, stmt *Assignment) (cel.Env, error) {
 	if klog.V(6) {
-		klog.Infof("evalIfWithSyntaxCheck : %v", object)
-	}
-	if numKeywords > 2 {
-		err := fmt.Errorf("body of if %v contains more than two keyword", object)
-		return env, false, err
+		klog.Infof("evalAssignment: %v = %v", stmt.Variable, stmt.Expression)
 	}
-	if numKeywords == 2 && (flags&BodyFlag) == 0 && (flags&SwitchFlag) == 0 {
-		/* second keyword is not body or switch */
-		err := fmt.Errorf("if object also contains keywords other than body or switch: %v", object)
-		return env, false, err
+	var err error
+	if stmt.Path != nil {
+		err = p.setIndexedVariable(ev, env, stmt, variables)
+	} else {
+		env, err = p.setOneVariable(ev, env, stmt.Variable, stmt.Expression, variables)
 	}
-	if numKeywords == 2 && len(object) > 2 {
-		err := fmt.Errorf("can not mix assignment with body object in if: %v", object)
-		return env, false, err
+	if err != nil {
+		return env, newStatementError(stmt.Position, stmt.Expression, err)
 	}
+	return env, nil
+}
 
-	conditionObj := object[IF]
-	condition, ok := conditionObj.(string)
-	if !ok {
-		return env, false, fmt.Errorf("condition of if object not a string: %v", object)
-	}
-	boolVal, err := p.evalCondition(env, condition, variables)
+/* Evaluate an if statement. Return true if the condition is met */
+func (p *Processor) evalIf(ev *evaluation, env cel.Env, variables map[string]interface{}, stmt *If) (cel.Env, bool, error) {
+	boolVal, err := p.evalCondition(ev, env, stmt.Condition, variables)
+	ev.traceBranch(traceIf, stmt.Condition, boolVal, err)
 	if err != nil {
-		return env, false, err
+		return env, false, newStatementError(stmt.Position, stmt.Condition, err)
 	}
 
 	if !boolVal {
 		/* condition not met */
 		if klog.V(6) {
-			klog.Infof("evalIfWithSyntaxCheck condition not met: %v", condition)
+			klog.Infof("evalIf condition not met: %v", stmt.Condition)
 		}
 		return env, false, nil
 	}
 
 	if klog.V(6) {
-		klog.Infof("evalIfWithSyntaxCheck condition met: %v", condition)
-	}
-	_, ok = object[BODY]
-	if ok {
-		/* if statement also contains body */
-		env, err = p.evalBody(env, variables, object, numKeywords, flags, depth)
-		return env, true, err
+		klog.Infof("evalIf condition met: %v", stmt.Condition)
 	}
+	env, err = p.evalStatements(ev, env, variables, stmt.Body)
+	return env, true, err
+}
 
-	_, ok = object[SWITCH]
-	if ok {
-		/* if statement also contains switch */
-		env, err = p.evalSwitch(env, variables, object, numKeywords, flags, depth)
-		return env, true, err
+func (p *Processor) evalSwitch(ev *evaluation, env cel.Env, variables map[string]interface{}, stmt *Switch) (cel.Env, error) {
+	ev.traceBranch(traceSwitch, "", true, nil)
+	for _, switchCase := range stmt.Cases {
+		env, conditionTrue, err := p.evalIf(ev, env, variables, switchCase)
+		if err != nil || conditionTrue {
+			return env, err
+		}
 	}
 
-	/* perform assignments */
-	env, err = p.evalAssignment(env, variables, object, numKeywords, flags, depth)
-	return env, true, err
+	/* evaluate defaults */
+	if stmt.Default != nil {
+		ev.traceBranch(traceDefault, "", true, nil)
+		return p.evalStatements(ev, env, variables, stmt.Default.Body)
+	}
+	return env, nil
 }
 
-func (p *Processor) evalSwitch(env cel.Env, variables map[string]interface{}, object map[interface{}]interface{}, numKeywords int, flags uint, depth int) (cel.Env, error) {
-	var err error
-	switchObj, ok := object[SWITCH]
-	if !ok {
-		return env, fmt.Errorf("Expecting switch statement: %v ", switchObj)
+/* Evaluate a foreach statement. The body is evaluated for each item in a nested scope, so variables set in the
+   body are not visible after the loop. Results are only passed out through the collect expression. */
+func (p *Processor) evalForeach(ev *evaluation, env cel.Env, variables map[string]interface{}, stmt *Foreach) (cel.Env, error) {
+	for _, name := range []string{stmt.Item, stmt.Index} {
+		if _, exists := variables[name]; name != "" && exists {
+			return env, fmt.Errorf("%v: foreach variable %v already defined", stmt.Position, name)
+		}
 	}
-	switchArray, ok := switchObj.([]interface{})
-	if !ok {
-		return env, fmt.Errorf("body of switch not array of objects: %v ", switchObj)
 
+	out, err := p.evalExpression(ev, env, FOREACH, stmt.Expression, variables)
+	if err != nil {
+		return env, newStatementError(stmt.Position, stmt.Expression, err)
 	}
-	var defaultArray []interface{} = nil
-	for _, arrayElementObj := range switchArray {
-		arrayElement, ok := arrayElementObj.(map[interface{}]interface{})
-		if !ok {
-			return env, fmt.Errorf("body component of switch not object: %v ", arrayElementObj)
-		}
-		switchCaseNumKeywords, switchCaseFlags := countKeywords(arrayElement)
-		_, ifOK := arrayElement[IF]
-		if ifOK {
-			/* evaluate the if statement */
-			env, conditionTrue, err := p.evalIfWithSyntaxCheck(env, variables, arrayElement, switchCaseNumKeywords, switchCaseFlags, depth)
-			if err != nil || conditionTrue {
-				return env, err
+	keys, items, err := getIterationItems(out)
+	if err != nil {
+		return env, newStatementError(stmt.Position, stmt.Expression, err)
+	}
+	maxIterations := p.triggerDef.getSettingInt(MAXITERATIONS, defaultMaxIterations)
+	if len(items) > maxIterations {
+		return env, fmt.Errorf("%v: foreach over %v items exceeds the maximum of %v iterations", stmt.Position, len(items), maxIterations)
+	}
+
+	/* only the variables the body may change are copied, so that other variables are shared with the nested scope */
+	assigned := make(map[string]bool)
+	assignedVariables(stmt.Body, assigned)
+	savedTypes := make(map[string]*exprpb.Type)
+	for name := range assigned {
+		if varType, ok := ev.varTypes[name]; ok {
+			savedTypes[name] = varType
+		}
+	}
+	defer func() {
+		for name := range assigned {
+			if varType, ok := savedTypes[name]; ok {
+				ev.varTypes[name] = varType
+			} else {
+				delete(ev.varTypes, name)
 			}
-			continue
 		}
-		defaultObj, defaultOK := arrayElement[DEFAULT]
-		if defaultOK {
-			if len(arrayElement) > 1 {
-				return env, fmt.Errorf("default object must be stand alone: %v", object)
+	}()
+
+	collected := make([]interface{}, 0, len(items))
+	for i, item := range items {
+		ev.addTraceEntry(&TraceEntry{Kind: traceForeach, Statement: stmt.Expression, Variable: stmt.Item, Value: ev.traceValue(item)})
+		scopeVariables := shallowCopy(variables)
+		for name := range assigned {
+			if value, ok := variables[name]; ok {
+				scopeVariables[name] = deepCopyValue(value)
 			}
-			if defaultArray != nil {
-				return env, fmt.Errorf("Only one default statement supported.  Extra default statement: %v", arrayElement)
+			if varType, ok := savedTypes[name]; ok {
+				ev.varTypes[name] = varType
 			}
-			defaultArray, ok = defaultObj.([]interface{})
-			if !ok {
-				return env, fmt.Errorf("content of default not []interface{}: %v, type: %T", defaultObj, defaultObj)
-
+		}
+		scopeEnv, err := createOneVariable(env, stmt.Item, stmt.Expression, item, scopeVariables)
+		if err != nil {
+			return env, newStatementError(stmt.Position, stmt.Expression, err)
+		}
+		if stmt.Index != "" {
+			scopeEnv, err = createOneVariable(scopeEnv, stmt.Index, stmt.Expression, keys[i], scopeVariables)
+			if err != nil {
+				return env, newStatementError(stmt.Position, stmt.Expression, err)
 			}
-			continue
 		}
-		/* Unsupported keyword, or assignment */
-		return env, fmt.Errorf("switch statement must contain if or default statements, but found: %v, type %T", arrayElement, arrayElement)
-
+		scopeEnv, err = p.evalStatements(ev, scopeEnv, scopeVariables, stmt.Body)
+		if err != nil {
+			return env, err
+		}
+		if stmt.Collect != "" {
+			value, err := p.evalExpression(ev, scopeEnv, stmt.Into, stmt.Collect, scopeVariables)
+			if err != nil {
+				return env, newStatementError(stmt.Position, stmt.Collect, err)
+			}
+			collected = append(collected, value.Value())
+		}
 	}
-	/* evaluate defaults */
 
-	if defaultArray != nil {
-		env, err = p.evalArrayObject(env, variables, defaultArray, depth)
+	if stmt.Into != "" {
+		list := types.NewDynamicList(types.DefaultTypeAdapter, collected)
+		ev.traceAssignment(stmt.Into, stmt.Collect, list, nil)
+		env, err = createOneVariable(env, stmt.Into, stmt.Collect, list, variables)
 		if err != nil {
-			return env, err
+			return env, newStatementError(stmt.Position, stmt.Expression, err)
 		}
 	}
 	return env, nil
 }
 
+/* Evaluate a return, stop, or fail statement. The returned error unwinds the enclosing statements up to the
+   function call for return, or to the trigger for stop and fail. */
+func (p *Processor) evalExit(ev *evaluation, env cel.Env, variables map[string]interface{}, stmt *Exit) error {
+	out, err := p.evalExpression(ev, env, stmt.Kind, stmt.Expression, variables)
+	if err != nil {
+		return newStatementError(stmt.Position, stmt.Expression, err)
+	}
+	ev.addTraceEntry(&TraceEntry{Kind: stmt.Kind, Statement: stmt.Expression, Value: ev.traceValue(out)})
+	if stmt.Kind == RETURN {
+		return &returnError{value: out}
+	}
+
+	reason, ok := out.Value().(string)
+	if !ok {
+		return fmt.Errorf("%v: reason for %v is not a string, but %v", stmt.Position, stmt.Kind, out.Type().TypeName())
+	}
+	ev.recordExit(stmt.Kind, reason, stmt.Position)
+	if stmt.Kind == STOP {
+		klog.Infof("Trigger %v for event %v stopped at %v: %v", ev.triggerIndex, ev.result.EventID, stmt.Position, reason)
+		return &stopError{reason: reason, position: stmt.Position}
+	}
+	return newStatementError(stmt.Position, stmt.Expression, errors.New(reason))
+}
+
+/* Evaluate a try statement. If the body fails, the catch body is evaluated with the error bound to a variable.
+   Ending a function or trigger with return or stop is not an error, and is not caught. */
+func (p *Processor) evalTry(ev *evaluation, env cel.Env, variables map[string]interface{}, stmt *Try) (cel.Env, error) {
+	numExits := len(ev.result.Exits)
+	tryEnv, err := p.evalStatements(ev, env, variables, stmt.Body)
+	switch err.(type) {
+	case nil, *stopError, *returnError:
+		return tryEnv, err
+	}
+	/* a fail that is caught does not end the trigger */
+	ev.result.Exits = ev.result.Exits[:numExits]
+
+	errorValue := errorToMap(err)
+	if ev.redacting {
+		/* the error may hold a value derived from a redacted function */
+		ev.addTraceEntry(&TraceEntry{Kind: traceCatch, Variable: stmt.ErrorVariable, Value: TRACEREDACTED, Error: TRACEREDACTED})
+	} else {
+		ev.addTraceEntry(&TraceEntry{Kind: traceCatch, Variable: stmt.ErrorVariable, Value: errorValue, Error: err.Error()})
+	}
+	if klog.V(4) {
+		klog.Infof("try statement at %v caught error: %v", stmt.Position, err)
+	}
+	out := types.NewDynamicMap(types.DefaultTypeAdapter, errorValue)
+	if _, exists := variables[stmt.ErrorVariable]; exists {
+		/* already declared by a previous catch */
+		variables[stmt.ErrorVariable] = errorValue
+	} else {
+		tryEnv, err = createOneVariable(tryEnv, stmt.ErrorVariable, "", out, variables)
+		if err != nil {
+			return env, newStatementError(stmt.Position, "", err)
+		}
+	}
+	return p.evalStatements(ev, tryEnv, variables, stmt.Catch)
+}
+
+/* Return the keys and items to iterate over. The keys of a list are the indexes. The keys of a map are sorted. */
+func getIterationItems(out ref.Val) ([]ref.Val, []ref.Val, error) {
+	keys := make([]ref.Val, 0)
+	items := make([]ref.Val, 0)
+	switch val := out.(type) {
+	case traits.Lister:
+		size, ok := val.Size().(types.Int)
+		if !ok {
+			return nil, nil, fmt.Errorf("unable to get size of list %v", out)
+		}
+		for i := types.Int(0); i < size; i++ {
+			keys = append(keys, i)
+			items = append(items, val.Get(i))
+		}
+	case traits.Mapper:
+		it := val.Iterator()
+		for it.HasNext() == types.True {
+			keys = append(keys, it.Next())
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprintf("%v", keys[i].Value()) < fmt.Sprintf("%v", keys[j].Value())
+		})
+		for _, key := range keys {
+			items = append(items, val.Get(key))
+		}
+	default:
+		return nil, nil, fmt.Errorf("foreach expression is not a list or map, but %v", out.Type().TypeName())
+	}
+	return keys, items, nil
+}
+
+/* Copy variables so that changes made in a nested scope, including to nested maps, are not visible outside */
+func deepCopy(originalMap map[string]interface{}) map[string]interface{} {
+	newMap := make(map[string]interface{})
+	for key, val := range originalMap {
+		newMap[key] = deepCopyValue(val)
+	}
+	return newMap
+}
+
+/* Copy a value, including the maps and lists within it */
+func deepCopyValue(val interface{}) interface{} {
+	switch container := val.(type) {
+	case map[string]interface{}:
+		return deepCopy(container)
+	case []interface{}:
+		newList := make([]interface{}, len(container))
+		for index, element := range container {
+			newList[index] = deepCopyValue(element)
+		}
+		return newList
+	}
+	return val
+}
+
 /* Shallow copy a map */
 func shallowCopy(originalMap map[string]interface{}) map[string]interface{} {
 	newMap := make(map[string]interface{})
@@ -663,6 +914,9 @@ func (p *Processor) initializeEmptyCELEnv() (cel.Env, error) {
 	/* initialize empty CEL environment with additional functions */
 	additionalFuncs := p.getAdditionalCELFuncDecls()
 	//	klog.Infof("Additional Func Decls: %v", additionalFuncs)
+	if p.schemaProvider != nil {
+		return cel.NewEnv(additionalFuncs, cel.CustomTypeProvider(p.schemaProvider))
+	}
 	return cel.NewEnv(additionalFuncs)
 }
 
@@ -670,8 +924,9 @@ func (p *Processor) initializeEmptyCELEnv() (cel.Env, error) {
 Return: cel.Env: the CEL environment
 	map[string]interface{}: variables used during substitution
 	error: any error encountered
+	inputType is the CEL type of the message, from the schema of the event source
 */
-func (p *Processor) initializeCELEnv(message map[string]interface{}, inputVariableName string) (cel.Env, map[string]interface{}, error) {
+func (p *Processor) initializeCELEnv(message map[string]interface{}, inputVariableName string, inputType *exprpb.Type) (cel.Env, map[string]interface{}, error) {
 	if klog.V(5) {
 		klog.Infof("entering initializeCELEnv")
 		defer klog.Infof("Leaving initializeCELEnv")
@@ -684,7 +939,7 @@ func (p *Processor) initializeCELEnv(message map[string]interface{}, inputVariab
 	}
 
 	variables := make(map[string]interface{})
-	ident := decls.NewIdent(inputVariableName, decls.NewMapType(decls.String, decls.Any), nil)
+	ident := decls.NewIdent(inputVariableName, inputType, nil)
 	env, err = env.Extend(cel.Declarations(ident))
 	if err != nil {
 		return nil, nil, err
@@ -692,10 +947,19 @@ func (p *Processor) initializeCELEnv(message map[string]interface{}, inputVariab
 	/* Add message as a new variable */
 	variables[inputVariableName] = message
 
+	env, err = p.addConstants(env, variables, map[string]bool{inputVariableName: true}, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	env, err = p.addParams(env, variables, map[string]bool{inputVariableName: true})
+	if err != nil {
+		return nil, nil, err
+	}
+
 	return env, variables, nil
 }
 
-func (p *Processor) setOneVariable(env cel.Env, name string, val string, variables map[string]interface{}) (cel.Env, error) {
+func (p *Processor) setOneVariable(ev *evaluation, env cel.Env, name string, val string, variables map[string]interface{}) (cel.Env, error) {
 	if name == "" {
 		/* name not set */
 		return env, nil
@@ -703,22 +967,10 @@ func (p *Processor) setOneVariable(env cel.Env, name string, val string, variabl
 
 	val = strings.Trim(val, " ")
 
-	parsed, issues := env.Parse(val)
-	if issues != nil && issues.Err() != nil {
-		return env, fmt.Errorf("parsing error setting variable %s to %s, error: %v", name, val, issues.Err())
-	}
-	checked, issues := env.Check(parsed)
-	if issues != nil && issues.Err() != nil {
-		return env, fmt.Errorf("CEL check error when setting variable %s to %s, error: %v, existing variables: %v", name, val, issues.Err(), variables)
-	}
-	prg, err := env.Program(checked, p.getAdditionalCELFuncs())
-	if err != nil {
-		return env, fmt.Errorf("CEL program error when setting variable %s to %s, error: %v", name, val, err)
-	}
-	// out, details, err := prg.Eval(variables)
-	out, _, err := prg.Eval(variables)
+	out, err := p.evalExpression(ev, env, name, val, variables)
+	ev.traceAssignment(name, val, out, err)
 	if err != nil {
-		return env, fmt.Errorf("CEL Eval error when setting variable %s to %s, error: %v", name, val, err)
+		return env, err
 	}
 
 	if klog.V(3) {
@@ -729,6 +981,23 @@ func (p *Processor) setOneVariable(env cel.Env, name string, val string, variabl
 	return env, err
 }
 
+/* Evaluate the expression of an assignment */
+func (p *Processor) evalExpression(ev *evaluation, env cel.Env, name string, val string, variables map[string]interface{}) (ref.Val, error) {
+	parsed, issues := env.Parse(val)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("parsing error setting variable %s to %s, error: %v", name, val, issues.Err())
+	}
+	checked, issues := env.Check(parsed)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("CEL check error when setting variable %s to %s, error: %v, existing variables: %v", name, val, issues.Err(), variables)
+	}
+	out, err := p.evalProgram(ev, env, checked, variables)
+	if err != nil {
+		return nil, fmt.Errorf("CEL Eval error when setting variable %s to %s, error: %v", name, val, err)
+	}
+	return out, nil
+}
+
 func createOneVariable(env cel.Env, entireName string, val string, out ref.Val, variables map[string]interface{}) (cel.Env, error) {
 	if klog.V(6) {
 		klog.Infof("Entering createOneVariables: setting %v to %v", entireName, val)
@@ -979,6 +1248,12 @@ func normalizeArrayInterface(val []interface{}) ([]interface{}, error) {
 
 // ReadTriggerDefinition reads the event trigger definition from a file.
 func ReadTriggerDefinition(fileName string, td *EventTriggerDefinition) error {
+	return readTriggerFile(fileName, td, "", nil)
+}
+
+/* Read a trigger file. namespace is the namespace of the functions and constants of an imported library, or
+   empty for a top level trigger file. importing is the list of libraries being imported, to detect cycles. */
+func readTriggerFile(fileName string, td *EventTriggerDefinition, namespace string, importing []string) error {
 	if klog.V(5) {
 		klog.Infof("enter readTriggerDefinitions %v", fileName)
 		defer klog.Infof("Leaving readTriggerDefinitions %v", fileName)
@@ -988,102 +1263,103 @@ func ReadTriggerDefinition(fileName string, td *EventTriggerDefinition) error {
 		return err
 	}
 
-	yamlMap := make(map[string]interface{})
-	err = yaml.Unmarshal(buf, yamlMap)
+	yamlObj, err := readObject(fileName, buf)
 	if err != nil {
-		return fmt.Errorf("unable to marshal %v. Error: %v", fileName, err)
+		return err
+	}
+
+	if namespace != "" {
+		/* libraries only provide functions and constants */
+		if err := checkKeys(yamlObj, "imported library", IMPORTS, CONSTANTS, FUNCTIONS); err != nil {
+			return err
+		}
 	}
 
 	/* gather args in the yaml */
-	settingsObj, ok := yamlMap[SETTINGS]
+	settingsObj, ok := yamlObj.Get(SETTINGS)
 	if ok {
 		if klog.V(5) {
 			klog.Infof("found settings %v", settingsObj)
 		}
-		settings, ok := settingsObj.(map[interface{}]interface{})
+		settings, ok := settingsObj.(*Object)
 		if ok {
 			td.Setting = append(td.Setting, settings)
 		}
 	}
 
-	eventTriggersObj, ok := yamlMap[EVENTTRIGGERS]
+	ps, err := newParser()
+	if err != nil {
+		return err
+	}
+
+	err = td.readImports(fileName, yamlObj, namespace, importing)
+	if err != nil {
+		return err
+	}
+
+	err = td.readConstants(yamlObj, namespace)
+	if err != nil {
+		return err
+	}
+
+	err = td.readParameters(yamlObj, ps)
+	if err != nil {
+		return err
+	}
+
+	err = td.readEventSchemas(fileName, yamlObj)
+	if err != nil {
+		return err
+	}
+
+	eventTriggersObj, ok := yamlObj.Get(EVENTTRIGGERS)
 	if ok {
 		if klog.V(5) {
 			klog.Infof("found EventTriggers %v %T", eventTriggersObj, eventTriggersObj)
 		}
 		eventTriggersArray, ok := eventTriggersObj.([]interface{})
-		if ok {
-			for _, triggerMapObj := range eventTriggersArray {
-				triggerMap, ok := triggerMapObj.(map[interface{}]interface{})
-				if !ok {
-					return fmt.Errorf("triggerMapObj %v not type map[interface{}]interface{}, but type %T", triggerMapObj, triggerMapObj)
-				}
-				eventSourceObj, ok := triggerMap[EVENTSOURCE]
-				if ok {
-					if klog.V(5) {
-						klog.Infof("Found eventSource %v", eventSourceObj)
-					}
-					eventSource, ok := eventSourceObj.(string)
-					if ok {
-						existingArray, ok := td.EventTriggers[eventSource]
-						if !ok {
-							existingArray = make([]map[interface{}]interface{}, 0)
-						}
-						td.EventTriggers[eventSource] = append(existingArray, triggerMap)
-					}
-				}
+		if !ok {
+			return fmt.Errorf("%v: event trigger %v not an array but type %T", yamlObj.GetEntry(EVENTTRIGGERS).Position, eventTriggersObj, eventTriggersObj)
+		}
+		for _, triggerMapObj := range eventTriggersArray {
+			triggerMap, ok := triggerMapObj.(*Object)
+			if !ok {
+				return fmt.Errorf("%v: triggerMapObj %v not an object, but type %T", yamlObj.GetEntry(EVENTTRIGGERS).Position, triggerMapObj, triggerMapObj)
 			}
-		} else {
-			return fmt.Errorf("event trigger %v not type map[interface{}]interface{] but type %F", eventTriggersObj, eventTriggersObj)
+			trigger, err := ps.parseTrigger(triggerMap)
+			if err != nil {
+				return err
+			}
+			if klog.V(5) {
+				klog.Infof("Found eventSource %v", trigger.EventSource)
+			}
+			td.EventTriggers[trigger.EventSource] = append(td.EventTriggers[trigger.EventSource], trigger)
 		}
 	}
 
 	/* read functions */
-	functionsObj, ok := yamlMap[FUNCTIONS]
+	functionsObj, ok := yamlObj.Get(FUNCTIONS)
 	if ok {
 		functionsArray, ok := functionsObj.([]interface{})
-		if ok {
-			for _, functionMapObj := range functionsArray {
-				functionMap, ok := functionMapObj.(map[interface{}]interface{})
-				if !ok {
-					if klog.V(5) {
-						klog.Infof("functionMap %v not of type map[interface{}]interface{}, but is type %T", functionMapObj, functionMapObj)
-					}
-					continue
-				}
-				nameObj, ok := functionMap[NAME]
-				if ok {
-					name, ok := nameObj.(string)
-					if ok {
-
-						inputObj, ok := functionMap[INPUT]
-						if !ok {
-							return fmt.Errorf("function %v does not contain input variable name: %v", name, functionMap)
-						}
-						_, ok = inputObj.(string)
-						if !ok {
-							return fmt.Errorf("function input %v not a string: %v", name, inputObj)
-						}
-
-						outputObj, ok := functionMap[OUTPUT]
-						if !ok {
-							return fmt.Errorf("function %v does not contain output variable name: %v", name, functionMap)
-						}
-						_, ok = outputObj.(string)
-						if !ok {
-							return fmt.Errorf("function output %v not a string %v", name, outputObj)
-						}
-
-						_, existing := td.Functions[name]
-						if existing {
-							return fmt.Errorf("error: event trigger function redcelared: %v", name)
-						}
-						td.Functions[name] = functionMap
-					}
-				}
+		if !ok {
+			return fmt.Errorf("%v: functionsArray %v not of type []interface{}, but type %T", yamlObj.GetEntry(FUNCTIONS).Position, functionsObj, functionsObj)
+		}
+		for _, functionMapObj := range functionsArray {
+			functionMap, ok := functionMapObj.(*Object)
+			if !ok {
+				return fmt.Errorf("%v: function %v not an object, but type %T", yamlObj.GetEntry(FUNCTIONS).Position, functionMapObj, functionMapObj)
 			}
-		} else {
-			return fmt.Errorf("functionsArray %v not of type []interface{}, but type %T", functionsArray, functionsArray)
+			function, err := ps.parseFunction(functionMap)
+			if err != nil {
+				return err
+			}
+			function.Namespace = namespace
+			name := qualifiedName(namespace, function.Name)
+			existing, ok := td.Functions[name]
+			if ok {
+				return fmt.Errorf("%v: error: event trigger function redcelared: %v, previously declared at %v", function.Position, name, existing.Position)
+			}
+			td.Functions[name] = function
 		}
 	}
 
@@ -1105,7 +1381,7 @@ func ReadTriggerDefinition(fileName string, td *EventTriggerDefinition) error {
 //	return nil, nil
 //}
 
-func (p *Processor) evalCondition(env cel.Env, when string, variables map[string]interface{}) (bool, error) {
+func (p *Processor) evalCondition(ev *evaluation, env cel.Env, when string, variables map[string]interface{}) (bool, error) {
 	if when == "" {
 		/* unconditional */
 		return true, nil
@@ -1118,12 +1394,7 @@ func (p *Processor) evalCondition(env cel.Env, when string, variables map[string
 	if issues != nil && issues.Err() != nil {
 		return false, fmt.Errorf("error parsing condition %s, error: %v", when, issues.Err())
 	}
-	prg, err := env.Program(checked, p.getAdditionalCELFuncs())
-	if err != nil {
-		return false, fmt.Errorf("error creating CEL program for condition %s, error: %v", when, err)
-	}
-	// out, details, err := prg.Eval(variables)
-	out, _, err := prg.Eval(variables)
+	out, err := p.evalProgram(ev, env, checked, variables)
 	if err != nil {
 		return false, fmt.Errorf("error evaluating condition %s, error: %v", when, err)
 	}
@@ -1199,27 +1470,46 @@ func SubstituteTemplate(templateStr string, variables interface{}) (string, erro
 	return buffer.String(), nil
 }
 
-/* Create resource. Assume it does not already exist */
-func (p *Processor) createResource(resourceStr string) error {
-	if klog.V(4) {
-		klog.Infof("Creating resource %s", resourceStr)
-	}
-
+/* Parse a rendered resource. Return the resource, its GVR, namespace, and name */
+func parseResource(resourceStr string) (*unstructured.Unstructured, schema.GroupVersionResource, string, string, error) {
 	/* Convert yaml to unstructured*/
 	resourceBytes, err := k8syaml.ToJSON([]byte(resourceStr))
 	if err != nil {
-		return fmt.Errorf("unable to convert yaml resource to JSON: %v", resourceStr)
+		return nil, schema.GroupVersionResource{}, "", "", fmt.Errorf("unable to convert yaml resource to JSON: %v", resourceStr)
 	}
 	var unstructuredObj = &unstructured.Unstructured{}
 	err = unstructuredObj.UnmarshalJSON(resourceBytes)
 	if err != nil {
 		klog.Errorf("Unable to convert JSON %s to unstructured", resourceStr)
-		return err
+		return nil, schema.GroupVersionResource{}, "", "", err
 	}
 
 	group, version, resource, namespace, name, err := getGroupVersionResourceNamespaceName(unstructuredObj)
+	if err != nil {
+		klog.Errorf("Unable to create resource /%s.  Error: %s", resourceStr, err)
+		return nil, schema.GroupVersionResource{}, "", "", fmt.Errorf("unable to get GVR for resource %s, error: %s", resourceStr, err)
+	}
 	if namespace == "" {
-		return fmt.Errorf("resource %s does not contain namepsace", resourceStr)
+		return nil, schema.GroupVersionResource{}, "", "", fmt.Errorf("resource %s does not contain namepsace", resourceStr)
+	}
+
+	gvr := schema.GroupVersionResource{
+		Group:    group,
+		Version:  version,
+		Resource: resource,
+	}
+	return unstructuredObj, gvr, namespace, name, nil
+}
+
+/* Create resource. Assume it does not already exist. Return the resource created */
+func (p *Processor) createResource(ctx context.Context, resourceStr string) (*unstructured.Unstructured, error) {
+	if klog.V(4) {
+		klog.Infof("Creating resource %s", resourceStr)
+	}
+
+	unstructuredObj, gvr, namespace, name, err := parseResource(resourceStr)
+	if err != nil {
+		return nil, err
 	}
 
 	/* add label kabanero.io/jobld = <jobid> */
@@ -1234,30 +1524,39 @@ func (p *Processor) createResource(resourceStr string) error {
 		klog.Infof("Resources before creating : %v", unstructuredObj)
 	}
 
-	gvr := schema.GroupVersionResource{
-		Group:    group,
-		Version:  version,
-		Resource: resource,
-	}
-
-	if err == nil {
-		var intfNoNS = p.env.DynamicClient.Resource(gvr)
-		var intf dynamic.ResourceInterface
-		intf = intfNoNS.Namespace(namespace)
+	var intfNoNS = p.dynamicClient().Resource(gvr)
+	var intf dynamic.ResourceInterface
+	intf = intfNoNS.Namespace(namespace)
 
-		_, err = intf.Create(unstructuredObj, metav1.CreateOptions{})
-		if err != nil {
-			klog.Errorf("Unable to create resource %s/%s error: %s", namespace, name, err)
-			return err
+	/*
+		the client does not take a context, so stop waiting for the create when the context is done. The request of the client
+		times out at the deadline of the context, if its configuration is known, but a create completing later is logged.
+	*/
+	created := make(chan error, 1)
+	go func() {
+		_, err := intf.Create(unstructuredObj, metav1.CreateOptions{})
+		created <- err
+		if ctx.Err() != nil {
+			if err == nil {
+				klog.Errorf("Create of resource %s/%s completed after the trigger timed out", namespace, name)
+			} else if klog.V(4) {
+				klog.Infof("Create of resource %s/%s failed after the trigger timed out: %v", namespace, name, err)
+			}
 		}
-	} else {
-		klog.Errorf("Unable to create resource /%s.  Error: %s", resourceStr, err)
-		return fmt.Errorf("unable to get GVR for resource %s, error: %s", resourceStr, err)
+	}()
+	select {
+	case err = <-created:
+	case <-ctx.Done():
+		err = fmt.Errorf("create of resource %s/%s did not complete in time, and may complete later: %v", namespace, name, ctx.Err())
+	}
+	if err != nil {
+		klog.Errorf("Unable to create resource %s/%s error: %s", namespace, name, err)
+		return nil, err
 	}
 	if klog.V(2) {
 		klog.Infof("Created resource %s/%s", namespace, name)
 	}
-	return nil
+	return unstructuredObj, nil
 }
 
 func setJobID(unstructuredObj *unstructured.Unstructured, jobid string) error {
@@ -1480,7 +1779,7 @@ func (p *Processor) kabaneroConfigCEL(values ...ref.Val) ref.Val {
        map["exists"] is true if the file exists, or false if it doesn't exist
 	   map["content"], if set, is the actual file content, of type map[string]interface{}
 */
-func (p *Processor) downloadYAMLCEL(webhookMessage ref.Val, fileNameVal ref.Val) ref.Val {
+func (p *Processor) downloadYAMLCEL(ev *evaluation, webhookMessage ref.Val, fileNameVal ref.Val) ref.Val {
 	klog.Infof("downloadYAMLCEL first param: %v, second param: %v", webhookMessage, fileNameVal)
 
 	if webhookMessage.Value() == nil {
@@ -1495,8 +1794,7 @@ func (p *Processor) downloadYAMLCEL(webhookMessage ref.Val, fileNameVal ref.Val)
 	if !ok {
 		return types.ValOrErr(webhookMessage, "Missing event parameter %v passed to downloadYAML.", webhookMessage)
 	}
-	var bodyMap map[string]interface{}
-	bodyMap, ok = bodyMapObj.(map[string]interface{})
+	_, ok = bodyMapObj.(map[string]interface{})
 	if !ok {
 		return types.ValOrErr(webhookMessage, "Event parameter %v passed to downloadYAML not map[string]interface{}. Instead, it is %T.", webhookMessage, bodyMapObj)
 	}
@@ -1505,8 +1803,7 @@ func (p *Processor) downloadYAMLCEL(webhookMessage ref.Val, fileNameVal ref.Val)
 	if !ok {
 		return types.ValOrErr(webhookMessage, "Missing header parameter %v passed to downloadYAML.", webhookMessage)
 	}
-	var headerMap map[string][]string
-	headerMap, err := convertToHeaderMap(headerMapObj)
+	_, err := convertToHeaderMap(headerMapObj)
 	if err != nil {
 		return types.ValOrErr(webhookMessage, "Header %v passed to downloadYAML can not be converted to map[string][]string. Instead, it is %T. Conversion error: %v", headerMapObj, headerMapObj, err)
 	}
@@ -1514,13 +1811,24 @@ func (p *Processor) downloadYAMLCEL(webhookMessage ref.Val, fileNameVal ref.Val)
 	if fileNameVal.Value() == nil {
 		return types.ValOrErr(fileNameVal, "unexpected null second parameter passed to function downloadYAML.")
 	}
-	fileName, ok := fileNameVal.Value().(string)
+	_, ok = fileNameVal.Value().(string)
 	if !ok {
 		return types.ValOrErr(fileNameVal, "unexpected type '%v' passed as first parameter to function downloadYAML. It should be string", fileNameVal.Type())
 	}
 
+	/* the file is read through the cache of the event, so other functions reading the same file do not read it again */
 	var ret = make(map[string]interface{})
-	fileContent, exists, err := utils.DownloadYAML(p.env.KubeClient, headerMap, bodyMap, fileName)
+	var fileContent map[string]interface{}
+	exists := false
+	content, errVal := p.getRepositoryContent(ev, "downloadYAML", webhookMessage, fileNameVal)
+	if errVal != nil {
+		err = fmt.Errorf("%v", errVal.Value())
+	} else if content.isDir {
+		err = fmt.Errorf("unable to download %v: not a file", fileNameVal)
+	} else if content.exists {
+		exists = true
+		fileContent, err = utils.YAMLToMap([]byte(content.content))
+	}
 	ret["exists"] = exists
 	if err != nil {
 		ret["error"] = fmt.Sprintf("%v", err)
@@ -1538,82 +1846,53 @@ func (p *Processor) downloadYAMLCEL(webhookMessage ref.Val, fileNameVal ref.Val)
 
 /* implementation of call for CEL.
    function string: name of function to call
-   param map[string]interface{}: param to pass to function
-   Return interface{} : result
+   params: arguments to pass to function
+   Return ref.Val : the output of the function, or a map of the outputs if the function declares multiple outputs
 */
-func (p *Processor) callCEL(functionVal ref.Val, param ref.Val) ref.Val {
+func (p *Processor) callCEL(ev *evaluation, functionVal ref.Val, params ...ref.Val) ref.Val {
 	if klog.V(6) {
-		klog.Infof("callCEL first param: %v, second param: %v", functionVal, param)
+		klog.Infof("callCEL function: %v, params: %v", functionVal, params)
 	}
 
-	if param.Value() == nil {
-		klog.Infof("callCEL param is nil")
-		return types.ValOrErr(param, "unexpected null second parameter passed to function call.")
-	}
-	/*
-		paramMap, ok := param.Value().(map[string]interface{})
-		if !ok {
-			klog.Infof("callCEL param is not map[string]interface{}")
-			return types.ValOrErr(param, "unexpected type '%v' passed as second parameter to function call. It should be map[string]interface{}", param.Type())
-		}
-	*/
-
 	if functionVal.Value() == nil {
 		klog.Infof("callCEL function is nil")
 		return types.ValOrErr(functionVal, "unexpected null first parameter passed to function call.")
 	}
-	if klog.V(6) {
-		klog.Infof("callCEL first param type: %v, second param type: %v", functionVal.Type(), param.Type())
-	}
-
 	function, ok := functionVal.Value().(string)
 	if !ok {
 		klog.Infof("callCEL function is not string")
 		return types.ValOrErr(functionVal, "unexpected type '%v' passed as first parameter to function call. It should be string", functionVal.Type())
 	}
 
-	if klog.V(6) {
-		klog.Infof("callCEL: getting functions:  %v ", p.triggerDef.Functions)
+	/* functions of a library may call each other without the namespace of the library */
+	functionDecl, ok := p.triggerDef.Functions[qualifiedName(ev.namespace, function)]
+	if !ok {
+		functionDecl, ok = p.triggerDef.Functions[function]
 	}
-
-	functionDecl, ok := p.triggerDef.Functions[function]
 	if !ok {
 		klog.Errorf("callCEL function %v not found", function)
 		return types.ValOrErr(functionVal, "function %v not found", function)
 	}
-
-	inputObj, ok := functionDecl[INPUT]
-	if !ok {
-		klog.Infof("callCEL function %v input variable name not found", function)
-		return types.ValOrErr(functionVal, "function %v does not contain input variable", functionDecl)
-	}
-	input, ok := inputObj.(string)
-	if !ok {
-		klog.Infof("callCEL function %v input variable name not string", function)
-		return types.ValOrErr(functionVal, "input variable of function %v not a string: %v", function, inputObj)
-	}
-	outputObj, ok := functionDecl[OUTPUT]
-	if !ok {
-		klog.Infof("callCEL function %v output variable name not found", function)
-		return types.ValOrErr(functionVal, "function %v does not contain output variable", functionDecl)
-	}
-	output, ok := outputObj.(string)
-	if !ok {
-		klog.Infof("callCEL function %v output variable name not string", function)
-		return types.ValOrErr(functionVal, "output variable of function %v not a string: %v", functionDecl, outputObj)
+	if len(params) > len(functionDecl.Parameters) {
+		return types.ValOrErr(functionVal, "function %v accepts at most %v arguments, but is called with %v", function, len(functionDecl.Parameters), len(params))
 	}
 
-	bodyArrayObj, ok := functionDecl[BODY]
-	if !ok {
-		klog.Infof("callCEL function %v function body not found", function)
-		return types.ValOrErr(functionVal, "function %v does not a have a body", functionDecl)
-	}
-	bodyArray, ok := bodyArrayObj.([]interface{})
-	if !ok {
-		klog.Infof("callCEL function %v function body not []interface{}", function)
-		return types.ValOrErr(functionVal, "body of function %v not not []interface{}", functionDecl)
+	maxCallDepth := p.triggerDef.getSettingInt(MAXCALLDEPTH, defaultMaxCallDepth)
+	if ev.callDepth >= maxCallDepth {
+		return types.ValOrErr(functionVal, "maximum call depth of %v exceeded when calling function %v", maxCallDepth, function)
 	}
+	ev.callDepth++
+	savedNamespace := ev.namespace
+	savedVarTypes := ev.varTypes
+	ev.namespace = functionDecl.Namespace
+	ev.varTypes = make(map[string]*exprpb.Type)
+	defer func() {
+		ev.callDepth--
+		ev.namespace = savedNamespace
+		ev.varTypes = savedVarTypes
+	}()
 
+	/* functions are evaluated in their own scope, containing only their parameters, constants, and params */
 	variables := make(map[string]interface{})
 	env, err := p.initializeEmptyCELEnv()
 	if err != nil {
@@ -1621,29 +1900,75 @@ func (p *Processor) callCEL(functionVal ref.Val, param ref.Val) ref.Val {
 		return types.ValOrErr(functionVal, "callCEL Unable to initialize CEL environment. Error: %v ", err)
 	}
 
-	env, err = createOneVariable(env, input, "", param, variables)
+	/* parameters hide constants and params with the same name, and the constants of the library hide params */
+	paramNames := make(map[string]bool)
+	for _, param := range functionDecl.Parameters {
+		paramNames[param.Name] = true
+	}
+	env, err = p.addConstants(env, variables, paramNames, functionDecl.Namespace)
 	if err != nil {
-		klog.Infof("callCEL function %v unable to create input variable %v for %v", function, input, param)
-		return types.ValOrErr(param, "callCEL Unable to initialize CEL environment. Error: %v ", err)
+		return types.ValOrErr(functionVal, "callCEL Unable to initialize CEL environment. Error: %v ", err)
 	}
-
-	depth := 1
-	_, err = p.evalArrayObject(env, variables, bodyArray, depth)
+	for name := range p.libraryConstants(functionDecl.Namespace) {
+		paramNames[name] = true
+	}
+	env, err = p.addParams(env, variables, paramNames)
 	if err != nil {
-		klog.Infof("callCEL error: %v", err)
-		return types.ValOrErr(param, "callCEL error evaluating function body. Error: %v ", err)
+		return types.ValOrErr(functionVal, "callCEL Unable to initialize CEL environment. Error: %v ", err)
 	}
-	outValueObj, ok := variables[output]
-	if !ok {
-		klog.Errorf("callCEL error calling function %v: output variable %v not set", function, output)
-		return types.ValOrErr(types.NewDynamicMap(types.DefaultTypeAdapter, variables), "error calling function %v: output variable %v not set by function", function, output)
+
+	for index, param := range functionDecl.Parameters {
+		var value ref.Val
+		if index < len(params) {
+			value = params[index]
+		} else if param.Default != "" {
+			/* defaults may refer to parameters declared before them */
+			value, err = p.evalExpression(ev, env, param.Name, param.Default, variables)
+			if err != nil {
+				return types.ValOrErr(functionVal, "%v: error evaluating default of parameter %v of function %v: %v", param.Position, param.Name, function, err)
+			}
+		} else {
+			return types.ValOrErr(functionVal, "function %v requires argument %v, but is called with %v arguments", function, param.Name, len(params))
+		}
+		if value.Value() == nil {
+			return types.ValOrErr(functionVal, "unexpected null argument %v passed to function %v", param.Name, function)
+		}
+		if param.Type != "" && param.Type != TYPEANY && param.Type != value.Type().TypeName() {
+			return types.ValOrErr(functionVal, "argument %v of function %v should be of type %v, but is of type %v", param.Name, function, param.Type, value.Type().TypeName())
+		}
+		env, err = createOneVariable(env, param.Name, "", value, variables)
+		if err != nil {
+			klog.Infof("callCEL function %v unable to create input variable %v for %v", function, param.Name, value)
+			return types.ValOrErr(value, "callCEL Unable to initialize CEL environment. Error: %v ", err)
+		}
 	}
 
-	ret, err := convertToRefVal(outValueObj)
+	_, err = p.evalStatements(ev, env, variables, functionDecl.Body)
+	if ret, ok := err.(*returnError); ok {
+		return ret.value
+	}
 	if err != nil {
-		return types.ValOrErr(types.NewDynamicMap(types.DefaultTypeAdapter, variables), "while calling function %v: return value  %v has unsupproted type %T", function, outValueObj, outValueObj)
+		klog.Infof("callCEL error: %v", err)
+		return types.ValOrErr(functionVal, "callCEL error evaluating function body. Error: %v ", err)
 	}
-	return ret
+
+	outputs := make(map[string]interface{})
+	for _, output := range functionDecl.Outputs {
+		outValueObj, ok := variables[output]
+		if !ok {
+			klog.Errorf("callCEL error calling function %v: output variable %v not set", function, output)
+			return types.ValOrErr(types.NewDynamicMap(types.DefaultTypeAdapter, variables), "error calling function %v: output variable %v not set by function", function, output)
+		}
+		if !functionDecl.OutputMap {
+			ret, err := convertToRefVal(outValueObj)
+			if err != nil {
+				return types.ValOrErr(types.NewDynamicMap(types.DefaultTypeAdapter, variables), "while calling function %v: return value  %v has unsupproted type %T", function, outValueObj, outValueObj)
+			}
+			return ret
+		}
+		outputs[output] = outValueObj
+	}
+	return types.NewDynamicMap(types.DefaultTypeAdapter, outputs)
 }
 
 /* Convert a value to ref.Val
@@ -1684,7 +2009,7 @@ func convertToRefVal(outValueObj interface{}) (ref.Val, error) {
    variable Any: variable to pass to go template
    Return string : empty if OK, otherwise, error message
 */
-func (p *Processor) applyResourcesCEL(dir ref.Val, variables ref.Val) ref.Val {
+func (p *Processor) applyResourcesCEL(ev *evaluation, dir ref.Val, variables ref.Val) ref.Val {
 	klog.Infof("applyResourcesCEL first param: %v, second param: %v", dir, variables)
 
 	if variables.Value() == nil {
@@ -1704,7 +2029,7 @@ func (p *Processor) applyResourcesCEL(dir ref.Val, variables ref.Val) ref.Val {
 		return types.ValOrErr(dir, "unexpected type '%v' passed as first parameter to function applyResources. It should be string", dir.Type())
 	}
 
-	err := p.applyResourcesHelper(p.triggerDir, dirStr, variables.Value(), p.triggerDef.isDryRun())
+	err := p.applyResourcesHelper(ev, p.triggerDir, dirStr, variables.Value(), p.triggerDef.isDryRun())
 	var ret ref.Val
 	if err != nil {
 		ret = types.String(fmt.Sprintf("applyResources error  applying template %v", err))
@@ -1730,10 +2055,12 @@ func findFiles(resourceDir string, suffixes []string) ([]string, error) {
 			ret = append(ret, fileName)
 		}
 	}
+	/* files are processed in the order of their names, regardless of suffix */
+	sort.Strings(ret)
 	return ret, nil
 }
 
-func (p *Processor) applyResourcesHelper(triggerDirectory string, directory string, variables interface{}, dryrun bool) error {
+func (p *Processor) applyResourcesHelper(ev *evaluation, triggerDirectory string, directory string, variables interface{}, dryrun bool) error {
 
 	resourceDir, err := utils.MergePathWithErrorCheck(triggerDirectory, directory)
 	if err != nil {
@@ -1755,17 +2082,33 @@ func (p *Processor) applyResourcesHelper(triggerDirectory string, directory stri
 	}
 
 	if dryrun {
-		klog.Infof("applyResources: dryrun is set. Resources not created")
+		/* record what would have been created */
+		for _, resource := range substituted {
+			unstructuredObj, gvr, namespace, name, err := parseResource(resource)
+			if err != nil {
+				return err
+			}
+			ev.result.Resources = append(ev.result.Resources, &DryRunResource{
+				Group:     gvr.Group,
+				Version:   gvr.Version,
+				Resource:  gvr.Resource,
+				Namespace: namespace,
+				Name:      name,
+				Manifest:  unstructuredObj.Object,
+			})
+		}
+		klog.Infof("applyResources: dryrun is set. %v resources not created", len(substituted))
 	} else {
 		/* Apply the files */
 		for _, resource := range substituted {
 			if klog.V(5) {
 				klog.Infof("applying resource: %s", resource)
 			}
-			err = p.createResource(resource)
+			created, err := p.createResource(ev.ctx, resource)
 			if err != nil {
 				return err
 			}
+			p.reportPipelineRun(ev, created)
 		}
 	}
 	return nil
@@ -1823,7 +2166,7 @@ func convertToHeaderMap(value interface{}) (map[string][]string, error) {
    Return string : empty if OK, otherwise, error message
 */
 // func sendEventCEL(destination ref.Val, message ref.Val, context ref.Val) ref.Val
-func (p *Processor) sendEventCEL(refs ...ref.Val) ref.Val {
+func (p *Processor) sendEventCEL(ev *evaluation, refs ...ref.Val) ref.Val {
 	if refs == nil {
 		klog.Error("sendEventCEL input is nil")
 		return types.ValOrErr(nil, "unexpected nil input to sendEventCEL.")
@@ -1876,11 +2219,17 @@ func (p *Processor) sendEventCEL(refs ...ref.Val) ref.Val {
 	}
 
 	if p.triggerDef.isDryRun() {
+		headerMap, _ := header.(map[string][]string)
+		ev.result.Events = append(ev.result.Events, &DryRunEvent{
+			Destination: dest,
+			Payload:     value,
+			Header:      headerMap,
+		})
 		klog.Infof("sendEvent: dry run is set. Event was not sent to destination '%s'", dest)
 		return types.String("")
 	}
 
-	err = p.env.MessageService.Send(dest, buf, header)
+	err = p.env.MessageService.SendContext(ev.ctx, dest, buf, header)
 	if err != nil {
 		return types.ValOrErr(nil, "sendEventCEL: unable to send event: %v", err)
 	}
@@ -1901,7 +2250,7 @@ func (p *Processor) sendEventCEL(refs ...ref.Val) ref.Val {
         newHader : ' filter(header, " key.startsWith(\"X-Github\") || key.startsWith(\"github\")) '
 		newArray: ' filter(oldArray, " value < 10 " )
 */
-func (p *Processor) filterCEL(message ref.Val, expression ref.Val) ref.Val {
+func (p *Processor) filterCEL(ev *evaluation, message ref.Val, expression ref.Val) ref.Val {
 	if klog.V(6) {
 		klog.Infof("filterCEL first param: %v, second param: %v", message, expression)
 	}
@@ -1941,7 +2290,7 @@ func (p *Processor) filterCEL(message ref.Val, expression ref.Val) ref.Val {
 		for iter := messageValue.MapRange(); iter.Next(); {
 			key := iter.Key()
 			value := iter.Value()
-			err = p.filterMapEntry(retMap, key, value, expressionStr)
+			err = p.filterMapEntry(ev, retMap, key, value, expressionStr)
 			if err != nil {
 				klog.Errorf("In built-in function filter evaluation of condition %v resulted in error  %v", expressionStr, err)
 				return types.ValOrErr(message, "In built-in function filter evaluation of condition %v resulted in error  %v", expressionStr, err)
@@ -1953,7 +2302,7 @@ func (p *Processor) filterCEL(message ref.Val, expression ref.Val) ref.Val {
 		retArray := reflect.MakeSlice(reflect.SliceOf(messageType.Elem()), 0, 0)
 		for i := 0; i < messageValue.Len(); i++ {
 			value := messageValue.Index(i)
-			retArray, err = p.filterArraySlice(retArray, value, expressionStr)
+			retArray, err = p.filterArraySlice(ev, retArray, value, expressionStr)
 			if err != nil {
 				klog.Errorf("In built-in function filter evaluation of condition %v resulted in error  %v", expressionStr, err)
 				return types.ValOrErr(message, "In built-in function filter evaluation of condition %v resulted in error  %v", expressionStr, err)
@@ -1981,7 +2330,7 @@ func (p *Processor) filterCEL(message ref.Val, expression ref.Val) ref.Val {
  Then then evaluates the expression in the context of the key/value variables.
  If the expression is true, it inserts the ke/value into the map
 */
-func (p *Processor) filterMapEntry(mapVal reflect.Value, key, value reflect.Value, expression string) error {
+func (p *Processor) filterMapEntry(ev *evaluation, mapVal reflect.Value, key, value reflect.Value, expression string) error {
 	env, err := p.initializeEmptyCELEnv()
 	if err != nil {
 		return err
@@ -2003,7 +2352,7 @@ func (p *Processor) filterMapEntry(mapVal reflect.Value, key, value reflect.Valu
 	if err != nil {
 		return err
 	}
-	condition, err := p.evalCondition(env, expression, variables)
+	condition, err := p.evalCondition(ev, env, expression, variables)
 	if err != nil {
 		return err
 	}
@@ -2026,7 +2375,7 @@ var nilValue = reflect.ValueOf(nil)
  Then then evaluates the expression in the context of the value variables.
  If the expression is true, it inserts the value into the slice
 */
-func (p *Processor) filterArraySlice(slice reflect.Value, value reflect.Value, expression string) (reflect.Value, error) {
+func (p *Processor) filterArraySlice(ev *evaluation, slice reflect.Value, value reflect.Value, expression string) (reflect.Value, error) {
 	env, err := p.initializeEmptyCELEnv()
 	if err != nil {
 		return nilValue, err
@@ -2041,7 +2390,7 @@ func (p *Processor) filterArraySlice(slice reflect.Value, value reflect.Value, e
 	if err != nil {
 		return nilValue, err
 	}
-	condition, err := p.evalCondition(env, expression, variables)
+	condition, err := p.evalCondition(ev, env, expression, variables)
 	if err != nil {
 		return nilValue, err
 	}
@@ -2057,27 +2406,34 @@ func (p *Processor) getAdditionalCELFuncDecls() cel.EnvOption {
 	return p.triggerFuncDecls
 }
 
-/* Get implementations of additional overloaded CEL functions */
-func (p *Processor) getAdditionalCELFuncs() cel.ProgramOption {
-	return p.triggerFuncs
+/* Declare overloads of call for up to maxCallArguments arguments after the function name */
+func callFuncDecl() *exprpb.Decl {
+	overloads := make([]*exprpb.Decl_FunctionDecl_Overload, 0, maxCallArguments+1)
+	argTypes := []*exprpb.Type{decls.String}
+	overloads = append(overloads, decls.NewOverload("call_string", argTypes, decls.Any))
+	for i := 1; i <= maxCallArguments; i++ {
+		argTypes = append(argTypes, decls.Any)
+		overloads = append(overloads, decls.NewOverload(fmt.Sprintf("call_string_any%d", i), argTypes, decls.Any))
+	}
+	return decls.NewFunction("call", overloads...)
 }
 
 func (p *Processor) initCELFuncs() {
 	p.triggerFuncDecls = cel.Declarations(
 		decls.NewFunction("filter",
 			decls.NewOverload("filter_any_string", []*exprpb.Type{decls.Any, decls.String}, decls.Any)),
-		decls.NewFunction("call",
-			decls.NewOverload("call_string_any_string", []*exprpb.Type{decls.String, decls.Any}, decls.Any)),
+		callFuncDecl(),
 		decls.NewFunction("sendEvent",
 			decls.NewOverload("sendEvent_string_any_any", []*exprpb.Type{decls.String, decls.Any, decls.Any}, decls.String)),
 		decls.NewFunction("applyResources",
+			decls.NewOverload("applyResources_string", []*exprpb.Type{decls.String}, decls.String),
 			decls.NewOverload("applyResources_string_any", []*exprpb.Type{decls.String, decls.Any}, decls.String)),
 		decls.NewFunction("kabaneroConfig",
 			decls.NewOverload("kabaneroConfig", []*exprpb.Type{}, decls.NewMapType(decls.String, decls.Any))),
 		decls.NewFunction("jobID",
 			decls.NewOverload("jobID", []*exprpb.Type{}, decls.String)),
 		decls.NewFunction("downloadYAML",
-			decls.NewOverload("downloadYAML_map_string", []*exprpb.Type{decls.NewMapType(decls.String, decls.Any), decls.String}, decls.NewMapType(decls.String, decls.Any))),
+			decls.NewOverload("downloadYAML_any_string", []*exprpb.Type{decls.Any, decls.String}, decls.NewMapType(decls.String, decls.Any))),
 		decls.NewFunction("toDomainName",
 			decls.NewOverload("toDomainName_string", []*exprpb.Type{decls.String}, decls.String)),
 		decls.NewFunction("toLabel",
@@ -2085,41 +2441,301 @@ func (p *Processor) initCELFuncs() {
 		decls.NewFunction("split",
 			decls.NewOverload("split_string", []*exprpb.Type{decls.String, decls.String}, decls.NewListType(decls.String))),
 		decls.NewFunction("substring",
-			decls.NewOverload("substring", []*exprpb.Type{decls.String, decls.Int}, decls.String)))
+			decls.NewOverload("substring", []*exprpb.Type{decls.String, decls.Int}, decls.String)),
+		decls.NewFunction("regexFind",
+			decls.NewOverload("regexFind_string_string", []*exprpb.Type{decls.String, decls.String}, decls.String)),
+		decls.NewFunction("regexFindAll",
+			decls.NewOverload("regexFindAll_string_string", []*exprpb.Type{decls.String, decls.String}, decls.NewListType(decls.String))),
+		decls.NewFunction("regexCaptures",
+			decls.NewOverload("regexCaptures_string_string", []*exprpb.Type{decls.String, decls.String}, decls.NewMapType(decls.String, decls.String))),
+		decls.NewFunction("regexReplace",
+			decls.NewOverload("regexReplace_string_string_string", []*exprpb.Type{decls.String, decls.String, decls.String}, decls.String)),
+		decls.NewFunction("semver",
+			decls.NewOverload("semver_string", []*exprpb.Type{decls.String}, decls.NewMapType(decls.String, decls.Dyn))),
+		decls.NewFunction("semverCompare",
+			decls.NewOverload("semverCompare_string_string", []*exprpb.Type{decls.String, decls.String}, decls.Int)),
+		decls.NewFunction("semverSatisfies",
+			decls.NewOverload("semverSatisfies_string_string", []*exprpb.Type{decls.String, decls.String}, decls.Bool)),
+		decls.NewFunction("semverBump",
+			decls.NewOverload("semverBump_string_string", []*exprpb.Type{decls.String, decls.String}, decls.String)),
+		decls.NewFunction("now",
+			decls.NewOverload("now", []*exprpb.Type{}, decls.String)),
+		decls.NewFunction("formatTime",
+			decls.NewOverload("formatTime_string_string", []*exprpb.Type{decls.String, decls.String}, decls.String),
+			decls.NewOverload("formatTime_string_string_string", []*exprpb.Type{decls.String, decls.String, decls.String}, decls.String)),
+		decls.NewFunction("parseTime",
+			decls.NewOverload("parseTime_string_string", []*exprpb.Type{decls.String, decls.String}, decls.String),
+			decls.NewOverload("parseTime_string_string_string", []*exprpb.Type{decls.String, decls.String, decls.String}, decls.String)),
+		decls.NewFunction("timezone",
+			decls.NewOverload("timezone_string_string", []*exprpb.Type{decls.String, decls.String}, decls.String)),
+		decls.NewFunction("inTimeWindow",
+			decls.NewOverload("inTimeWindow_string_string", []*exprpb.Type{decls.String, decls.String}, decls.Bool),
+			decls.NewOverload("inTimeWindow_string_string_string", []*exprpb.Type{decls.String, decls.String, decls.String}, decls.Bool)),
+		decls.NewFunction("base64Encode",
+			decls.NewOverload("base64Encode_string", []*exprpb.Type{decls.String}, decls.String)),
+		decls.NewFunction("base64Decode",
+			decls.NewOverload("base64Decode_string", []*exprpb.Type{decls.String}, decls.String)),
+		decls.NewFunction("sha256",
+			decls.NewOverload("sha256_string", []*exprpb.Type{decls.String}, decls.String)),
+		decls.NewFunction("toJSON",
+			decls.NewOverload("toJSON_dyn", []*exprpb.Type{decls.Dyn}, decls.String)),
+		decls.NewFunction("fromJSON",
+			decls.NewOverload("fromJSON_string", []*exprpb.Type{decls.String}, decls.Dyn)),
+		decls.NewFunction("toYAML",
+			decls.NewOverload("toYAML_dyn", []*exprpb.Type{decls.Dyn}, decls.String)),
+		decls.NewFunction("fromYAML",
+			decls.NewOverload("fromYAML_string", []*exprpb.Type{decls.String}, decls.Dyn)),
+		decls.NewFunction("urlEncode",
+			decls.NewOverload("urlEncode_string", []*exprpb.Type{decls.String}, decls.String)),
+		decls.NewFunction("lower",
+			decls.NewOverload("lower_string", []*exprpb.Type{decls.String}, decls.String)),
+		decls.NewFunction("upper",
+			decls.NewOverload("upper_string", []*exprpb.Type{decls.String}, decls.String)),
+		decls.NewFunction("trim",
+			decls.NewOverload("trim_string", []*exprpb.Type{decls.String}, decls.String),
+			decls.NewOverload("trim_string_string", []*exprpb.Type{decls.String, decls.String}, decls.String)),
+		decls.NewFunction("join",
+			decls.NewOverload("join_list_string", []*exprpb.Type{decls.NewListType(decls.Dyn), decls.String}, decls.String)),
+		decls.NewFunction("truncateHash",
+			decls.NewOverload("truncateHash_string_int", []*exprpb.Type{decls.String, decls.Int}, decls.String)),
+		decls.NewFunction("query",
+			decls.NewOverload("query_dyn_string", []*exprpb.Type{decls.Dyn, decls.String}, decls.Dyn),
+			decls.NewOverload("query_dyn_string_dyn", []*exprpb.Type{decls.Dyn, decls.String, decls.Dyn}, decls.Dyn)),
+		decls.NewFunction("getResource",
+			decls.NewOverload("getResource_string_string_string_string", []*exprpb.Type{decls.String, decls.String, decls.String, decls.String}, decls.Dyn)),
+		decls.NewFunction("listResources",
+			decls.NewOverload("listResources_string_string_string_string", []*exprpb.Type{decls.String, decls.String, decls.String, decls.String}, decls.NewListType(decls.Dyn))),
+		decls.NewFunction("httpGet",
+			decls.NewOverload("httpGet_string_map", []*exprpb.Type{decls.String, decls.NewMapType(decls.String, decls.String)}, decls.NewMapType(decls.String, decls.Dyn))),
+		decls.NewFunction("httpPost",
+			decls.NewOverload("httpPost_string_dyn_map", []*exprpb.Type{decls.String, decls.Dyn, decls.NewMapType(decls.String, decls.String)}, decls.NewMapType(decls.String, decls.Dyn))),
+		decls.NewFunction("downloadFile",
+			decls.NewOverload("downloadFile_dyn_string", []*exprpb.Type{decls.Dyn, decls.String}, decls.String)),
+		decls.NewFunction("downloadJSON",
+			decls.NewOverload("downloadJSON_dyn_string", []*exprpb.Type{decls.Dyn, decls.String}, decls.Dyn)),
+		decls.NewFunction("listDirectory",
+			decls.NewOverload("listDirectory_dyn_string", []*exprpb.Type{decls.Dyn, decls.String}, decls.NewListType(decls.NewMapType(decls.String, decls.Dyn)))),
+		decls.NewFunction("fileExists",
+			decls.NewOverload("fileExists_dyn_string", []*exprpb.Type{decls.Dyn, decls.String}, decls.Bool)),
+		decls.NewFunction("changedFiles",
+			decls.NewOverload("changedFiles_dyn", []*exprpb.Type{decls.Dyn}, decls.NewListType(decls.String))),
+		decls.NewFunction("pathMatches",
+			decls.NewOverload("pathMatches_list_string", []*exprpb.Type{decls.NewListType(decls.Dyn), decls.String}, decls.Bool),
+			decls.NewOverload("pathMatches_list_list", []*exprpb.Type{decls.NewListType(decls.Dyn), decls.NewListType(decls.Dyn)}, decls.Bool)),
+		decls.NewFunction("scmEvent",
+			decls.NewOverload("scmEvent_dyn", []*exprpb.Type{decls.Dyn}, decls.NewMapType(decls.String, decls.Dyn))),
+		decls.NewFunction("setCommitStatus",
+			decls.NewOverload("setCommitStatus_dyn_string_string_string_string", []*exprpb.Type{decls.Dyn, decls.String, decls.String, decls.String, decls.String}, decls.Bool)))
+}
 
-	p.triggerFuncs = cel.Functions(
+/* Get implementations of additional overloaded CEL functions, bound to the evaluation of one event */
+func (p *Processor) getAdditionalCELFuncs(ev *evaluation) []*functions.Overload {
+	return []*functions.Overload{
 		&functions.Overload{
 			Operator: "filter",
-			Binary:   p.filterCEL},
+			Binary: ev.tracedBinary("filter", func(message ref.Val, expression ref.Val) ref.Val {
+				return p.filterCEL(ev, message, expression)
+			})},
 		&functions.Overload{
 			Operator: "call",
-			Binary:   p.callCEL},
+			Unary: ev.tracedUnary("call", func(functionVal ref.Val) ref.Val {
+				return p.callCEL(ev, functionVal)
+			}),
+			Binary: ev.tracedBinary("call", func(functionVal ref.Val, param ref.Val) ref.Val {
+				return p.callCEL(ev, functionVal, param)
+			}),
+			Function: ev.tracedFunction("call", func(values ...ref.Val) ref.Val {
+				return p.callCEL(ev, values[0], values[1:]...)
+			})},
 		&functions.Overload{
 			Operator: "sendEvent",
-			Function: p.sendEventCEL},
+			Function: ev.tracedFunction("sendEvent", func(refs ...ref.Val) ref.Val {
+				return p.sendEventCEL(ev, refs...)
+			})},
 		&functions.Overload{
 			Operator: "applyResources",
-			Binary:   p.applyResourcesCEL},
+			Unary: ev.tracedUnary("applyResources", func(dir ref.Val) ref.Val {
+				return p.applyResourcesCEL(ev, dir, types.NewDynamicMap(types.DefaultTypeAdapter, ev.templateVariables()))
+			}),
+			Binary: ev.tracedBinary("applyResources", func(dir ref.Val, variables ref.Val) ref.Val {
+				return p.applyResourcesCEL(ev, dir, variables)
+			})},
 		&functions.Overload{
 			Operator: "kabaneroConfig",
-			Function: p.kabaneroConfigCEL},
+			Function: ev.tracedFunction("kabaneroConfig", p.kabaneroConfigCEL)},
 		&functions.Overload{
 			Operator: "jobID",
-			Function: p.jobIDCEL},
+			Function: ev.tracedFunction("jobID", p.jobIDCEL)},
 		&functions.Overload{
 			Operator: "downloadYAML",
-			Binary:   p.downloadYAMLCEL},
+			Binary: ev.tracedBinary("downloadYAML", func(message ref.Val, fileName ref.Val) ref.Val {
+				return p.downloadYAMLCEL(ev, message, fileName)
+			})},
 		&functions.Overload{
 			Operator: "toDomainName",
-			Unary:    p.toDomainNameCEL},
+			Unary: ev.tracedUnary("toDomainName", p.toDomainNameCEL)},
 		&functions.Overload{
 			Operator: "toLabel",
-			Unary:    p.toLabelCEL},
+			Unary: ev.tracedUnary("toLabel", p.toLabelCEL)},
 		&functions.Overload{
 			Operator: "split",
-			Binary:   p.splitCEL},
+			Binary: ev.tracedBinary("split", p.splitCEL)},
 		&functions.Overload{
 			Operator: "substring",
-			Binary:   p.substringCEL},
-	)
+			Binary: ev.tracedBinary("substring", p.substringCEL)},
+		&functions.Overload{
+			Operator: "regexFind",
+			Binary: ev.tracedBinary("regexFind", p.regexFindCEL)},
+		&functions.Overload{
+			Operator: "regexFindAll",
+			Binary: ev.tracedBinary("regexFindAll", p.regexFindAllCEL)},
+		&functions.Overload{
+			Operator: "regexCaptures",
+			Binary: ev.tracedBinary("regexCaptures", p.regexCapturesCEL)},
+		&functions.Overload{
+			Operator: "regexReplace",
+			Function: ev.tracedFunction("regexReplace", p.regexReplaceCEL)},
+		&functions.Overload{
+			Operator: "semver",
+			Unary: ev.tracedUnary("semver", p.semverCEL)},
+		&functions.Overload{
+			Operator: "semverCompare",
+			Binary: ev.tracedBinary("semverCompare", p.semverCompareCEL)},
+		&functions.Overload{
+			Operator: "semverSatisfies",
+			Binary: ev.tracedBinary("semverSatisfies", p.semverSatisfiesCEL)},
+		&functions.Overload{
+			Operator: "semverBump",
+			Binary: ev.tracedBinary("semverBump", p.semverBumpCEL)},
+		&functions.Overload{
+			Operator: "now",
+			Function: ev.tracedFunction("now", p.nowCEL)},
+		&functions.Overload{
+			Operator: "formatTime",
+			Binary: ev.tracedBinary("formatTime", func(t ref.Val, layout ref.Val) ref.Val {
+				return p.formatTimeCEL(t, layout)
+			}),
+			Function: ev.tracedFunction("formatTime", p.formatTimeCEL)},
+		&functions.Overload{
+			Operator: "parseTime",
+			Binary: ev.tracedBinary("parseTime", func(str ref.Val, layout ref.Val) ref.Val {
+				return p.parseTimeCEL(str, layout)
+			}),
+			Function: ev.tracedFunction("parseTime", p.parseTimeCEL)},
+		&functions.Overload{
+			Operator: "timezone",
+			Binary: ev.tracedBinary("timezone", p.timezoneCEL)},
+		&functions.Overload{
+			Operator: "inTimeWindow",
+			Binary: ev.tracedBinary("inTimeWindow", func(window ref.Val, location ref.Val) ref.Val {
+				return p.inTimeWindowCEL(window, location)
+			}),
+			Function: ev.tracedFunction("inTimeWindow", p.inTimeWindowCEL)},
+		&functions.Overload{
+			Operator: "base64Encode",
+			Unary: ev.tracedUnary("base64Encode", p.base64EncodeCEL)},
+		&functions.Overload{
+			Operator: "base64Decode",
+			Unary: ev.tracedUnary("base64Decode", p.base64DecodeCEL)},
+		&functions.Overload{
+			Operator: "sha256",
+			Unary: ev.tracedUnary("sha256", p.sha256CEL)},
+		&functions.Overload{
+			Operator: "toJSON",
+			Unary: ev.tracedUnary("toJSON", p.toJSONCEL)},
+		&functions.Overload{
+			Operator: "fromJSON",
+			Unary: ev.tracedUnary("fromJSON", p.fromJSONCEL)},
+		&functions.Overload{
+			Operator: "toYAML",
+			Unary: ev.tracedUnary("toYAML", p.toYAMLCEL)},
+		&functions.Overload{
+			Operator: "fromYAML",
+			Unary: ev.tracedUnary("fromYAML", p.fromYAMLCEL)},
+		&functions.Overload{
+			Operator: "urlEncode",
+			Unary: ev.tracedUnary("urlEncode", p.urlEncodeCEL)},
+		&functions.Overload{
+			Operator: "lower",
+			Unary: ev.tracedUnary("lower", p.lowerCEL)},
+		&functions.Overload{
+			Operator: "upper",
+			Unary: ev.tracedUnary("upper", p.upperCEL)},
+		&functions.Overload{
+			Operator: "trim",
+			Unary: ev.tracedUnary("trim", func(str ref.Val) ref.Val {
+				return p.trimCEL(str)
+			}),
+			Binary: ev.tracedBinary("trim", func(str ref.Val, cutset ref.Val) ref.Val {
+				return p.trimCEL(str, cutset)
+			})},
+		&functions.Overload{
+			Operator: "join",
+			Binary: ev.tracedBinary("join", p.joinCEL)},
+		&functions.Overload{
+			Operator: "truncateHash",
+			Binary: ev.tracedBinary("truncateHash", p.truncateHashCEL)},
+		&functions.Overload{
+			Operator: "query",
+			Binary: ev.tracedBinary("query", func(obj ref.Val, expression ref.Val) ref.Val {
+				return p.queryCEL(obj, expression)
+			}),
+			Function: ev.tracedFunction("query", p.queryCEL)},
+		&functions.Overload{
+			Operator: "getResource",
+			Function: ev.tracedFunction("getResource", func(values ...ref.Val) ref.Val {
+				return p.getResourceCEL(ev, values...)
+			})},
+		&functions.Overload{
+			Operator: "listResources",
+			Function: ev.tracedFunction("listResources", func(values ...ref.Val) ref.Val {
+				return p.listResourcesCEL(ev, values...)
+			})},
+		&functions.Overload{
+			Operator: "httpGet",
+			Binary: ev.tracedBinary("httpGet", func(url ref.Val, headers ref.Val) ref.Val {
+				return p.httpGetCEL(ev, url, headers)
+			})},
+		&functions.Overload{
+			Operator: "httpPost",
+			Function: ev.tracedFunction("httpPost", func(values ...ref.Val) ref.Val {
+				return p.httpPostCEL(ev, values...)
+			})},
+		&functions.Overload{
+			Operator: "downloadFile",
+			Binary: ev.tracedBinary("downloadFile", func(message ref.Val, path ref.Val) ref.Val {
+				return p.downloadFileCEL(ev, message, path)
+			})},
+		&functions.Overload{
+			Operator: "downloadJSON",
+			Binary: ev.tracedBinary("downloadJSON", func(message ref.Val, path ref.Val) ref.Val {
+				return p.downloadJSONCEL(ev, message, path)
+			})},
+		&functions.Overload{
+			Operator: "listDirectory",
+			Binary: ev.tracedBinary("listDirectory", func(message ref.Val, path ref.Val) ref.Val {
+				return p.listDirectoryCEL(ev, message, path)
+			})},
+		&functions.Overload{
+			Operator: "fileExists",
+			Binary: ev.tracedBinary("fileExists", func(message ref.Val, path ref.Val) ref.Val {
+				return p.fileExistsCEL(ev, message, path)
+			})},
+		&functions.Overload{
+			Operator: "changedFiles",
+			Unary: ev.tracedUnary("changedFiles", func(message ref.Val) ref.Val {
+				return p.changedFilesCEL(ev, message)
+			})},
+		&functions.Overload{
+			Operator: "pathMatches",
+			Binary: ev.tracedBinary("pathMatches", p.pathMatchesCEL)},
+		&functions.Overload{
+			Operator: "scmEvent",
+			Unary: ev.tracedUnary("scmEvent", p.scmEventCEL)},
+		&functions.Overload{
+			Operator: "setCommitStatus",
+			Function: ev.tracedFunction("setCommitStatus", func(values ...ref.Val) ref.Val {
+				return p.setCommitStatusCEL(ev, values...)
+			})},
+	}
 }
@@ -17,29 +17,72 @@ limitations under the License.
 package trigger_test
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"github.com/kabanero-io/kabanero-events/pkg/endpoints"
 	"github.com/kabanero-io/kabanero-events/pkg/trigger"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"text/template"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
 	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
 )
 
 const (
-	TRIGGER0 = "../../test_data/trigger0"
-	TRIGGER1 = "../../test_data/trigger1"
-	TRIGGER2 = "../../test_data/trigger2"
-	TRIGGER3 = "../../test_data/trigger3"
-	TRIGGER4 = "../../test_data/trigger4"
-	TRIGGER5 = "../../test_data/trigger5"
-	TRIGGER6 = "../../test_data/trigger6"
-	TRIGGER7 = "../../test_data/trigger7"
-	TRIGGER8 = "../../test_data/trigger8"
-	TRIGGER9 = "../../test_data/trigger9"
+	TRIGGER0  = "../../test_data/trigger0"
+	TRIGGER1  = "../../test_data/trigger1"
+	TRIGGER2  = "../../test_data/trigger2"
+	TRIGGER3  = "../../test_data/trigger3"
+	TRIGGER4  = "../../test_data/trigger4"
+	TRIGGER5  = "../../test_data/trigger5"
+	TRIGGER6  = "../../test_data/trigger6"
+	TRIGGER7  = "../../test_data/trigger7"
+	TRIGGER8  = "../../test_data/trigger8"
+	TRIGGER9  = "../../test_data/trigger9"
+	TRIGGER10 = "../../test_data/trigger10"
+	TRIGGER11 = "../../test_data/trigger11"
+	TRIGGER12 = "../../test_data/trigger12"
+	TRIGGER13 = "../../test_data/trigger13"
+	TRIGGER14 = "../../test_data/trigger14"
+	TRIGGER15 = "../../test_data/trigger15"
+	TRIGGER16 = "../../test_data/trigger16"
+	TRIGGER17 = "../../test_data/trigger17"
+	TRIGGER18 = "../../test_data/trigger18"
+	TRIGGER19 = "../../test_data/trigger19"
+	TRIGGER20 = "../../test_data/trigger20"
+	TRIGGER21 = "../../test_data/trigger21"
+	TRIGGER22 = "../../test_data/trigger22"
+	TRIGGER23 = "../../test_data/trigger23"
+	TRIGGER24 = "../../test_data/trigger24"
+	TRIGGER25 = "../../test_data/trigger25"
+	TRIGGER26 = "../../test_data/trigger26"
+	TRIGGER27 = "../../test_data/trigger27"
+	TRIGGER28 = "../../test_data/trigger28"
+	TRIGGER29 = "../../test_data/trigger29"
+	TRIGGER30 = "../../test_data/trigger30"
+	TRIGGER31 = "../../test_data/trigger31"
+	TRIGGER32 = "../../test_data/trigger32"
+	TRIGGER33 = "../../test_data/trigger33"
+	TRIGGER34 = "../../test_data/trigger34"
 )
 
 /* Simaple test to read data structure*/
@@ -65,11 +108,7 @@ func TestReadYaml(t *testing.T) {
 	}
 
 	for _, fileName := range files {
-		td := &trigger.EventTriggerDefinition{
-			Setting:       []map[interface{}]interface{}{},
-			EventTriggers: make(map[string][]map[interface{}]interface{}),
-			Functions:     make(map[string]map[interface{}]interface{}),
-		}
+		td := trigger.NewEventTriggerDefinition()
 		err := trigger.ReadTriggerDefinition(fileName, td)
 		if err != nil {
 			t.Fatal(err)
@@ -274,7 +313,7 @@ func TestApplyTemplateWithCELVariables(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	variables, err := tp.ProcessMessage(event, "default")
+	variables, _, err := tp.ProcessMessage(event, "default")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -328,7 +367,7 @@ func testOneConditional(tp *trigger.Processor, srcEvent []byte, expectedDirector
 		return err
 	}
 
-	variablesArray, err := tp.ProcessMessage(event, "default")
+	variablesArray, _, err := tp.ProcessMessage(event, "default")
 	if err != nil {
 		return err
 	}
@@ -423,7 +462,7 @@ func TestAdditionalfunctions(t *testing.T) {
 	}
 
 	event := make(map[string]interface{})
-	variablesArray, err := tp.ProcessMessage(event, "default")
+	variablesArray, _, err := tp.ProcessMessage(event, "default")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -586,7 +625,7 @@ func TestDoubleNestedCELVariables(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	variablesArray, err := tp.ProcessMessage(event, "default")
+	variablesArray, _, err := tp.ProcessMessage(event, "default")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -635,7 +674,7 @@ func testOneSwitch(tp *trigger.Processor, srcEvent []byte, expectedDirectory str
 		return err
 	}
 
-	variablesArray, err := tp.ProcessMessage(event, "default")
+	variablesArray, _, err := tp.ProcessMessage(event, "default")
 	if err != nil {
 		return err
 	}
@@ -666,7 +705,7 @@ func TestCall(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	variables, err := triggerProc.ProcessMessage(event, "default")
+	variables, _, err := triggerProc.ProcessMessage(event, "default")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -710,7 +749,7 @@ func TestRecursiveCall(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	variables, err := triggerProc.ProcessMessage(event, "default")
+	variables, _, err := triggerProc.ProcessMessage(event, "default")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -738,7 +777,7 @@ func TestFilter(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	variables, err := triggerProc.ProcessMessage(event, "default")
+	variables, _, err := triggerProc.ProcessMessage(event, "default")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -748,3 +787,1675 @@ func TestFilter(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestDryRun(t *testing.T) {
+	srcEvent := []byte(`{"header": {"X-Test": ["value1"]}, "body": {"name": "project1"}}`)
+	var event map[string]interface{}
+	err := json.Unmarshal(srcEvent, &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	triggerProc := trigger.NewProcessor(nil)
+	err = triggerProc.Initialize(TRIGGER10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, result, err := triggerProc.ProcessMessage(event, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.DryRun {
+		t.Fatalf("dryrun not set in result: %v", result)
+	}
+	if len(result.Resources) != 1 {
+		t.Fatalf("expecting 1 resource in dryrun result but got %v", len(result.Resources))
+	}
+	resource := result.Resources[0]
+	if resource.Group != "tekton.dev" || resource.Version != "v1alpha1" || resource.Resource != "pipelineruns" {
+		t.Errorf("unexpected GVR for dryrun resource: %v/%v/%v", resource.Group, resource.Version, resource.Resource)
+	}
+	if resource.Namespace != "kabanero" || resource.Name != "project1-run" {
+		t.Errorf("unexpected namespace/name for dryrun resource: %v/%v", resource.Namespace, resource.Name)
+	}
+	if resource.Manifest["kind"] != "PipelineRun" {
+		t.Errorf("unexpected manifest for dryrun resource: %v", resource.Manifest)
+	}
+
+	if len(result.Events) != 1 {
+		t.Fatalf("expecting 1 event in dryrun result but got %v", len(result.Events))
+	}
+	sent := result.Events[0]
+	if sent.Destination != "dest1" {
+		t.Errorf("unexpected destination for dryrun event: %v", sent.Destination)
+	}
+	if len(sent.Header["X-Test"]) != 1 || sent.Header["X-Test"][0] != "value1" {
+		t.Errorf("unexpected header for dryrun event: %v", sent.Header)
+	}
+	buf, err := json.Marshal(sent.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != string(`{"name":"project1"}`) {
+		t.Errorf("unexpected payload for dryrun event: %s", string(buf))
+	}
+}
+
+func TestTrace(t *testing.T) {
+	srcEvent := []byte(`{"header": {"X-Kabanero-Trace": ["true"], "X-Github-Delivery": ["delivery1"]}, "attr1": "string1a", "attr2": "string2"}`)
+	var event map[string]interface{}
+	err := json.Unmarshal(srcEvent, &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* the trace header is ignored unless the traceHeader setting is set */
+	tp := trigger.NewProcessor(nil)
+	err = tp.Initialize(TRIGGER6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, result, err := tp.ProcessMessage(event, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Trace != nil || tp.GetTrace("delivery1") != nil {
+		t.Errorf("event traced without the traceHeader setting")
+	}
+
+	configMap := newUnstructured("v1", "ConfigMap", "kabanero", "team-settings", nil)
+	configMap.Object["data"] = map[string]interface{}{"token": "secret-value"}
+	tp = trigger.NewProcessor(&endpoints.Environment{DynamicClient: fake.NewSimpleDynamicClient(runtime.NewScheme(), configMap)})
+	err = tp.Initialize(TRIGGER34)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, result, err = tp.ProcessMessage(event, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.EventID != "delivery1" {
+		t.Fatalf("event ID %v is not the Github delivery ID", result.EventID)
+	}
+
+	trace := tp.GetTrace("delivery1")
+	if trace == nil {
+		t.Fatal("trace for event delivery1 not found")
+	}
+	if trace != result.Trace {
+		t.Errorf("trace in evaluation result is not the saved trace")
+	}
+
+	/* the first condition is false, the second is true */
+	var conditions []bool
+	var assigned interface{}
+	for _, entry := range trace.Entries {
+		switch entry.Kind {
+		case "if":
+			conditions = append(conditions, *entry.Taken)
+		case "assignment":
+			if entry.Variable == "directory" {
+				assigned = entry.Value
+			}
+		}
+	}
+	if len(conditions) < 2 || conditions[0] || !conditions[1] {
+		t.Errorf("unexpected branches taken in trace: %v", conditions)
+	}
+	if assigned != "notstring1string2" {
+		t.Errorf("unexpected value assigned to directory in trace: %v", assigned)
+	}
+
+	/* events without the trace header are not traced */
+	delete(event, "header")
+	_, result, err = tp.ProcessMessage(event, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Trace != nil || tp.GetTrace(result.EventID) != nil {
+		t.Errorf("event %v traced without trace header", result.EventID)
+	}
+
+	/* values are recorded as assigned, and the values of functions reading credentials or cluster data are redacted */
+	event["header"] = map[string]interface{}{"X-Kabanero-Trace": []interface{}{"true"}, "X-Github-Delivery": []interface{}{"delivery2"}}
+	_, result, err = tp.ProcessMessage(event, "redacted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Trace == nil {
+		t.Fatal("event with trace header not traced")
+	}
+	buf, err := json.Marshal(result.Trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(buf), "secret-value") {
+		t.Errorf("value read by getResource not redacted in trace: %s", buf)
+	}
+	var recorded []string
+	for _, entry := range result.Trace.Entries {
+		switch {
+		case entry.Kind == "assignment":
+			recorded = append(recorded, fmt.Sprintf("%v=%v", entry.Variable, entry.Value))
+		case entry.Kind == "function" && entry.Function == "getResource":
+			recorded = append(recorded, fmt.Sprintf("getResource%v=%v", entry.Arguments, entry.Result))
+		}
+	}
+	expected := "[build.name=first recorded=map[name:first] build.name=second getResource[<redacted> <redacted> <redacted> <redacted>]=<redacted> settings=<redacted>]"
+	if str := fmt.Sprintf("%v", recorded); str != expected {
+		t.Errorf("expecting trace %v, but got %v", expected, str)
+	}
+
+	/* values derived from a redacted function, directly or in a called function, are redacted */
+	for _, eventSource := range []string{"derived", "called"} {
+		_, result, err = tp.ProcessMessage(event, eventSource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err = json.Marshal(result.Trace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(buf), "secret-value") {
+			t.Errorf("value derived from getResource not redacted in trace of %v: %s", eventSource, buf)
+		}
+		tokens := 0
+		for _, entry := range result.Trace.Entries {
+			if entry.Variable == "token" {
+				tokens++
+				if entry.Value != "<redacted>" {
+					t.Errorf("token assigned in %v recorded as %v", eventSource, entry.Value)
+				}
+			}
+		}
+		if tokens == 0 {
+			t.Errorf("assignment of token not found in trace of %v: %s", eventSource, buf)
+		}
+	}
+}
+
+func TestDeclarationOrder(t *testing.T) {
+	var event map[string]interface{}
+	err := json.Unmarshal([]byte(`{"count": 1}`), &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp := trigger.NewProcessor(nil)
+	err = tp.Initialize(TRIGGER11)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* assignments in the same object depend on each other, so they must be evaluated in declaration order every time */
+	for i := 0; i < 20; i++ {
+		variablesArray, _, err := tp.ProcessMessage(event, "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+		variables := variablesArray[0]
+		if variables["third"] != float64(20) || variables["fifth"] != float64(22) {
+			t.Fatalf("unexpected variables after ordered assignments: %v", variables)
+		}
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	var event map[string]interface{}
+	err := json.Unmarshal([]byte(`{"count": 1}`), &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp := trigger.NewProcessor(nil)
+	err = tp.Initialize(TRIGGER11)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = tp.ProcessMessage(event, "error")
+	if err == nil {
+		t.Fatal("expecting error for undefined variable")
+	}
+	if !strings.Contains(err.Error(), "trigger11.yaml:15:9") {
+		t.Errorf("error does not contain position of the assignment: %v", err)
+	}
+}
+
+func TestSyntaxErrorAtInitialize(t *testing.T) {
+	/* the syntax error is in a branch never taken, and must be reported without processing any event */
+	tp := trigger.NewProcessor(nil)
+	err := tp.Initialize(TRIGGER12)
+	if err == nil {
+		t.Fatal("expecting syntax error from Initialize")
+	}
+	if !strings.Contains(err.Error(), "trigger12.yaml:10:15") {
+		t.Errorf("error does not contain position of the assignment: %v", err)
+	}
+}
+
+func TestForeach(t *testing.T) {
+	srcEvent := []byte(`{"commits": [{"id": "c1", "modified": ["a"]}, {"id": "c2", "modified": []}], "namespaces": {"test": "ns2", "dev": "ns1"}}`)
+	var event map[string]interface{}
+	err := json.Unmarshal(srcEvent, &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp := trigger.NewProcessor(nil)
+	err = tp.Initialize(TRIGGER13)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variablesArray, _, err := tp.ProcessMessage(event, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variables := variablesArray[0]
+
+	/* variables of the nested scope are not visible after the loop */
+	for _, name := range []string{"commit", "i", "modified", "ns", "name"} {
+		if _, ok := variables[name]; ok {
+			t.Errorf("variable %v of foreach visible outside of the loop", name)
+		}
+	}
+
+	buf, err := json.Marshal(variables["commits"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"id":"c1","index":0,"modified":true},{"id":"c2","index":1,"modified":false}]`
+	if string(buf) != expected {
+		t.Errorf("unexpected collected commits. Expecting %v, but got %v", expected, string(buf))
+	}
+
+	/* keys of a map are iterated in sorted order */
+	buf, err = json.Marshal(variables["namespaces"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `["dev=ns1","test=ns2"]`
+	if string(buf) != expected {
+		t.Errorf("unexpected collected namespaces. Expecting %v, but got %v", expected, string(buf))
+	}
+
+	/* a list changed by indexed assignment in the body is unchanged after the loop */
+	variablesArray, _, err = tp.ProcessMessage(event, "mutation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variables = variablesArray[0]
+	if variables["first"] != "c1" || fmt.Sprintf("%v", variables["seen"]) != "[c1-changed c2-changed]" {
+		t.Errorf("unexpected variables after changing a list in the body of foreach: first %v, seen %v", variables["first"], variables["seen"])
+	}
+	buf, err = json.Marshal(event["commits"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(buf), `"id":"c1"`) {
+		t.Errorf("the event was changed by the body of foreach: %v", string(buf))
+	}
+
+	_, _, err = tp.ProcessMessage(event, "overflow")
+	if err == nil || !strings.Contains(err.Error(), "maximum of 3 iterations") {
+		t.Errorf("expecting error for exceeding the maximum iterations, but got %v", err)
+	}
+}
+
+func TestExitStatements(t *testing.T) {
+	tp := trigger.NewProcessor(nil)
+	err := tp.Initialize(TRIGGER14)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* return from function, and no stop */
+	event := map[string]interface{}{"branch": "release-1.0"}
+	variablesArray, result, err := tp.ProcessMessage(event, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if variablesArray[0]["kind"] != "release" || variablesArray[0]["built"] != true {
+		t.Errorf("unexpected variables for release branch: %v", variablesArray[0])
+	}
+	if len(result.Exits) != 0 {
+		t.Errorf("unexpected exits for release branch: %v", result.Exits)
+	}
+
+	/* stop ends the first trigger only */
+	event = map[string]interface{}{"branch": "feature1"}
+	variablesArray, result, err = tp.ProcessMessage(event, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := variablesArray[0]["built"]; ok || variablesArray[0]["kind"] != "other" {
+		t.Errorf("unexpected variables after stop: %v", variablesArray[0])
+	}
+	if variablesArray[1]["second"] != true {
+		t.Errorf("second trigger not evaluated after stop: %v", variablesArray[1])
+	}
+	if len(result.Exits) != 1 || result.Exits[0].Kind != "stop" || result.Exits[0].Trigger != 0 ||
+		result.Exits[0].Reason != "branch feature1 is not a release branch" {
+		t.Errorf("unexpected exits after stop: %v", result.Exits)
+	}
+
+	/* fail aborts with the user defined message */
+	event = map[string]interface{}{"branch": ""}
+	_, result, err = tp.ProcessMessage(event, "fail")
+	if err == nil || !strings.Contains(err.Error(), "missing branch") {
+		t.Errorf("expecting error from fail, but got: %v", err)
+	}
+	if len(result.Exits) != 1 || result.Exits[0].Kind != "fail" || result.Exits[0].Reason != "missing branch" {
+		t.Errorf("unexpected exits after fail: %v", result.Exits)
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tp := trigger.NewProcessor(nil)
+	err := tp.Initialize(TRIGGER15)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := map[string]interface{}{"fail": false, "name": "project1"}
+	variablesArray, result, err := tp.ProcessMessage(event, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variables := variablesArray[0]
+	if variables["before"] != "set" || variables["handledStatement"] != "event.missing" {
+		t.Errorf("unexpected variables after catch: %v", variables)
+	}
+	if message, _ := variables["handledMessage"].(string); !strings.Contains(message, "missing") {
+		t.Errorf("unexpected error message in catch: %v", variables["handledMessage"])
+	}
+	if result.SystemError != nil {
+		t.Errorf("handled error routed to systemError: %v", result.SystemError)
+	}
+
+	/* the first fail is caught, the second is routed to systemError */
+	event["fail"] = true
+	_, result, err = tp.ProcessMessage(event, "default")
+	if err == nil {
+		t.Fatal("expecting error from unhandled fail")
+	}
+	if len(result.Exits) != 1 || result.Exits[0].Reason != "build could not be started for project1" {
+		t.Errorf("unexpected exits: %v", result.Exits)
+	}
+	if result.SystemError == nil || len(result.SystemError.Events) != 1 {
+		t.Fatalf("error not routed to systemError: %v", result.SystemError)
+	}
+	sent := result.SystemError.Events[0]
+	payload, _ := sent.Payload.(map[string]interface{})
+	if sent.Destination != "notify" || payload["message"] != "build could not be started for project1" ||
+		payload["eventSource"] != "default" || payload["position"] != "../../test_data/trigger15/trigger15.yaml:20:9" {
+		t.Errorf("unexpected event sent to systemError destination: %v", sent)
+	}
+}
+
+func TestFunctionParameters(t *testing.T) {
+	tp := trigger.NewProcessor(nil)
+	err := tp.Initialize(TRIGGER16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := map[string]interface{}{"name": "world"}
+	variablesArray, _, err := tp.ProcessMessage(event, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variables := variablesArray[0]
+	both, _ := variables["both"].(map[string]interface{})
+	if both["sum"] != int64(5) || both["product"] != int64(6) {
+		t.Errorf("unexpected outputs of function with two arguments: %v", variables["both"])
+	}
+	defaulted, _ := variables["defaulted"].(map[string]interface{})
+	if defaulted["sum"] != int64(12) || defaulted["product"] != int64(20) {
+		t.Errorf("unexpected outputs of function with default argument: %v", variables["defaulted"])
+	}
+	if variables["greeting"] != "hello world" {
+		t.Errorf("unexpected output of function with single input: %v", variables["greeting"])
+	}
+
+	expectedErrors := map[string]string{
+		"recursion": "maximum call depth of 5 exceeded",
+		"missing":   "requires argument a",
+		"wrongType": "argument a of function arith should be of type int, but is of type string",
+		"tooMany":   "accepts at most 1 arguments",
+	}
+	for eventSource, expected := range expectedErrors {
+		_, _, err = tp.ProcessMessage(event, eventSource)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expecting error containing %v for event source %v, but got: %v", expected, eventSource, err)
+		}
+	}
+}
+
+func TestImports(t *testing.T) {
+	tp := trigger.NewProcessor(nil)
+	err := tp.Initialize(TRIGGER17)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := map[string]interface{}{"name": "web"}
+	variablesArray, _, err := tp.ProcessMessage(event, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variables := variablesArray[0]
+	expected := map[string]interface{}{
+		"label":       "app:web!",
+		"shout":       "web!!",
+		"environment": "dev-default",
+		"count":       int64(3),
+	}
+	for name, value := range expected {
+		if variables[name] != value {
+			t.Errorf("expecting %v to be %v, but got %v", name, value, variables[name])
+		}
+	}
+
+	/* a library does not depend on the name it is imported as */
+	tp = trigger.NewProcessor(nil)
+	err = tp.Initialize(TRIGGER17 + "/renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variablesArray, _, err = tp.ProcessMessage(event, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variables = variablesArray[0]
+	expected = map[string]interface{}{
+		"label": "app:web!",
+		"loud":  "default!",
+		"tag":   "app:",
+	}
+	for name, value := range expected {
+		if variables[name] != value {
+			t.Errorf("expecting %v to be %v, but got %v", name, value, variables[name])
+		}
+	}
+
+	tp = trigger.NewProcessor(nil)
+	err = tp.Initialize(TRIGGER17 + "/cycle")
+	if err == nil || !strings.Contains(err.Error(), "is circular") {
+		t.Errorf("expecting circular import error, but got: %v", err)
+	}
+
+	tp = trigger.NewProcessor(nil)
+	err = tp.Initialize(TRIGGER17 + "/unverified")
+	if err == nil || !strings.Contains(err.Error(), "sha256 checksum of the archive is required") {
+		t.Errorf("expecting an error for an archive URL without a checksum, but got: %v", err)
+	}
+}
+
+func TestCollectionParameters(t *testing.T) {
+	tp := trigger.NewProcessor(nil)
+	err := tp.Initialize(TRIGGER18)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := map[string]interface{}{"repository": "project1", "branch": "dev"}
+	variablesArray, _, err := tp.ProcessMessage(event, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variables := variablesArray[0]
+	if variables["image"] != "image-registry.openshift-image-registry.svc:5000/project1" {
+		t.Errorf("unexpected image with default registry: %v", variables["image"])
+	}
+	if variables["allowed"] != false || variables["retries"] != int64(4) || variables["timeout"] != "1h0m0s" {
+		t.Errorf("unexpected variables with default parameters: %v", variables)
+	}
+
+	err = tp.SetParameterOverrides(map[string]string{
+		"registry":        "quay.io",
+		"allowedBranches": "[ master, dev ]",
+		"retries":         "5",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	variablesArray, _, err = tp.ProcessMessage(event, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variables = variablesArray[0]
+	if variables["image"] != "quay.io/project1" || variables["allowed"] != true || variables["retries"] != int64(6) || variables["timeout"] != "1h0m0s" {
+		t.Errorf("unexpected variables with overridden parameters: %v", variables)
+	}
+
+	/* invalid overrides leave the parameters unchanged */
+	expectedErrors := map[string]map[string]string{
+		"should be of type int": {"registry": "docker.io", "retries": "many"},
+		"not declared":          {"registry": "docker.io", "unknown": "1"},
+	}
+	for expected, overrides := range expectedErrors {
+		err = tp.SetParameterOverrides(overrides)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expecting error containing %v for overrides %v, but got: %v", expected, overrides, err)
+		}
+	}
+	variablesArray, _, err = tp.ProcessMessage(event, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if variablesArray[0]["image"] != "quay.io/project1" {
+		t.Errorf("parameters changed by invalid overrides: %v", variablesArray[0]["image"])
+	}
+
+	tp = trigger.NewProcessor(nil)
+	err = tp.Initialize(TRIGGER18 + "/readonly")
+	if err == nil || !strings.Contains(err.Error(), "params is read-only") {
+		t.Errorf("expecting error setting params, but got: %v", err)
+	}
+}
+
+func TestVariableScoping(t *testing.T) {
+	tp := trigger.NewProcessor(nil)
+	err := tp.Initialize(TRIGGER19)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := map[string]interface{}{"name": "project1"}
+	variablesArray, result, err := tp.ProcessMessage(event, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variables := variablesArray[0]
+	if _, ok := variables["temp"]; ok {
+		t.Errorf("let variable temp visible after the end of the body: %v", variables["temp"])
+	}
+	build, _ := variables["build"].(map[string]interface{})
+	if build["label"] != "project1-temp-label" || build["inner"] != "inner" || build["outer"] != "project1-temp" {
+		t.Errorf("unexpected values set from let variables: %v", build)
+	}
+	if _, ok := build["obsolete"]; ok || variables["obsolete"] != int64(1) {
+		t.Errorf("unexpected values after unset: %v, %v", build["obsolete"], variables["obsolete"])
+	}
+
+	targets, _ := build["targets"].([]interface{})
+	if len(targets) != 3 || fmt.Sprintf("%v", targets[1]) != "staging" {
+		t.Errorf("unexpected list after indexed assignment: %v", build["targets"])
+	}
+	stages, _ := build["stages"].([]interface{})
+	if len(stages) != 2 || !strings.Contains(fmt.Sprintf("%v", stages[1]), "c") {
+		t.Errorf("unexpected list after indexed assignment of nested map: %v", build["stages"])
+	}
+
+	if len(result.Resources) != 1 {
+		t.Fatalf("expecting 1 resource in dryrun result but got %v", len(result.Resources))
+	}
+	resource := result.Resources[0]
+	data, _ := resource.Manifest["data"].(map[string]interface{})
+	if resource.Name != "project1-config" || data["label"] != "project1-temp-label" || data["secret"] == "hidden" {
+		t.Errorf("unexpected resource rendered from exported variables: %v", resource.Manifest)
+	}
+
+	expectedErrors := map[string]string{
+		"outOfRange": "index 2 out of range for list of length 2",
+		"notSet":     "missing is not set",
+	}
+	for eventSource, expected := range expectedErrors {
+		_, _, err = tp.ProcessMessage(event, eventSource)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expecting error containing %v for event source %v, but got: %v", expected, eventSource, err)
+		}
+	}
+}
+
+func TestEventSchemas(t *testing.T) {
+	tp := trigger.NewProcessor(nil)
+	err := tp.Initialize(TRIGGER20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	push := map[string]interface{}{
+		"header": map[string]interface{}{"X-Github-Event": []interface{}{"push"}},
+		"body": map[string]interface{}{
+			"ref":         "refs/heads/master",
+			"repository":  map[string]interface{}{"name": "app", "owner": map[string]interface{}{"login": "org"}},
+			"commits":     []interface{}{map[string]interface{}{"author": map[string]interface{}{"name": "dev"}}},
+			"head_commit": map[string]interface{}{"message": "fix"},
+		},
+	}
+	variablesArray, _, err := tp.ProcessMessage(push, "github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variables := variablesArray[0]
+	expected := map[string]interface{}{"repository": "app", "branch": "master", "owner": "org", "event": "push", "headMessage": "fix"}
+	for name, value := range expected {
+		if variables[name] != value {
+			t.Errorf("expecting %v to be %v, but got %v", name, value, variables[name])
+		}
+	}
+	if fmt.Sprintf("%v", variables["authors"]) != "[dev]" {
+		t.Errorf("unexpected authors: %v", variables["authors"])
+	}
+
+	inline := map[string]interface{}{"name": "web", "replicas": float64(2), "labels": map[string]interface{}{"tier": "front"}}
+	variablesArray, _, err = tp.ProcessMessage(inline, "inline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if variablesArray[0]["scaled"] != float64(4) || variablesArray[0]["tier"] != "front" {
+		t.Errorf("unexpected variables for inline schema: %v", variablesArray[0])
+	}
+
+	build := map[string]interface{}{
+		"build":  map[string]interface{}{"id": "42"},
+		"stages": []interface{}{map[string]interface{}{"name": "compile"}},
+	}
+	variablesArray, _, err = tp.ProcessMessage(build, "fromFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if variablesArray[0]["firstStage"] != "compile:42" {
+		t.Errorf("unexpected variables for schema file: %v", variablesArray[0])
+	}
+
+	expectedErrors := map[string][]string{
+		"typo":     {"trigger.yaml:8", "undefined field 'nmae'", "trigger.yaml:12", "undefined field 'autor'", "trigger.yaml:14", "undefined field 'mesage'"},
+		"mismatch": {"trigger.yaml:8", "'_+_' applied to '(double, int)'", "trigger.yaml:9", "condition message.body.pull_request.title is of type string, not bool"},
+	}
+	for dir, expected := range expectedErrors {
+		tp = trigger.NewProcessor(nil)
+		err = tp.Initialize(TRIGGER20 + "/" + dir)
+		if err == nil {
+			t.Errorf("expecting type check errors for %v", dir)
+			continue
+		}
+		for _, str := range expected {
+			if !strings.Contains(err.Error(), str) {
+				t.Errorf("expecting error for %v to contain %v, but got: %v", dir, str, err)
+			}
+		}
+	}
+}
+
+func TestEvaluationLimits(t *testing.T) {
+	tp := trigger.NewProcessor(nil)
+	err := tp.Initialize(TRIGGER21)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := make([]interface{}, 0, 100)
+	for i := 0; i < 100; i++ {
+		items = append(items, float64(i))
+	}
+	message := map[string]interface{}{"items": items}
+	variablesArray, _, err := tp.ProcessMessage(message, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if variablesArray[0]["total"] != int64(100) {
+		t.Errorf("unexpected total: %v", variablesArray[0]["total"])
+	}
+
+	/* the timeout of these triggers is large enough that only the cost limit can end them */
+	expected := map[string][]string{
+		"expensive":       {"trigger21.yaml:16", "exceeded the cost limit of 1000"},
+		"expensiveFilter": {"trigger21.yaml:20", "exceeded the cost limit of 1000"},
+	}
+	for eventSource, strs := range expected {
+		_, _, err = tp.ProcessMessage(message, eventSource)
+		if err == nil {
+			t.Errorf("expecting error for %v", eventSource)
+			continue
+		}
+		for _, str := range strs {
+			if !strings.Contains(err.Error(), str) {
+				t.Errorf("expecting error for %v to contain %v, but got: %v", eventSource, str, err)
+			}
+		}
+	}
+
+	/* a trigger of a million statements can not complete in 200ms */
+	tp = trigger.NewProcessor(nil)
+	err = tp.Initialize(TRIGGER21 + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = tp.ProcessMessage(message, "slow")
+	if err == nil || !strings.Contains(err.Error(), "slow.yaml:") || !strings.Contains(err.Error(), "timed out after 200ms") {
+		t.Errorf("expecting slow trigger to time out, but got: %v", err)
+	}
+}
+
+/* panicVal is a CEL value whose Go value can not be retrieved, to test recovery from panics in the evaluator */
+type panicVal struct{}
+
+func (v panicVal) ConvertToNative(typeDesc reflect.Type) (interface{}, error) {
+	panic("ConvertToNative")
+}
+func (v panicVal) ConvertToType(typeValue ref.Type) ref.Val { panic("ConvertToType") }
+func (v panicVal) Equal(other ref.Val) ref.Val              { panic("Equal") }
+func (v panicVal) Type() ref.Type                           { return types.StringType }
+func (v panicVal) Value() interface{}                       { panic("unable to get value") }
+
+func TestPanicRecovery(t *testing.T) {
+	tp := trigger.NewProcessor(nil)
+	err := tp.Initialize(TRIGGER22)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := map[string]interface{}{"name": "project1", "value": panicVal{}}
+	_, result, err := tp.ProcessMessage(event, "default")
+	if err == nil {
+		t.Fatal("expecting error from panic")
+	}
+	if !strings.Contains(err.Error(), "trigger22.yaml:8:9: internal error: unable to get value") {
+		t.Errorf("unexpected error from panic: %v", err)
+	}
+	if result.SystemError == nil || len(result.SystemError.Events) != 1 {
+		t.Fatalf("panic not routed to systemError: %v", result.SystemError)
+	}
+	payload := fmt.Sprintf("%v", result.SystemError.Events[0].Payload)
+	if !strings.Contains(payload, "../../test_data/trigger22/trigger22.yaml:8:9") {
+		t.Errorf("unexpected event sent to systemError destination: %v", payload)
+	}
+
+	variablesArray, _, err := tp.ProcessMessage(event, "caught")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message, _ := variablesArray[0]["caught"].(string); !strings.Contains(message, "internal error") {
+		t.Errorf("unexpected error caught from panic: %v", variablesArray[0]["caught"])
+	}
+
+	/* the processor is still usable after a panic */
+	event["value"] = "ok"
+	variablesArray, _, err = tp.ProcessMessage(event, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if variablesArray[0]["value"] != "ok" {
+		t.Errorf("unexpected variables after panic: %v", variablesArray[0])
+	}
+}
+
+func TestRegexFunctions(t *testing.T) {
+	tp := trigger.NewProcessor(nil)
+	err := tp.Initialize(TRIGGER23)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := map[string]interface{}{"ref": "refs/tags/1.20.3"}
+	variablesArray, _, err := tp.ProcessMessage(event, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variables := variablesArray[0]
+	expected := map[string]interface{}{
+		"version":  "1.20.3",
+		"noMatch":  "",
+		"numbers":  []string{"1", "20", "3"},
+		"none":     []string{},
+		"captures": map[string]interface{}{"0": "refs/tags/1.20", "1": "tags", "2": "1", "3": "20", "kind": "tags"},
+		"missing":  map[string]interface{}{},
+		"replaced": "release-1.20.3",
+	}
+	for name, value := range expected {
+		if !reflect.DeepEqual(variables[name], value) {
+			t.Errorf("expecting %v to be %T %#v, but got %T %#v", name, value, value, variables[name], variables[name])
+		}
+	}
+
+	_, _, err = tp.ProcessMessage(event, "invalid")
+	if err == nil || !strings.Contains(err.Error(), "invalid regular expression") {
+		t.Errorf("expecting error for invalid regular expression, but got: %v", err)
+	}
+}
+
+func TestSemverFunctions(t *testing.T) {
+	tp := trigger.NewProcessor(nil)
+	err := tp.Initialize(TRIGGER24)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := map[string]interface{}{"tag": "v1.4.0-rc.1+build.5", "deployed": "1.3.2"}
+	variablesArray, _, err := tp.ProcessMessage(event, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variables := variablesArray[0]
+	expected := map[string]interface{}{
+		"version": map[string]interface{}{
+			"major": int64(1), "minor": int64(4), "patch": int64(0), "prerelease": "rc.1", "build": "build.5", "isPrerelease": true,
+		},
+		"release": map[string]interface{}{
+			"major": int64(1), "minor": int64(4), "patch": int64(0), "prerelease": "", "build": "", "isPrerelease": false,
+		},
+		"newer":                  true,
+		"prereleaseOrder":        int64(-1),
+		"releaseAfterPrerelease": int64(1),
+		"buildIgnored":           int64(0),
+		"range":                  true,
+		"caret":                  true,
+		"caretZero":              true,
+		"tilde":                  true,
+		"alternatives":           true,
+		"greaterPartial":         true,
+		"atMostPartial":          true,
+		"lessPartial":            true,
+		"atLeastPartial":         true,
+		"wildcard":               true,
+		"nextPatch":              "1.3.3",
+		"nextMinor":              "v1.4.0",
+		"nextMajor":              "2.0.0",
+	}
+	for name, value := range expected {
+		if !reflect.DeepEqual(variables[name], value) {
+			t.Errorf("expecting %v to be %T %#v, but got %T %#v", name, value, value, variables[name], variables[name])
+		}
+	}
+
+	expectedErrors := map[string]string{
+		"invalid":     "invalid semantic version 1.2",
+		"invalidPart": "unknown part build",
+	}
+	for eventSource, str := range expectedErrors {
+		_, _, err = tp.ProcessMessage(event, eventSource)
+		if err == nil || !strings.Contains(err.Error(), str) {
+			t.Errorf("expecting error for %v to contain %v, but got: %v", eventSource, str, err)
+		}
+	}
+}
+
+func TestTimeFunctions(t *testing.T) {
+	tp := trigger.NewProcessor(nil)
+	err := tp.Initialize(TRIGGER25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	/* a Friday, 18:30 in Berlin */
+	tp.SetClock(func() time.Time {
+		return time.Date(2020, time.March, 13, 17, 30, 0, 0, time.UTC)
+	})
+
+	variablesArray, _, err := tp.ProcessMessage(map[string]interface{}{}, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variables := variablesArray[0]
+	expected := map[string]interface{}{
+		"now":         "2020-03-13T17:30:00Z",
+		"date":        "2020-03-13",
+		"local":       "18:30 CET",
+		"parsed":      "2020-03-13T08:15:00Z",
+		"parsedZone":  "2020-03-13T14:15:00Z",
+		"berlin":      "2020-03-13T18:30:00+01:00",
+		"freeze":      true,
+		"open":        true,
+		"weekend":     false,
+		"nightly":     true,
+		"notNightly":  false,
+		"wrappedDays": true,
+	}
+	for name, value := range expected {
+		if !reflect.DeepEqual(variables[name], value) {
+			t.Errorf("expecting %v to be %T %#v, but got %T %#v", name, value, value, variables[name], variables[name])
+		}
+	}
+
+	expectedErrors := map[string]string{
+		"invalidWindow": "invalid time 8",
+		"invalidZone":   "unknown time zone Mars/Olympus",
+		"invalidTime":   "invalid time yesterday",
+	}
+	for eventSource, str := range expectedErrors {
+		_, _, err = tp.ProcessMessage(map[string]interface{}{}, eventSource)
+		if err == nil || !strings.Contains(err.Error(), str) {
+			t.Errorf("expecting error for %v to contain %v, but got: %v", eventSource, str, err)
+		}
+	}
+}
+
+func TestEncodingFunctions(t *testing.T) {
+	tp := trigger.NewProcessor(nil)
+	err := tp.Initialize(TRIGGER26)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := map[string]interface{}{"name": "app"}
+	variablesArray, _, err := tp.ProcessMessage(event, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variables := variablesArray[0]
+	expected := map[string]interface{}{
+		"encoded":       "dXNlcjpzZWNyZXQ=",
+		"decoded":       "user:secret",
+		"hash":          "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		"json":          `{"enabled":true,"name":"app","owner":null,"replicas":2,"tags":["a","b"]}`,
+		"yaml":          "name: app\ntags:\n- a\n",
+		"query":         "https://example.com/search?q=a+b%26c%3Dd",
+		"lower":         "myapp",
+		"upper":         "MYAPP",
+		"trimmed":       "app",
+		"trimmedCutset": "app",
+		"joined":        "a.b.c",
+		"short":         "short-name",
+		"fromJsonName":  "app",
+		"fromJson":      map[string]interface{}{"name": "app", "ports": []interface{}{float64(8080), float64(9443)}},
+		"fromYaml":      map[string]interface{}{"name": "app", "ports": []interface{}{float64(8080)}},
+		"fromYamlPort":  float64(8080),
+		"roundTrip":     "app",
+	}
+	for name, value := range expected {
+		if !reflect.DeepEqual(variables[name], value) {
+			t.Errorf("expecting %v to be %T %#v, but got %T %#v", name, value, value, variables[name], variables[name])
+		}
+	}
+	truncated, _ := variables["truncated"].(string)
+	truncatedOther, _ := variables["truncatedOther"].(string)
+	if len(truncated) != 20 || !strings.HasPrefix(truncated, "a-very-long-") || truncated == truncatedOther {
+		t.Errorf("unexpected truncated names %v and %v", truncated, truncatedOther)
+	}
+	truncatedUnicode, _ := variables["truncatedUnicode"].(string)
+	if len(truncatedUnicode) > 20 || !utf8.ValidString(truncatedUnicode) || !strings.HasPrefix(truncatedUnicode, "ééééé-") {
+		t.Errorf("unexpected truncated name %q", truncatedUnicode)
+	}
+
+	expectedErrors := map[string]string{
+		"invalidBase64": "function base64Decode",
+		"invalidUTF8":   "not a valid UTF-8 string",
+		"invalidJSON":   "function fromJSON",
+		"invalidJoin":   "element 1 of the list is not a string",
+	}
+	for eventSource, str := range expectedErrors {
+		_, _, err = tp.ProcessMessage(event, eventSource)
+		if err == nil || !strings.Contains(err.Error(), str) {
+			t.Errorf("expecting error for %v to contain %v, but got: %v", eventSource, str, err)
+		}
+	}
+}
+
+func TestQueryFunction(t *testing.T) {
+	tp := trigger.NewProcessor(nil)
+	err := tp.Initialize(TRIGGER27)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := map[string]interface{}{
+		"body": map[string]interface{}{
+			"repository": map[string]interface{}{"name": "app", "owner": map[string]interface{}{"login": "org"}},
+			"commits": []interface{}{
+				map[string]interface{}{"id": "c1", "author": map[string]interface{}{"name": "dev"}, "modified": []interface{}{"README.md"}},
+				map[string]interface{}{"id": "c2", "author": map[string]interface{}{"name": "ops"}, "modified": []interface{}{"Dockerfile", "app.go"}},
+			},
+			"tags": []interface{}{},
+		},
+	}
+	variablesArray, _, err := tp.ProcessMessage(message, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variables := variablesArray[0]
+	expected := map[string]interface{}{
+		"modified":        []interface{}{"README.md", "Dockerfile", "app.go"},
+		"firstId":         "c1",
+		"owner":           "org",
+		"authors":         []interface{}{"c1"},
+		"lastCommit":      []interface{}{"c2"},
+		"nameCount":       int64(3),
+		"hasDockerfile":   true,
+		"noLicense":       true,
+		"noIndex":         true,
+		"noNegativeIndex": "none",
+		"lastTag":         []interface{}{},
+		"firstTags":       []interface{}{},
+		"secondModified":  []interface{}{},
+		"firstModified":   []interface{}{"README.md", "Dockerfile"},
+		"repository":      map[string]interface{}{"name": "app", "owner": map[string]interface{}{"login": "org"}},
+		"repositoryName":  "app",
+		"license":         "none",
+		"ownerOrDefault":  "org",
+	}
+	for name, value := range expected {
+		if !reflect.DeepEqual(variables[name], value) {
+			t.Errorf("expecting %v to be %T %#v, but got %T %#v", name, value, value, variables[name], variables[name])
+		}
+	}
+
+	_, _, err = tp.ProcessMessage(message, "invalid")
+	if err == nil || !strings.Contains(err.Error(), "invalid expression") {
+		t.Errorf("expecting error for invalid expression, but got: %v", err)
+	}
+}
+
+func newUnstructured(apiVersion string, kind string, namespace string, name string, labels map[string]interface{}) *unstructured.Unstructured {
+	metadata := map[string]interface{}{"name": name, "labels": labels}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata":   metadata,
+	}}
+}
+
+func TestResourceFunctions(t *testing.T) {
+	configMap := newUnstructured("v1", "ConfigMap", "kabanero", "team-settings", nil)
+	configMap.Object["data"] = map[string]interface{}{"owner": "team-a"}
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme(),
+		configMap,
+		newUnstructured("v1", "Namespace", "", "kabanero", nil),
+		newUnstructured("v1", "Secret", "kabanero", "token", nil),
+		newUnstructured("tekton.dev/v1alpha1", "PipelineRun", "kabanero", "build-1", map[string]interface{}{"sha": "abc"}),
+		newUnstructured("tekton.dev/v1alpha1", "PipelineRun", "kabanero", "build-2", map[string]interface{}{"sha": "def"}),
+		newUnstructured("tekton.dev/v1alpha1", "PipelineRun", "other", "build-3", map[string]interface{}{"sha": "abc"}))
+	tp := trigger.NewProcessor(&endpoints.Environment{DynamicClient: client})
+	err := tp.Initialize(TRIGGER28)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variablesArray, _, err := tp.ProcessMessage(map[string]interface{}{"sha": "abc"}, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variables := variablesArray[0]
+	expected := map[string]string{
+		"owner":           "team-a",
+		"sameOwner":       "team-a",
+		"namespaceExists": "true",
+		"noConfigMap":     "true",
+		"alreadyRan":      "true",
+		"runName":         "build-1",
+		"allRuns":         "3",
+		"otherRuns":       "0",
+	}
+	for name, value := range expected {
+		if str := fmt.Sprintf("%v", variables[name]); str != value {
+			t.Errorf("expecting %v to be %v, but got %v", name, value, str)
+		}
+	}
+
+	/* the team settings are read once for the event although requested twice, and the missing config map once */
+	countGets := func() int {
+		count := 0
+		for _, action := range client.Actions() {
+			if action.GetVerb() == "get" && action.GetResource().Resource == "configmaps" {
+				count++
+			}
+		}
+		return count
+	}
+	if count := countGets(); count != 2 {
+		t.Errorf("expecting 2 gets of config maps for the event, but got %v", count)
+	}
+	_, _, err = tp.ProcessMessage(map[string]interface{}{"sha": "abc"}, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := countGets(); count != 4 {
+		t.Errorf("expecting config maps to be read again for another event, but got %v gets in total", count)
+	}
+
+	expectedErrors := map[string]string{
+		"secret":          "kind Secret is not readable",
+		"lowerCaseSecret": "kind secret is not readable",
+		"notReadable":     "kind Deployment is not readable",
+		"otherGroup":      "kind PipelineRun is not readable",
+		"coreGroup":       "kind PipelineRun is not readable",
+		"invalidSelector": "invalid label selector",
+	}
+	for eventSource, str := range expectedErrors {
+		_, _, err = tp.ProcessMessage(map[string]interface{}{}, eventSource)
+		if err == nil || !strings.Contains(err.Error(), str) {
+			t.Errorf("expecting error for %v to contain %v, but got: %v", eventSource, str, err)
+		}
+	}
+
+	tp = trigger.NewProcessor(nil)
+	err = tp.Initialize(TRIGGER28)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = tp.ProcessMessage(map[string]interface{}{"sha": "abc"}, "default")
+	if err == nil || !strings.Contains(err.Error(), "no Kubernetes client") {
+		t.Errorf("expecting error without a Kubernetes client, but got: %v", err)
+	}
+}
+
+func TestHTTPFunctions(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/owners/app", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprintf(w, `{"team": "platform", "authorization": %q}`, r.Header.Get("Authorization"))
+	})
+	approvals := 0
+	mux.HandleFunc("/approvals", func(w http.ResponseWriter, r *http.Request) {
+		approvals++
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"approved": %v, "contentType": %q}`, r.Method == http.MethodPost && string(body) == `{"app":"web","replicas":2}`, r.Header.Get("Content-Type"))
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://example.com/", http.StatusFound)
+	})
+	var plainServer *httptest.Server
+	mux.HandleFunc("/downgrade", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, plainServer.URL+"/owners/app", http.StatusFound)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+	plainServer = httptest.NewServer(mux)
+	defer plainServer.Close()
+
+	kubeClient := kubefake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry-token", Namespace: "kabanero"},
+		Data:       map[string][]byte{"token": []byte("s3cret")},
+	})
+	tp := trigger.NewProcessor(&endpoints.Environment{KubeClient: kubeClient})
+	tp.SetHTTPTransport(server.Client().Transport)
+	err := tp.Initialize(TRIGGER29)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := map[string]interface{}{"url": server.URL, "plainURL": plainServer.URL}
+	variablesArray, _, err := tp.ProcessMessage(message, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variables := variablesArray[0]
+	expected := map[string]string{
+		"status":        "200",
+		"team":          "platform",
+		"authorization": "Bearer s3cret",
+		"contentType":   "application/json; charset=utf-8",
+		"approved":      "true",
+		"receivedType":  "application/json",
+		"text":          "plain text",
+		"missing":       "404",
+	}
+	for name, value := range expected {
+		if str := fmt.Sprintf("%v", variables[name]); str != value {
+			t.Errorf("expecting %v to be %v, but got %v", name, value, str)
+		}
+	}
+
+	expectedErrors := map[string]string{
+		"notAllowed": "host example.com is not allowed",
+		"redirect":   "host example.com is not allowed",
+		"slow":       "deadline exceeded",
+		"plain":      "only sent to https URLs",
+	}
+	for eventSource, str := range expectedErrors {
+		_, _, err = tp.ProcessMessage(message, eventSource)
+		if err == nil || !strings.Contains(err.Error(), str) {
+			t.Errorf("expecting error for %v to contain %v, but got: %v", eventSource, str, err)
+		}
+	}
+
+	/* credentials are not sent in clear text when redirected to http */
+	variablesArray, _, err = tp.ProcessMessage(message, "downgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if variablesArray[0]["team"] != "platform" || variablesArray[0]["authorization"] != "" {
+		t.Errorf("expecting no credentials after a redirect to http, but got %v", variablesArray[0]["authorization"])
+	}
+
+	/* POST requests are recorded rather than sent when dryrun is set */
+	approvals = 0
+	tp = trigger.NewProcessor(&endpoints.Environment{KubeClient: kubeClient})
+	err = tp.Initialize(TRIGGER29 + "/dryrun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variablesArray, result, err := tp.ProcessMessage(message, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approvals != 0 {
+		t.Errorf("httpPost sent %v requests with dryrun set", approvals)
+	}
+	if variablesArray[0]["status"] != int64(0) {
+		t.Errorf("expecting status 0 for a POST not sent, but got %v", variablesArray[0]["status"])
+	}
+	if len(result.Events) != 1 || result.Events[0].Destination != server.URL+"/approvals" {
+		t.Fatalf("expecting the POST to be recorded in the dry run result, but got %v", result.Events)
+	}
+	buf, err := json.Marshal(result.Events[0].Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != `{"app":"web","replicas":2}` {
+		t.Errorf("unexpected payload recorded for the POST: %s", buf)
+	}
+}
+
+func TestFileFunctions(t *testing.T) {
+	packageJSON := `{"name": "app", "version": "1.2.3"}`
+	contents := map[string]string{
+		"package.json": fmt.Sprintf(`{"type": "file", "encoding": "base64", "name": "package.json", "path": "package.json", "content": %q}`,
+			base64.StdEncoding.EncodeToString([]byte(packageJSON))),
+		"CODEOWNERS": fmt.Sprintf(`{"type": "file", "encoding": "base64", "name": "CODEOWNERS", "path": "CODEOWNERS", "content": %q}`,
+			base64.StdEncoding.EncodeToString([]byte("* @org/team\n"))),
+		"": `[{"type": "file", "name": "package.json", "path": "package.json", "size": 36},
+			{"type": "dir", "name": "services", "path": "services"}]`,
+		"services": `[{"type": "dir", "name": "api", "path": "services/api"},
+			{"type": "dir", "name": "web", "path": "services/web"},
+			{"type": "file", "name": "README.md", "path": "services/README.md"}]`,
+	}
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/repos/org/app/contents/")
+		requests[path]++
+		content, ok := contents[path]
+		if !ok || r.URL.Query().Get("ref") != "abc123" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "Not Found"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, content)
+	}))
+	defer server.Close()
+
+	kubeClient := kubefake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "github", Namespace: "kabanero", Annotations: map[string]string{"tekton.dev/git-0": "https://github.com"}},
+		Data:       map[string][]byte{"username": []byte("user"), "password": []byte("token")},
+	})
+	tp := trigger.NewProcessor(&endpoints.Environment{KubeClient: kubeClient})
+	tp.SetGitHubAPIURL(server.URL)
+	err := tp.Initialize(TRIGGER30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := map[string]interface{}{
+		"header": map[string]interface{}{"X-Github-Event": []interface{}{"push"}},
+		"body": map[string]interface{}{
+			"after": "abc123",
+			"repository": map[string]interface{}{
+				"name":     "app",
+				"owner":    map[string]interface{}{"login": "org"},
+				"html_url": "https://github.com/org/app",
+			},
+		},
+	}
+	variablesArray, _, err := tp.ProcessMessage(message, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variables := variablesArray[0]
+	expected := map[string]string{
+		"version":     "1.2.3",
+		"packageText": packageJSON,
+		"hasPackage":  "true",
+		"noPom":       "true",
+		"owners":      "* @org/team\n",
+		"rootNames":   "[package.json services]",
+		"modules":     "[api web]",
+		"yamlVersion": "1.2.3",
+		"noYAML":      "true",
+	}
+	for name, value := range expected {
+		if str := fmt.Sprintf("%v", variables[name]); str != value {
+			t.Errorf("expecting %v to be %v, but got %v", name, value, str)
+		}
+	}
+	if requests["package.json"] != 1 {
+		t.Errorf("expecting package.json to be read once for the event, but it was read %v times", requests["package.json"])
+	}
+
+	expectedErrors := map[string]string{
+		"missingFile":  "file missing.txt not found",
+		"notDirectory": "package.json is not a directory",
+	}
+	for eventSource, str := range expectedErrors {
+		_, _, err = tp.ProcessMessage(message, eventSource)
+		if err == nil || !strings.Contains(err.Error(), str) {
+			t.Errorf("expecting error for %v to contain %v, but got: %v", eventSource, str, err)
+		}
+	}
+	if requests["package.json"] != 2 {
+		t.Errorf("expecting package.json to be read again for another event, but it was read %v times in total", requests["package.json"])
+	}
+}
+
+func gitHubMessage(event string, body map[string]interface{}) map[string]interface{} {
+	body["repository"] = map[string]interface{}{
+		"name":     "app",
+		"owner":    map[string]interface{}{"login": "org"},
+		"html_url": "https://github.com/org/app",
+	}
+	return map[string]interface{}{
+		"header": map[string]interface{}{"X-Github-Event": []interface{}{event}},
+		"body":   body,
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	requests := make(map[string]int)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/repos/org/app/compare/aaa...bbb":
+			fmt.Fprint(w, `{"files": [{"filename": "services/web/index.html"}, {"filename": "services/web/app.js"}]}`)
+		case r.URL.Path == "/repos/org/app/compare/aaa...eee":
+			/* GitHub cuts the list of files of a comparison off at 300 */
+			files := make([]string, 300)
+			for index := range files {
+				files[index] = fmt.Sprintf(`{"filename": "services/web/file%v.js"}`, index)
+			}
+			fmt.Fprintf(w, `{"files": [%v]}`, strings.Join(files, ", "))
+		case r.URL.Path == "/repos/org/app/pulls/7/files" && r.URL.Query().Get("page") == "":
+			w.Header().Set("Link", fmt.Sprintf(`<%v/repos/org/app/pulls/7/files?page=2>; rel="next"`, server.URL))
+			fmt.Fprint(w, `[{"filename": "services/api/main.go"}]`)
+		case r.URL.Path == "/repos/org/app/pulls/7/files":
+			fmt.Fprint(w, `[{"filename": "docs/guide.md"}]`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "Not Found"}`)
+		}
+	}))
+	defer server.Close()
+
+	kubeClient := kubefake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "github", Namespace: "kabanero", Annotations: map[string]string{"tekton.dev/git-0": "https://github.com"}},
+		Data:       map[string][]byte{"username": []byte("user"), "password": []byte("token")},
+	})
+	tp := trigger.NewProcessor(&endpoints.Environment{KubeClient: kubeClient})
+	tp.SetGitHubAPIURL(server.URL)
+	err := tp.Initialize(TRIGGER31)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	truncatedCommits := make([]interface{}, 20)
+	for index := range truncatedCommits {
+		truncatedCommits[index] = map[string]interface{}{"modified": []interface{}{"README.md"}}
+	}
+	tests := []struct {
+		name     string
+		message  map[string]interface{}
+		expected map[string]string
+	}{
+		{"push", gitHubMessage("push", map[string]interface{}{
+			"before": "aaa", "after": "ccc",
+			"commits": []interface{}{
+				map[string]interface{}{"added": []interface{}{"services/api/main.go"}, "modified": []interface{}{"README.md"}},
+				map[string]interface{}{"removed": []interface{}{"shared/util.go"}, "modified": []interface{}{"README.md"}},
+			},
+		}), map[string]string{
+			"files":         "[README.md services/api/main.go shared/util.go]",
+			"apiChanged":    "true",
+			"webChanged":    "true",
+			"docsChanged":   "true",
+			"rootGoChanged": "false",
+		}},
+		{"truncated push", gitHubMessage("push", map[string]interface{}{
+			"before": "aaa", "after": "bbb", "commits": truncatedCommits,
+		}), map[string]string{
+			"files":       "[services/web/app.js services/web/index.html]",
+			"apiChanged":  "false",
+			"webChanged":  "true",
+			"docsChanged": "false",
+		}},
+		{"pull request", gitHubMessage("pull_request", map[string]interface{}{
+			"number":       7.0,
+			"pull_request": map[string]interface{}{"head": map[string]interface{}{"sha": "ddd"}},
+		}), map[string]string{
+			"files":       "[docs/guide.md services/api/main.go]",
+			"apiChanged":  "true",
+			"webChanged":  "false",
+			"docsChanged": "true",
+		}},
+	}
+	for _, test := range tests {
+		variablesArray, _, err := tp.ProcessMessage(test.message, "default")
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		variables := variablesArray[0]
+		for name, value := range test.expected {
+			if str := fmt.Sprintf("%v", variables[name]); str != value {
+				t.Errorf("%v: expecting %v to be %v, but got %v", test.name, name, value, str)
+			}
+		}
+	}
+	if requests["/repos/org/app/compare/aaa...bbb"] != 1 || requests["/repos/org/app/pulls/7/files"] != 2 {
+		t.Errorf("unexpected requests to the GitHub API: %v", requests)
+	}
+
+	_, _, err = tp.ProcessMessage(gitHubMessage("push", map[string]interface{}{
+		"before": "aaa", "after": "eee", "commits": truncatedCommits,
+	}), "default")
+	if err == nil || !strings.Contains(err.Error(), "GitHub returned only the first 300 files changed between aaa and eee") {
+		t.Errorf("expecting error for a comparison cut off by GitHub, but got: %v", err)
+	}
+
+	_, _, err = tp.ProcessMessage(gitHubMessage("issues", map[string]interface{}{}), "invalid")
+	if err == nil || !strings.Contains(err.Error(), "unsupported event") {
+		t.Errorf("expecting error for an issues event, but got: %v", err)
+	}
+}
+
+func TestSCMEvent(t *testing.T) {
+	tp := trigger.NewProcessor(nil)
+	err := tp.Initialize(TRIGGER32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		message  map[string]interface{}
+		expected map[string]string
+	}{
+		{"push", gitHubMessage("push", map[string]interface{}{"ref": "refs/heads/main", "after": "abc"}),
+			map[string]string{"eventType": "push", "ref": "refs/heads/main", "branch": "main", "tag": "", "sha": "abc", "repository": "org/app", "prNumber": "0", "revision": ""}},
+		{"tag", gitHubMessage("push", map[string]interface{}{"ref": "refs/tags/v1.2.3", "after": "abc"}),
+			map[string]string{"eventType": "tag", "branch": "", "tag": "v1.2.3", "sha": "abc"}},
+		{"deleted branch", gitHubMessage("push", map[string]interface{}{"ref": "refs/heads/feature", "after": "0000000000000000000000000000000000000000", "deleted": true}),
+			map[string]string{"eventType": "delete", "event": "push", "branch": "feature", "sha": ""}},
+		{"delete tag", gitHubMessage("delete", map[string]interface{}{"ref": "v1.0.0", "ref_type": "tag"}),
+			map[string]string{"eventType": "delete", "event": "delete", "ref": "refs/tags/v1.0.0", "tag": "v1.0.0", "branch": ""}},
+		{"pull request", gitHubMessage("pull_request", map[string]interface{}{
+			"action": "opened",
+			"number": 7.0,
+			"pull_request": map[string]interface{}{
+				"number": 7.0,
+				"head":   map[string]interface{}{"ref": "feature", "sha": "def"},
+				"base":   map[string]interface{}{"ref": "main"},
+			},
+		}), map[string]string{"eventType": "pull_request", "action": "opened", "branch": "main", "sha": "def", "prNumber": "7", "revision": "refs/pull/7/head"}},
+		{"release", gitHubMessage("release", map[string]interface{}{
+			"action":  "published",
+			"release": map[string]interface{}{"tag_name": "v2.0.0", "name": "Version 2"},
+		}), map[string]string{"eventType": "release", "action": "published", "tag": "v2.0.0", "ref": "refs/tags/v2.0.0", "release": "Version 2"}},
+		{"comment on a pull request", gitHubMessage("issue_comment", map[string]interface{}{
+			"action":  "created",
+			"issue":   map[string]interface{}{"number": 8.0, "pull_request": map[string]interface{}{"url": "https://api.github.com/repos/org/app/pulls/8"}},
+			"comment": map[string]interface{}{"body": "/retest"},
+		}), map[string]string{"eventType": "issue_comment", "comment": "/retest", "prNumber": "8", "revision": "refs/pull/8/head"}},
+		{"other event", gitHubMessage("star", map[string]interface{}{"action": "created"}),
+			map[string]string{"eventType": "star", "action": "created", "repository": "org/app"}},
+	}
+	for _, test := range tests {
+		variablesArray, _, err := tp.ProcessMessage(test.message, "default")
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		variables := variablesArray[0]
+		for name, value := range test.expected {
+			if str := fmt.Sprintf("%v", variables[name]); str != value {
+				t.Errorf("%v: expecting %v to be %v, but got %v", test.name, name, value, str)
+			}
+		}
+	}
+
+	_, _, err = tp.ProcessMessage(map[string]interface{}{"header": map[string]interface{}{}, "body": map[string]interface{}{}}, "default")
+	if err == nil || !strings.Contains(err.Error(), "unsupported message") {
+		t.Errorf("expecting error for a message that is not a GitHub event, but got: %v", err)
+	}
+}
+
+/*
+Run the trigger creating a PipelineRun, and check the commit statuses and check runs reported as the PipelineRun progresses
+through the conditions, one for each expected request, or nil to leave the PipelineRun unchanged
+*/
+func testPipelineRunReport(t *testing.T, dir string, allowCheckRuns bool, conditions []map[string]interface{}, expected []string) {
+	requests := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid request body: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/repos/org/app/statuses/abc":
+			requests <- fmt.Sprintf("status %v %v %v", body["context"], body["state"], body["description"])
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{}`)
+		case r.URL.Path == "/repos/org/app/check-runs" && allowCheckRuns:
+			requests <- fmt.Sprintf("create %v %v %v", body["name"], body["head_sha"], body["status"])
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"id": 42}`)
+		case r.URL.Path == "/repos/org/app/check-runs":
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"message": "You must authenticate via a GitHub App."}`)
+		case r.URL.Path == "/repos/org/app/check-runs/42":
+			requests <- fmt.Sprintf("update %v %v %v", body["name"], body["status"], body["conclusion"])
+			fmt.Fprint(w, `{"id": 42}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "Not Found"}`)
+		}
+	}))
+	defer server.Close()
+
+	kubeClient := kubefake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "github", Namespace: "kabanero", Annotations: map[string]string{"tekton.dev/git-0": "https://github.com"}},
+		Data:       map[string][]byte{"username": []byte("user"), "password": []byte("token")},
+	})
+	dynamicClient := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	tp := trigger.NewProcessor(&endpoints.Environment{KubeClient: kubeClient, DynamicClient: dynamicClient})
+	defer tp.Stop()
+	tp.SetGitHubAPIURL(server.URL)
+	err := tp.Initialize(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	variablesArray, _, err := tp.ProcessMessage(gitHubMessage("push", map[string]interface{}{"ref": "refs/heads/main", "after": "abc"}), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if variablesArray[0]["started"] != true || variablesArray[0]["applied"] != "" {
+		t.Fatalf("unexpected variables: %v", variablesArray[0])
+	}
+
+	/* update the PipelineRun until each state is reported, as the watch of the PipelineRun starts in the background */
+	pipelineRuns := dynamicClient.Resource(schema.GroupVersionResource{Group: "tekton.dev", Version: "v1alpha1", Resource: "pipelineruns"}).Namespace("kabanero")
+	for index, expectedRequest := range expected {
+		deadline := time.After(5 * time.Second)
+		for received := false; !received; {
+			if conditions[index] != nil {
+				pipelineRun, err := pipelineRuns.Get("app-run", metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				pipelineRun.Object["status"] = map[string]interface{}{"conditions": []interface{}{conditions[index]}}
+				if _, err = pipelineRuns.Update(pipelineRun, metav1.UpdateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			select {
+			case request := <-requests:
+				if request != expectedRequest {
+					t.Fatalf("expecting request %q, but got %q", expectedRequest, request)
+				}
+				received = true
+			case <-time.After(100 * time.Millisecond):
+			case <-deadline:
+				t.Fatalf("request %q not received", expectedRequest)
+			}
+		}
+	}
+	select {
+	case request := <-requests:
+		t.Errorf("unexpected request %q", request)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestCommitStatus(t *testing.T) {
+	conditions := []map[string]interface{}{
+		nil,
+		nil,
+		{"type": "Succeeded", "status": "Unknown", "reason": "Running", "message": "Tasks Completed: 0"},
+		{"type": "Succeeded", "status": "True", "reason": "Succeeded", "message": "Tasks Completed: 2"},
+	}
+	testPipelineRunReport(t, TRIGGER33, true, conditions, []string{
+		"status kabanero/build pending Build started",
+		"create app-build-pipeline abc queued",
+		"update app-build-pipeline in_progress <nil>",
+		"update app-build-pipeline completed success",
+	})
+	testPipelineRunReport(t, TRIGGER33, false, conditions, []string{
+		"status kabanero/build pending Build started",
+		"status app-build-pipeline pending PipelineRun app-run created",
+		"status app-build-pipeline pending Tasks Completed: 0",
+		"status app-build-pipeline success Tasks Completed: 2",
+	})
+
+	/* a PipelineRun that does not complete in time has an unknown outcome, which is an error for a commit status */
+	unchanged := []map[string]interface{}{nil, nil, nil}
+	testPipelineRunReport(t, TRIGGER33+"/timeout", true, unchanged, []string{
+		"status kabanero/build pending Build started",
+		"create app-build-pipeline abc queued",
+		"update app-build-pipeline completed neutral",
+	})
+	testPipelineRunReport(t, TRIGGER33+"/timeout", false, unchanged, []string{
+		"status kabanero/build pending Build started",
+		"status app-build-pipeline pending PipelineRun app-run created",
+		"status app-build-pipeline error The status of PipelineRun app-run is unknown: it did not complete in 1s",
+	})
+
+	tp := trigger.NewProcessor(nil)
+	err := tp.Initialize(TRIGGER33)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = tp.ProcessMessage(gitHubMessage("push", map[string]interface{}{"after": "abc"}), "invalid")
+	if err == nil || !strings.Contains(err.Error(), "invalid state") {
+		t.Errorf("expecting error for an invalid state, but got: %v", err)
+	}
+}
@@ -0,0 +1,369 @@
+/*
+Copyright 2020 IBM Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trigger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker"
+	"github.com/google/cel-go/checker/decls"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"k8s.io/klog"
+)
+
+/* typeChecker checks the expressions of a trigger against the types of the variables, without evaluating them */
+type typeChecker struct {
+	p      *Processor
+	types  map[string]*exprpb.Type // types of the variables in scope
+	env    cel.Env                 // environment declaring the variables in scope
+	errors []string
+}
+
+/* Check the triggers of the event sources with a schema. All errors found are returned together. */
+func (p *Processor) checkTriggers() error {
+	eventSources := make([]string, 0, len(p.triggerDef.Schemas))
+	for eventSource := range p.triggerDef.Schemas {
+		eventSources = append(eventSources, eventSource)
+	}
+	sort.Strings(eventSources)
+
+	errors := make([]string, 0)
+	for _, eventSource := range eventSources {
+		for _, trigger := range p.triggerDef.EventTriggers[eventSource] {
+			if klog.V(5) {
+				klog.Infof("type checking trigger at %v", trigger.Position)
+			}
+			tc, err := p.newTypeChecker(trigger.Input, p.inputType(eventSource))
+			if err != nil {
+				return err
+			}
+			tc.checkStatements(trigger.Body)
+			errors = append(errors, tc.errors...)
+		}
+	}
+	if len(errors) > 0 {
+		return fmt.Errorf("type check of triggers failed:\n%v", strings.Join(errors, "\n"))
+	}
+	return nil
+}
+
+/* Create a type checker with the input variable, constants, and parameters in scope */
+func (p *Processor) newTypeChecker(inputVariableName string, inputType *exprpb.Type) (*typeChecker, error) {
+	env, err := p.initializeEmptyCELEnv()
+	if err != nil {
+		return nil, err
+	}
+	variables := make(map[string]interface{})
+	hidden := map[string]bool{inputVariableName: true}
+	if _, err = p.addConstants(env, variables, hidden, ""); err != nil {
+		return nil, err
+	}
+	if _, err = p.addParams(env, variables, hidden); err != nil {
+		return nil, err
+	}
+
+	tc := &typeChecker{p: p, types: make(map[string]*exprpb.Type)}
+	for name, value := range variables {
+		tc.types[name] = celTypeOf(value)
+	}
+	tc.types[inputVariableName] = inputType
+	return tc, tc.declare()
+}
+
+/* Rebuild the environment from the types of the variables */
+func (tc *typeChecker) declare() error {
+	var err error
+	tc.env, err = tc.p.declareTypes(tc.types)
+	return err
+}
+
+func (tc *typeChecker) addError(pos Position, format string, args ...interface{}) {
+	tc.errors = append(tc.errors, fmt.Sprintf("%v: ", pos)+fmt.Sprintf(format, args...))
+}
+
+/* Set the type of a variable, and declare it */
+func (tc *typeChecker) setType(pos Position, name string, varType *exprpb.Type) {
+	tc.types[name] = varType
+	if err := tc.declare(); err != nil {
+		tc.addError(pos, "unable to declare variable %v: %v", name, err)
+	}
+}
+
+/* Check an expression, and return its type, or nil if there are errors */
+func (tc *typeChecker) checkExpression(pos Position, expression string) *exprpb.Type {
+	parsed, issues := tc.env.Parse(expression)
+	if issues != nil && issues.Err() != nil {
+		tc.addError(pos, "parsing error in %v: %v", expression, issues.Err())
+		return nil
+	}
+	checked, issues := tc.env.Check(parsed)
+	if issues != nil && issues.Err() != nil {
+		tc.addError(pos, "CEL check error in %v: %v", expression, issues.Err())
+		return nil
+	}
+	if checkedExpr, err := cel.AstToCheckedExpr(checked); err == nil && tc.p.schemaProvider != nil {
+		tc.checkFields(pos, expression, checkedExpr.Expr, checkedExpr.TypeMap)
+	}
+	return checked.ResultType()
+}
+
+/*
+Report the fields accessed by an expression that are not declared by the closed objects of the schemas. The objects allow
+other fields, so that messages with them are accepted, but accessing a field not declared is likely a typo.
+*/
+func (tc *typeChecker) checkFields(pos Position, expression string, expr *exprpb.Expr, typeMap map[int64]*exprpb.Type) {
+	if expr == nil {
+		return
+	}
+	switch kind := expr.ExprKind.(type) {
+	case *exprpb.Expr_SelectExpr:
+		operand := kind.SelectExpr.Operand
+		if operandType, ok := typeMap[operand.Id]; ok && operandType.GetMessageType() != "" &&
+			!tc.p.schemaProvider.fieldDeclared(operandType.GetMessageType(), kind.SelectExpr.Field) {
+			tc.addError(pos, "CEL check error in %v: undefined field '%v' of %v", expression, kind.SelectExpr.Field, operandType.GetMessageType())
+		}
+		tc.checkFields(pos, expression, operand, typeMap)
+	case *exprpb.Expr_CallExpr:
+		tc.checkFields(pos, expression, kind.CallExpr.Target, typeMap)
+		for _, arg := range kind.CallExpr.Args {
+			tc.checkFields(pos, expression, arg, typeMap)
+		}
+	case *exprpb.Expr_ListExpr:
+		for _, element := range kind.ListExpr.Elements {
+			tc.checkFields(pos, expression, element, typeMap)
+		}
+	case *exprpb.Expr_StructExpr:
+		for _, entry := range kind.StructExpr.Entries {
+			tc.checkFields(pos, expression, entry.GetMapKey(), typeMap)
+			tc.checkFields(pos, expression, entry.Value, typeMap)
+		}
+	case *exprpb.Expr_ComprehensionExpr:
+		comprehension := kind.ComprehensionExpr
+		for _, sub := range []*exprpb.Expr{comprehension.IterRange, comprehension.AccuInit, comprehension.LoopCondition, comprehension.LoopStep, comprehension.Result} {
+			tc.checkFields(pos, expression, sub, typeMap)
+		}
+	}
+}
+
+/* Check an expression whose value must be of the given primitive type */
+func (tc *typeChecker) checkExpressionOf(pos Position, expression string, kind string, primitive *exprpb.Type) {
+	exprType := tc.checkExpression(pos, expression)
+	if exprType == nil || exprType.GetDyn() != nil || exprType.GetWellKnown() == exprpb.Type_ANY {
+		return
+	}
+	if exprType.GetPrimitive() == primitive.GetPrimitive() || exprType.GetWrapper() == primitive.GetPrimitive() {
+		return
+	}
+	tc.addError(pos, "%v %v is of type %v, not %v", kind, expression, checker.FormatCheckedType(exprType), checker.FormatCheckedType(primitive))
+}
+
+/* Check statements. Variables declared by let are removed at the end of the statements. */
+func (tc *typeChecker) checkStatements(statements []Statement) {
+	var locals *localScope
+	localTypes := make(map[string]*exprpb.Type)
+	for _, statement := range statements {
+		stmt, ok := statement.(*Let)
+		if !ok {
+			tc.checkStatement(statement)
+			continue
+		}
+		if locals == nil {
+			locals = newLocalScope()
+		}
+		for _, assignment := range stmt.Assignments {
+			if !locals.declared(assignment.Variable) {
+				locals.names = append(locals.names, assignment.Variable)
+				if previous, exists := tc.types[assignment.Variable]; exists {
+					localTypes[assignment.Variable] = previous
+				}
+			}
+			tc.checkAssignment(assignment)
+		}
+	}
+	if locals == nil {
+		return
+	}
+	for _, name := range locals.names {
+		if previous, ok := localTypes[name]; ok {
+			tc.types[name] = previous
+		} else {
+			delete(tc.types, name)
+		}
+	}
+	if err := tc.declare(); err != nil {
+		tc.addError(statements[0].Pos(), "%v", err)
+	}
+}
+
+func (tc *typeChecker) checkStatement(statement Statement) {
+	switch stmt := statement.(type) {
+	case *Assignment:
+		tc.checkAssignment(stmt)
+	case *If:
+		tc.checkExpressionOf(stmt.Position, stmt.Condition, "condition", decls.Bool)
+		tc.checkStatements(stmt.Body)
+	case *Switch:
+		for _, switchCase := range stmt.Cases {
+			tc.checkStatement(switchCase)
+		}
+		if stmt.Default != nil {
+			tc.checkStatements(stmt.Default.Body)
+		}
+	case *Body:
+		tc.checkStatements(stmt.Body)
+	case *Foreach:
+		tc.checkForeach(stmt)
+	case *Exit:
+		if stmt.Kind == RETURN {
+			tc.checkExpression(stmt.Position, stmt.Expression)
+		} else {
+			tc.checkExpressionOf(stmt.Position, stmt.Expression, "reason", decls.String)
+		}
+	case *Try:
+		tc.checkStatements(stmt.Body)
+		if _, exists := tc.types[stmt.ErrorVariable]; !exists {
+			tc.setType(stmt.Position, stmt.ErrorVariable, decls.NewMapType(decls.String, decls.Any))
+		}
+		tc.checkStatements(stmt.Catch)
+	case *Unset:
+		for _, name := range stmt.Variables {
+			if !strings.Contains(name, ".") {
+				delete(tc.types, name)
+			}
+		}
+		if err := tc.declare(); err != nil {
+			tc.addError(stmt.Position, "%v", err)
+		}
+	}
+}
+
+/* Check an assignment, and set the type of the variable */
+func (tc *typeChecker) checkAssignment(stmt *Assignment) {
+	exprType := tc.checkExpression(stmt.Position, stmt.Expression)
+	path := stmt.Path
+	if path == nil {
+		for _, component := range strings.Split(stmt.Variable, ".") {
+			path = append(path, PathElement{Key: component})
+		}
+	}
+	name := path[0].Key
+	if len(path) == 1 {
+		if exprType == nil {
+			/* avoid reporting the same error again where the variable is used */
+			exprType = decls.Dyn
+		}
+		tc.setType(stmt.Position, name, variableType(exprType))
+		return
+	}
+
+	/* setting an element of a map or list */
+	varType, exists := tc.types[name]
+	if !exists {
+		tc.setType(stmt.Position, name, decls.NewMapType(decls.String, decls.Any))
+	} else if !tc.pathDeclared(varType, path[1:]) {
+		/* the variable no longer matches its schema */
+		tc.setType(stmt.Position, name, decls.NewMapType(decls.String, decls.Any))
+	}
+}
+
+/* Return whether the path is declared within a type. Paths within maps, lists, or open objects are always declared. */
+func (tc *typeChecker) pathDeclared(varType *exprpb.Type, path []PathElement) bool {
+	for _, element := range path {
+		switch {
+		case varType.GetMessageType() != "":
+			fieldType, ok := tc.p.schemaProvider.FindFieldType(varType.GetMessageType(), element.Key)
+			if element.Key == "" || !ok || !tc.p.schemaProvider.fieldDeclared(varType.GetMessageType(), element.Key) {
+				return false
+			}
+			varType = fieldType.Type
+		case varType.GetMapType() != nil:
+			varType = varType.GetMapType().ValueType
+		case varType.GetListType() != nil:
+			varType = varType.GetListType().ElemType
+		default:
+			return true
+		}
+	}
+	return true
+}
+
+/* Check a foreach statement. Variables set in the body are not visible after the loop. */
+func (tc *typeChecker) checkForeach(stmt *Foreach) {
+	exprType := tc.checkExpression(stmt.Position, stmt.Expression)
+	itemType, indexType := decls.Dyn, decls.Dyn
+	if exprType != nil {
+		switch {
+		case exprType.GetListType() != nil:
+			itemType, indexType = exprType.GetListType().ElemType, decls.Int
+		case exprType.GetMapType() != nil:
+			itemType, indexType = exprType.GetMapType().ValueType, exprType.GetMapType().KeyType
+		case exprType.GetPrimitive() != exprpb.Type_PRIMITIVE_TYPE_UNSPECIFIED || exprType.GetWrapper() != exprpb.Type_PRIMITIVE_TYPE_UNSPECIFIED:
+			tc.addError(stmt.Position, "foreach over %v of type %v, not a list or map", stmt.Expression, checker.FormatCheckedType(exprType))
+		}
+	}
+
+	saved := make(map[string]*exprpb.Type)
+	for name, varType := range tc.types {
+		saved[name] = varType
+	}
+	tc.setType(stmt.Position, stmt.Item, variableType(itemType))
+	if stmt.Index != "" {
+		tc.setType(stmt.Position, stmt.Index, variableType(indexType))
+	}
+	tc.checkStatements(stmt.Body)
+	collectType := decls.Dyn
+	if stmt.Collect != "" {
+		if collectType = tc.checkExpression(stmt.Position, stmt.Collect); collectType == nil {
+			collectType = decls.Dyn
+		}
+	}
+	tc.types = saved
+	if stmt.Into != "" {
+		tc.setType(stmt.Position, stmt.Into, variableType(decls.NewListType(collectType)))
+	} else if err := tc.declare(); err != nil {
+		tc.addError(stmt.Position, "%v", err)
+	}
+}
+
+/*
+Return the type of a variable set to a value of the given type. As when evaluating, variables holding lists
+
+	and maps may be changed to hold any values. Values that may be null have no declared type.
+*/
+func variableType(valueType *exprpb.Type) *exprpb.Type {
+	switch {
+	case valueType.GetPrimitive() != exprpb.Type_PRIMITIVE_TYPE_UNSPECIFIED, valueType.GetMessageType() != "":
+		return valueType
+	case valueType.GetListType() != nil:
+		return decls.NewListType(elementType(valueType.GetListType().ElemType))
+	case valueType.GetMapType() != nil:
+		return decls.NewMapType(decls.String, elementType(valueType.GetMapType().ValueType))
+	default:
+		return decls.Dyn
+	}
+}
+
+/* Return the type of the elements of a list or map variable. Only objects from schemas keep their type. */
+func elementType(valueType *exprpb.Type) *exprpb.Type {
+	if valueType.GetMessageType() != "" {
+		return valueType
+	}
+	return decls.Any
+}
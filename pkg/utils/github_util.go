@@ -23,6 +23,7 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog"
 	"net/http"
+	"time"
 )
 
 /* Get the repository's information from from github message body: name, owner, html_url, and ref */
@@ -115,113 +116,235 @@ func getRepositoryInfo(body map[string]interface{}, repositoryEvent string) (str
 	return owner, name, htmlURL, ref, nil
 }
 
-/*
-DownloadYAML Downloads a YAML file from a git repository.
-  header: HTTP header from webhook
-  bodyMap: HTTP  message body from webhook
-*/
-func DownloadYAML(kubeClient *kubernetes.Clientset, header map[string][]string, bodyMap map[string]interface{}, fileName string) (map[string]interface{}, bool, error) {
+// GitHubRepository identifies the repository and commit of a GitHub webhook message
+type GitHubRepository struct {
+	Owner   string
+	Name    string
+	HTMLURL string
+	Ref     string // commit of the event, or empty for the default branch
+	APIURL  string // URL of the GitHub API of the host of the repository, ending with /
+}
 
-	hostHeader, isEnterprise := header[http.CanonicalHeaderKey("x-github-enterprise-host")]
-	var host string
-	if !isEnterprise {
-		host = "github.com"
-	} else {
-		host = hostHeader[0]
+// GetGitHubRepository returns the repository and commit of a GitHub webhook message, given its header and body
+func GetGitHubRepository(header map[string][]string, bodyMap map[string]interface{}) (*GitHubRepository, error) {
+	events := header[http.CanonicalHeaderKey("x-github-event")]
+	if len(events) == 0 {
+		return nil, fmt.Errorf("webhook message has no X-Github-Event header")
 	}
-
-	repositoryEvent := header["X-Github-Event"][0]
-
-	owner, name, htmlURL, ref, err := getRepositoryInfo(bodyMap, repositoryEvent)
+	owner, name, htmlURL, ref, err := getRepositoryInfo(bodyMap, events[0])
 	if err != nil {
-		return nil, false, fmt.Errorf("unable to get repository owner, name, or html_url from webhook message: %v", err)
+		return nil, fmt.Errorf("unable to get repository owner, name, or html_url from webhook message: %v", err)
 	}
+	apiURL := "https://api.github.com/"
+	if hostHeader := header[http.CanonicalHeaderKey("x-github-enterprise-host")]; len(hostHeader) > 0 {
+		apiURL = "https://" + hostHeader[0] + "/api/v3/"
+	}
+	return &GitHubRepository{Owner: owner, Name: name, HTMLURL: htmlURL, Ref: ref, APIURL: apiURL}, nil
+}
 
-	namespace := GetKabaneroNamespace()
-	user, token, err := GetGitHubSecret(kubeClient, namespace, htmlURL)
+// NewGitHubClient creates a client of the GitHub API of the repository, authenticated with the user and token of the
+// secret for the repository in the Kabanero namespace
+func NewGitHubClient(kubeClient kubernetes.Interface, repo *GitHubRepository) (*github.Client, error) {
+	user, token, err := GetGitHubSecret(kubeClient, GetKabaneroNamespace(), repo.HTMLURL)
 	if err != nil {
-		return nil, false, fmt.Errorf("unable to get user/token secret for URL %s: %v", htmlURL, err)
+		return nil, fmt.Errorf("unable to get user/token secret for URL %s: %v", repo.HTMLURL, err)
 	}
+	tp := github.BasicAuthTransport{
+		Username: user,
+		Password: token,
+	}
+	return github.NewEnterpriseClient(repo.APIURL, repo.APIURL, tp.Client())
+}
 
-	githubURL := "https://" + host
-
-	bytes, found, err := DownloadFileFromGithub(owner, name, fileName, ref, githubURL, user, token, isEnterprise)
-	if err != nil {
-		return nil, found, err
+// GetGitHubContents returns the file or the entries of the directory at the path of the repository, at the commit of
+// the event. Return: file, directory entries, true if the path exists, and any error
+func GetGitHubContents(ctx context.Context, client *github.Client, repo *GitHubRepository, path string) (*github.RepositoryContent, []*github.RepositoryContent, bool, error) {
+	if klog.V(5) {
+		klog.Infof("GetGitHubContents %v/%v %v at %v", repo.Owner, repo.Name, path, repo.Ref)
+	}
+	var options *github.RepositoryContentGetOptions
+	if repo.Ref != "" {
+		options = &github.RepositoryContentGetOptions{Ref: repo.Ref}
+	}
+	fileContent, directoryContent, resp, err := client.Repositories.GetContents(ctx, repo.Owner, repo.Name, path, options)
+	if resp == nil {
+		/* no response, e.g. because the context is done */
+		return nil, nil, false, fmt.Errorf("unable to get %v/%v/%v: %v", repo.Owner, repo.Name, path, err)
+	}
+	switch resp.Response.StatusCode {
+	case http.StatusOK:
+		return fileContent, directoryContent, true, err
+	case http.StatusBadRequest, http.StatusNotFound:
+		/* does not exist */
+		return nil, nil, false, nil
+	default:
+		return nil, nil, false, fmt.Errorf("unable to get %v/%v/%v, http error %v", repo.Owner, repo.Name, path, resp.Response.Status)
 	}
-	retMap, err := YAMLToMap(bytes)
-	return retMap, found, err
 }
 
 // DownloadFileFromGithub Downloads a file and returns: bytes of the file, true if file exists, and any error
 func DownloadFileFromGithub(owner, repository, fileName, ref, githubURL, user, token string, isEnterprise bool) ([]byte, bool, error) {
+	return DownloadFileFromGithubContext(context.Background(), owner, repository, fileName, ref, githubURL, user, token, isEnterprise)
+}
 
+// DownloadFileFromGithubContext is DownloadFileFromGithub, within the time allowed by the context
+func DownloadFileFromGithubContext(ctx context.Context, owner, repository, fileName, ref, githubURL, user, token string, isEnterprise bool) ([]byte, bool, error) {
 	if klog.V(5) {
 		klog.Infof("downloadFileFromGithub %v, %v, %v, %v, %v, %v, %v", owner, repository, fileName, ref, githubURL, user, isEnterprise)
 	}
-
-	ctx := context.Background()
-
+	repo := &GitHubRepository{Owner: owner, Name: repository, Ref: ref, APIURL: "https://api.github.com/"}
+	if isEnterprise {
+		repo.APIURL = githubURL + "/api/v3/"
+	}
 	tp := github.BasicAuthTransport{
 		Username: user,
 		Password: token,
 	}
-	/*
-		tokenService := oauth2.StaticTokenSource(
-			&oauth2.Token{AccessToken: token},
-		)
-		tokenClient := oauth2.NewClient(ctx, tokenService)
-	*/
+	client, err := github.NewEnterpriseClient(repo.APIURL, repo.APIURL, tp.Client())
+	if err != nil {
+		return nil, false, err
+	}
+	fileContent, _, found, err := GetGitHubContents(ctx, client, repo, fileName)
+	if err != nil || !found {
+		return nil, found, err
+	}
+	if fileContent == nil {
+		return nil, false, fmt.Errorf("unable to download %v/%v/%v: not a file", owner, repository, fileName)
+	}
+	content, err := fileContent.GetContent()
+	return []byte(content), true, err
+}
 
-	var err error
-	var client *github.Client
-	if isEnterprise {
-		githubURL = githubURL + "/api/v3"
-		client, err = github.NewEnterpriseClient(githubURL, githubURL, tp.Client())
+/*
+DownloadYAML Downloads a YAML file from a git repository.
+  header: HTTP header from webhook
+  bodyMap: HTTP  message body from webhook
+*/
+func DownloadYAML(kubeClient *kubernetes.Clientset, header map[string][]string, bodyMap map[string]interface{}, fileName string) (map[string]interface{}, bool, error) {
+	return DownloadYAMLContext(context.Background(), kubeClient, header, bodyMap, fileName)
+}
+
+// DownloadYAMLContext is DownloadYAML, within the time allowed by the context
+func DownloadYAMLContext(ctx context.Context, kubeClient kubernetes.Interface, header map[string][]string, bodyMap map[string]interface{}, fileName string) (map[string]interface{}, bool, error) {
+	repo, err := GetGitHubRepository(header, bodyMap)
+	if err != nil {
+		return nil, false, err
+	}
+	client, err := NewGitHubClient(kubeClient, repo)
+	if err != nil {
+		return nil, false, err
+	}
+	fileContent, _, found, err := GetGitHubContents(ctx, client, repo, fileName)
+	if err != nil || !found {
+		return nil, found, err
+	}
+	if fileContent == nil {
+		return nil, false, fmt.Errorf("unable to download %v/%v/%v: not a file", repo.Owner, repo.Name, fileName)
+	}
+	content, err := fileContent.GetContent()
+	if err != nil {
+		return nil, true, err
+	}
+	retMap, err := YAMLToMap([]byte(content))
+	return retMap, true, err
+}
+
+/* maximum numbers of files GitHub returns for a comparison of commits, and for a pull request */
+const (
+	maxComparisonFiles  = 300
+	maxPullRequestFiles = 3000
+)
+
+// GetGitHubComparisonFiles returns the paths of the files changed between two commits of the repository, and true if the
+// list may be cut off, as GitHub returns at most 300 files.
+func GetGitHubComparisonFiles(ctx context.Context, client *github.Client, repo *GitHubRepository, base, head string) ([]string, bool, error) {
+	comparison, _, err := client.Repositories.CompareCommits(ctx, repo.Owner, repo.Name, base, head)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to compare %v...%v of %v/%v: %v", base, head, repo.Owner, repo.Name, err)
+	}
+	files := make([]string, 0, len(comparison.Files))
+	for _, file := range comparison.Files {
+		files = append(files, file.GetFilename())
+	}
+	return files, len(files) >= maxComparisonFiles, nil
+}
+
+// GetGitHubPullRequestFiles returns the paths of the files changed by a pull request of the repository, and true if the
+// list may be cut off, as GitHub returns at most 3000 files.
+func GetGitHubPullRequestFiles(ctx context.Context, client *github.Client, repo *GitHubRepository, number int) ([]string, bool, error) {
+	files := make([]string, 0)
+	options := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := client.PullRequests.ListFiles(ctx, repo.Owner, repo.Name, number, options)
 		if err != nil {
-			return nil, false, err
+			return nil, false, fmt.Errorf("unable to list the files of pull request %v of %v/%v: %v", number, repo.Owner, repo.Name, err)
+		}
+		for _, file := range page {
+			files = append(files, file.GetFilename())
 		}
-	} else {
-		client = github.NewClient(tp.Client())
+		if resp.NextPage == 0 {
+			return files, len(files) >= maxPullRequestFiles, nil
+		}
+		options.Page = resp.NextPage
+	}
+}
+
+// SetGitHubCommitStatus sets the status of a commit of the repository. The state is pending, success, failure, or error,
+// and the context distinguishes the status from those set by other systems.
+func SetGitHubCommitStatus(ctx context.Context, client *github.Client, repo *GitHubRepository, sha string, state string, statusContext string, description string, targetURL string) error {
+	if klog.V(5) {
+		klog.Infof("SetGitHubCommitStatus %v/%v %v: %v %v", repo.Owner, repo.Name, sha, statusContext, state)
 	}
+	status := &github.RepoStatus{State: &state, Context: &statusContext}
+	if description != "" {
+		status.Description = &description
+	}
+	if targetURL != "" {
+		status.TargetURL = &targetURL
+	}
+	_, _, err := client.Repositories.CreateStatus(ctx, repo.Owner, repo.Name, sha, status)
+	if err != nil {
+		return fmt.Errorf("unable to set status %v of commit %v of %v/%v: %v", statusContext, sha, repo.Owner, repo.Name, err)
+	}
+	return nil
+}
 
-	var options *github.RepositoryContentGetOptions
-	if ref != "" {
-		options = &github.RepositoryContentGetOptions{Ref: ref}
-	}
-
-	/*
-	       rc, err := client.Repositories.DownloadContents(ctx, owner, repository, fileName, options)
-	       if err != nil {
-	   		fmt.Printf("Error type: %T, value: %v\n", err, err)
-	           return nil, false, err
-	       }
-	       defer rc.Close()
-	   	buf, err := ioutil.ReadAll(rc)
-	*/
-	fileContent, _, resp, err := client.Repositories.GetContents(ctx, owner, repository, fileName, options)
-	if resp.Response.StatusCode == 200 {
-		if fileContent != nil {
-			if fileContent.Content == nil {
-				return nil, true, fmt.Errorf("content for %v/%v/%v is nil", owner, repository, fileName)
-			}
-
-			content, err := fileContent.GetContent()
-			if err != nil {
-				klog.Infof("download File Form Github error %v", err)
-			} else {
-				klog.Infof("download File from Github: buffer %v", content)
-			}
-			return []byte(content), true, err
-		}
-		/* some other errors */
-		return nil, false, fmt.Errorf("unable to download %v/%v/%v: not a file", owner, repository, fileName)
-	} else if resp.Response.StatusCode == 400 {
-		/* does not exist */
-		return nil, false, nil
-	} else {
-		/* some other errors */
-		return nil, false, fmt.Errorf("unable to download %v/%v/%v, http error %v", owner, repository, fileName, resp.Response.Status)
+// CreateGitHubCheckRun creates a queued check run for a commit of the repository, and returns its ID. GitHub only
+// allows GitHub Apps to create check runs.
+func CreateGitHubCheckRun(ctx context.Context, client *github.Client, repo *GitHubRepository, name string, sha string, externalID string) (int64, error) {
+	if klog.V(5) {
+		klog.Infof("CreateGitHubCheckRun %v/%v %v: %v", repo.Owner, repo.Name, sha, name)
+	}
+	status := "queued"
+	checkRun, _, err := client.Checks.CreateCheckRun(ctx, repo.Owner, repo.Name, github.CreateCheckRunOptions{
+		Name:       name,
+		HeadSHA:    sha,
+		ExternalID: &externalID,
+		Status:     &status,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to create check run %v for commit %v of %v/%v: %v", name, sha, repo.Owner, repo.Name, err)
 	}
+	return checkRun.GetID(), nil
+}
 
+// UpdateGitHubCheckRun updates the status of a check run of the repository. The status is queued, in_progress, or
+// completed. The conclusion of a completed check run is success, failure, neutral, cancelled, or timed_out.
+func UpdateGitHubCheckRun(ctx context.Context, client *github.Client, repo *GitHubRepository, id int64, name string, status string, conclusion string, summary string) error {
+	if klog.V(5) {
+		klog.Infof("UpdateGitHubCheckRun %v/%v %v: %v %v %v", repo.Owner, repo.Name, id, name, status, conclusion)
+	}
+	options := github.UpdateCheckRunOptions{Name: name, Status: &status}
+	if conclusion != "" {
+		options.Conclusion = &conclusion
+		options.CompletedAt = &github.Timestamp{Time: time.Now()}
+	}
+	if summary != "" {
+		options.Output = &github.CheckRunOutput{Title: &name, Summary: &summary}
+	}
+	_, _, err := client.Checks.UpdateCheckRun(ctx, repo.Owner, repo.Name, id, options)
+	if err != nil {
+		return fmt.Errorf("unable to update check run %v of %v/%v: %v", name, repo.Owner, repo.Name, err)
+	}
+	return nil
 }
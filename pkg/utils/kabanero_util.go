@@ -135,7 +135,7 @@ GetGitHubSecret will return the username and token of a secret whose annotation'
 Note that a secret with the `kabanero.io/git-*` annotation is preferred over one with `tekton.dev/git-*`.
 Return: username, token, error
 */
-func GetGitHubSecret(client *kubernetes.Clientset, namespace string, repoURL string) (string, string, error) {
+func GetGitHubSecret(client kubernetes.Interface, namespace string, repoURL string) (string, string, error) {
 	// TODO: Change to controller pattern and cache the secrets.
 	if klog.V(8) {
 		klog.Infof("GetGitHubSecret namespace: %s, repoURL: %s", namespace, repoURL)
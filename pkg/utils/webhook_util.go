@@ -155,7 +155,7 @@ func DownloadTrigger(triggerURL, triggerChkSum, dir string, verifyChkSum bool) e
 		}
 
 		if chkSum != triggerChkSum {
-			klog.Fatalf("trigger collection checksum does not match the checksum from the Kabanero index: found: %s, expected: %s",
+			return fmt.Errorf("trigger collection checksum does not match the expected checksum: found: %s, expected: %s",
 				chkSum, triggerChkSum)
 		}
 	}
@@ -165,6 +165,7 @@ func DownloadTrigger(triggerURL, triggerChkSum, dir string, verifyChkSum bool) e
 	if err != nil {
 		return err
 	}
+	defer triggerReadCloser.Close()
 
 	err = DecompressGzipTar(triggerReadCloser, dir)
 	return err